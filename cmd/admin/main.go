@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+
+	"github.com/dexciuq/yummy-express-backend/internal/catalog"
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+	"github.com/dexciuq/yummy-express-backend/internal/storage"
+)
+
+const usage = `Usage: admin [-db-dsn DSN] [-storage local|s3 ...] <command> [arguments]
+
+Commands:
+  keys list                         list signing keys
+  keys generate [-alg EdDSA|RS256]  generate a new signing key
+  keys retire <kid>                 stop signing and accepting tokens with a key
+  import [-dry-run] <file.csv>      import the catalog from a CSV file
+  purge [-dry-run] [-retention D]   delete records archived for longer than D
+                                    and the image files of purged products
+
+The storage flags only matter for purge and default to the same environment
+variables as the API server.
+`
+
+func main() {
+	godotenv.Load()
+
+	var dsn string
+	var cfg storageConfig
+	flag.StringVar(&dsn, "db-dsn", getDSN(), "PostgreSQL DSN")
+	flag.StringVar(&cfg.backend, "storage", getString("STORAGE", "local"), "Storage backend for uploads (local|s3)")
+	flag.StringVar(&cfg.dir, "storage-dir", getString("STORAGE_DIR", "./uploads"), "Directory of the local storage backend")
+	flag.StringVar(&cfg.s3.endpoint, "s3-endpoint", os.Getenv("S3_ENDPOINT"), "S3 endpoint URL")
+	flag.StringVar(&cfg.s3.region, "s3-region", getString("S3_REGION", "us-east-1"), "S3 region")
+	flag.StringVar(&cfg.s3.bucket, "s3-bucket", os.Getenv("S3_BUCKET"), "S3 bucket")
+	flag.StringVar(&cfg.s3.accessKey, "s3-access-key", os.Getenv("S3_ACCESS_KEY"), "S3 access key")
+	flag.StringVar(&cfg.s3.secretKey, "s3-secret-key", os.Getenv("S3_SECRET_KEY"), "S3 secret key")
+	flag.BoolVar(&cfg.s3.pathStyle, "s3-path-style", os.Getenv("S3_PATH_STYLE") == "true", "Address the bucket by path, as MinIO needs")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	models := data.NewModels(db)
+
+	switch flag.Arg(0) {
+	case "keys":
+		err = keysCommand(models, flag.Args()[1:])
+	case "import":
+		err = importCommand(models, flag.Args()[1:])
+	case "purge":
+		var store storage.Storage
+		store, err = openStorage(cfg)
+		if err == nil {
+			err = purgeCommand(models, store, flag.Args()[1:])
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func keysCommand(models data.Models, args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		keys, err := models.Keys.Model.GetAll()
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KID\tALGORITHM\tCREATED\tRETIRED")
+		for _, key := range keys {
+			retired := "-"
+			if key.RetiredAt != nil {
+				retired = key.RetiredAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key.KID, key.Algorithm, key.CreatedAt.Format(time.RFC3339), retired)
+		}
+		return tw.Flush()
+
+	case "generate":
+		fs := flag.NewFlagSet("keys generate", flag.ExitOnError)
+		algorithm := fs.String("alg", data.AlgorithmEdDSA, "Signing algorithm (EdDSA|RS256)")
+		fs.Parse(args[1:])
+
+		key, err := data.GenerateSigningKey(*algorithm)
+		if err != nil {
+			return err
+		}
+		if err = models.Keys.Model.Insert(key); err != nil {
+			return err
+		}
+
+		fmt.Printf("generated %s key %s\n", key.Algorithm, key.KID)
+		return nil
+
+	case "retire":
+		if len(args) < 2 {
+			return errors.New("keys retire: kid is required")
+		}
+
+		active, err := models.Keys.Model.GetActive()
+		if err != nil {
+			return err
+		}
+		if len(active) == 1 && active[0].KID == args[1] {
+			return errors.New("keys retire: refusing to retire the only active key, generate a new one first")
+		}
+
+		err = models.Keys.Model.Retire(args[1])
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return fmt.Errorf("keys retire: no active key %q", args[1])
+			}
+			return err
+		}
+
+		fmt.Printf("retired key %s\n", args[1])
+		return nil
+	}
+
+	flag.Usage()
+	os.Exit(2)
+	return nil
+}
+
+// importCommand imports a CSV catalog file and prints the report. It fails if
+// any row has errors, in which case nothing is saved.
+func importCommand(models data.Models, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Check the file and report what would change without saving")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: import [-dry-run] FILE.csv")
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rows, err := catalog.ReadCSV(file)
+	if err != nil {
+		return err
+	}
+
+	report, err := models.Products.Import(rows, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	err = enc.Encode(report)
+	if err != nil {
+		return err
+	}
+
+	if !report.Valid() {
+		return fmt.Errorf("%d of %d rows have errors, nothing was saved", len(report.Errors), report.Rows)
+	}
+	return nil
+}
+
+// purgeCommand deletes the records archived for longer than the retention
+// window that nothing refers to anymore, with the image files of the purged
+// products, and prints the report.
+func purgeCommand(models data.Models, store storage.Storage, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	retention := fs.Duration("retention", 90*24*time.Hour, "How long archived records are kept")
+	dryRun := fs.Bool("dry-run", false, "Report what would be purged without deleting anything")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 || *retention < 0 {
+		return errors.New("usage: purge [-dry-run] [-retention DURATION]")
+	}
+
+	report, err := models.Archive.Purge(time.Now().Add(-*retention), *dryRun)
+	if err != nil {
+		return err
+	}
+
+	// The rows are gone already, so a file that fails to delete is only
+	// left orphaned.
+	for _, key := range report.StorageKeys {
+		err := store.Delete(context.Background(), key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "deleting %s: %v\n", key, err)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	return enc.Encode(report)
+}
+
+// storageConfig holds the settings of the upload storage the API server
+// writes to. URLs are never built here, so only what locates the files is
+// needed.
+type storageConfig struct {
+	backend string
+	dir     string
+	s3      struct {
+		endpoint  string
+		region    string
+		bucket    string
+		accessKey string
+		secretKey string
+		pathStyle bool
+	}
+}
+
+func openStorage(cfg storageConfig) (storage.Storage, error) {
+	switch cfg.backend {
+	case "local":
+		return storage.NewLocal(cfg.dir, ""), nil
+	case "s3":
+		s3 := cfg.s3
+		if s3.endpoint == "" || s3.bucket == "" {
+			return nil, errors.New("s3 storage needs an endpoint and a bucket")
+		}
+		return storage.NewS3(s3.endpoint, s3.region, s3.bucket, s3.accessKey, s3.secretKey, s3.pathStyle, ""), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.backend)
+	}
+}
+
+func getDSN() string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_PORT"),
+		os.Getenv("DB_NAME"))
+}
+
+func getString(key, defaultValue string) string {
+	s := os.Getenv(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// archivePermissions maps the kinds of archived records to the permission
+// needed to list and restore them, which is the one needed to delete them.
+var archivePermissions = map[string]string{
+	"products":         data.PermissionProductsWrite,
+	"product_variants": data.PermissionProductsWrite,
+	"categories":       data.PermissionCatalogWrite,
+	"brands":           data.PermissionCatalogWrite,
+	"units":            data.PermissionCatalogWrite,
+	"countries":        data.PermissionCatalogWrite,
+	"discounts":        data.PermissionDiscountsWrite,
+	"users":            data.PermissionUsersAdmin,
+}
+
+// readArchiveKind returns the kind of archived records the request is about,
+// if the user may manage them.
+func (app *application) readArchiveKind(w http.ResponseWriter, r *http.Request) (string, bool) {
+	kind := httprouter.ParamsFromContext(r.Context()).ByName("kind")
+
+	permission, ok := archivePermissions[kind]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return "", false
+	}
+
+	if !app.contextGetPermissions(r).Include(permission) {
+		app.NotEnoughPermissionResponse(w, r)
+		return "", false
+	}
+	return kind, true
+}
+
+// archiveSortSafelist holds the sort values an archive listing accepts.
+var archiveSortSafelist = []string{"id", "name", "deleted_at", "-id", "-name", "-deleted_at"}
+
+func (app *application) listArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	kind, ok := app.readArchiveKind(w, r)
+	if !ok {
+		return
+	}
+
+	filters := app.readFilters(r.URL.Query(), 100, "-deleted_at", archiveSortSafelist)
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	records, metadata, err := app.models.Archive.GetAll(kind, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{kind: records, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreArchivedHandler(w http.ResponseWriter, r *http.Request) {
+	kind, ok := app.readArchiveKind(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Archive.Restore(kind, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrRestoreConflict):
+			app.restoreConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "record successfully restored"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+)
+
+func (app *application) addAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code       string   `json:"code"`
+		Name       string   `json:"name"`
+		Type       string   `json:"type"`
+		Options    []string `json:"options"`
+		Unit       string   `json:"unit"`
+		Filterable bool     `json:"filterable"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	attribute := &data.Attribute{
+		Code:       input.Code,
+		Name:       input.Name,
+		Type:       input.Type,
+		Options:    input.Options,
+		Unit:       input.Unit,
+		Filterable: input.Filterable,
+	}
+
+	v := validator.New()
+	if data.ValidateAttribute(v, attribute); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Attributes.Insert(attribute)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateAttributeCode):
+			v.AddError("code", "an attribute with this code already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"attribute": attribute}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	attributes, err := app.models.Attributes.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"attributes": attributes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	attribute, err := app.models.Attributes.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"attribute": attribute}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	attribute, err := app.models.Attributes.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Code       *string  `json:"code"`
+		Name       *string  `json:"name"`
+		Type       *string  `json:"type"`
+		Options    []string `json:"options"`
+		Unit       *string  `json:"unit"`
+		Filterable *bool    `json:"filterable"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Code != nil {
+		attribute.Code = *input.Code
+	}
+
+	if input.Name != nil {
+		attribute.Name = *input.Name
+	}
+
+	if input.Options != nil {
+		attribute.Options = input.Options
+	}
+
+	if input.Unit != nil {
+		attribute.Unit = *input.Unit
+	}
+
+	if input.Filterable != nil {
+		attribute.Filterable = *input.Filterable
+	}
+
+	v := validator.New()
+	v.Check(input.Type == nil || *input.Type == attribute.Type, "type", "can not be changed")
+	if data.ValidateAttribute(v, attribute); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Attributes.Update(attribute)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateAttributeCode):
+			v.AddError("code", "an attribute with this code already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"attribute": attribute}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Attributes.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "attribute successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setProductAttributesHandler replaces the attribute values of a product
+// with the ones given by attribute code. A null value removes it.
+func (app *application) setProductAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if product.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.ifMatch(r, product.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Attributes map[string]any `json:"attributes"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	attributes, err := app.models.Attributes.GetAllByCode()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Attributes != nil, "attributes", "must be provided")
+
+	values := []*data.ProductAttribute{}
+	for code, value := range input.Attributes {
+		attribute, ok := attributes[code]
+		if !ok {
+			v.AddError("attributes."+code, "must be an existing attribute")
+			continue
+		}
+		if value == nil {
+			continue
+		}
+
+		data.ValidateProductAttribute(v, attribute, value)
+		values = append(values, &data.ProductAttribute{
+			AttributeID: attribute.ID,
+			Code:        attribute.Code,
+			Name:        attribute.Name,
+			Type:        attribute.Type,
+			Unit:        attribute.Unit,
+			Value:       value,
+		})
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Attributes.SetProductAttributes(product, values)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i].Name < values[j].Name })
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"attributes": values}, app.etagHeader(product.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAttributeFilters reads the attribute filters of a product listing from
+// the query string: attr.<code>=a,b selects any of the values and
+// attr.<code>.min and attr.<code>.max a range of numbers.
+func (app *application) readAttributeFilters(qs url.Values) []data.AttributeFilter {
+	byCode := map[string]*data.AttributeFilter{}
+
+	for key := range qs {
+		name, ok := strings.CutPrefix(key, "attr.")
+		if !ok || qs.Get(key) == "" {
+			continue
+		}
+
+		code, bound, _ := strings.Cut(name, ".")
+		if !validator.In(bound, "", "min", "max") {
+			continue
+		}
+
+		f, ok := byCode[code]
+		if !ok {
+			f = &data.AttributeFilter{Code: code}
+			byCode[code] = f
+		}
+
+		switch bound {
+		case "":
+			f.Values = app.readCSV(qs, key, nil)
+		case "min":
+			f.Min = app.readFloat(qs, key)
+		case "max":
+			f.Max = app.readFloat(qs, key)
+		}
+	}
+
+	filters := make([]data.AttributeFilter, 0, len(byCode))
+	for _, f := range byCode {
+		filters = append(filters, *f)
+	}
+	sort.Slice(filters, func(i, j int) bool { return filters[i].Code < filters[j].Code })
+	return filters
+}
+
+// readFloat returns the number in the query string, or nil if there is none.
+func (app *application) readFloat(qs url.Values, key string) *float64 {
+	f, err := strconv.ParseFloat(qs.Get(key), 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// validateAttributeFilters checks the attribute filters of a product listing
+// against the filterable attributes, which are only loaded if there are any.
+func (app *application) validateAttributeFilters(v *validator.Validator, filter data.ProductFilter) error {
+	if len(filter.Attributes) == 0 {
+		return nil
+	}
+
+	attributes, err := app.models.Attributes.GetAllFilterable()
+	if err != nil {
+		return err
+	}
+
+	data.ValidateAttributeFilters(v, filter.Attributes, attributes)
+	return nil
+}
@@ -44,12 +44,20 @@ func (app *application) addBrandHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *application) listBrandsHandler(w http.ResponseWriter, r *http.Request) {
-	brands, err := app.models.Brands.GetAll()
+	filters := app.readFilters(r.URL.Query(), 100, "id", referenceSortSafelist)
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	brands, metadata, err := app.models.Brands.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"brands": brands}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"brands": brands, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -72,6 +80,12 @@ func (app *application) showBrandHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Archived records only resolve for the orders that refer to them.
+	if brand.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"brand": brand}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -151,7 +165,7 @@ func (app *application) deleteBrandHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "brand successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "brand successfully archived"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
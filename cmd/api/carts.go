@@ -0,0 +1,347 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+)
+
+const cartTokenHeader = "X-Cart-Token"
+
+type cartLine struct {
+	ID int64 `json:"id"`
+	*data.PricedItem
+}
+
+type cartView struct {
+	ID    int64      `json:"id"`
+	Items []cartLine `json:"items"`
+	Total int64      `json:"total"`
+}
+
+// resolveCart finds the cart of the request: the user's cart for requests with
+// a valid access token, otherwise the guest cart named by the X-Cart-Token
+// header. If there is no cart yet and create is set, a new one is made.
+func (app *application) resolveCart(r *http.Request, create bool) (*data.Cart, error) {
+	if userID := app.optionalUserID(r); userID != 0 {
+		return app.models.Carts.GetOrCreateForUser(userID)
+	}
+
+	cart, err := app.models.Carts.GetByToken(r.Header.Get(cartTokenHeader))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound) && create:
+			return app.models.Carts.CreateGuest()
+		default:
+			return nil, err
+		}
+	}
+	return cart, nil
+}
+
+// renderCart prices every item of the cart against the current discounts.
+func (app *application) renderCart(cart *data.Cart) (*cartView, error) {
+	items, err := app.models.Carts.GetItems(cart.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	view := &cartView{ID: cart.ID, Items: []cartLine{}}
+
+	for _, item := range items {
+		product, variant, err := app.resolveVariant(item.ProductID, item.VariantID)
+		if err != nil {
+			// Archived products are no longer sold, so their lines are
+			// left out.
+			if errors.Is(err, data.ErrRecordNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		discount, err := app.variantDiscount(product, variant)
+		if err != nil {
+			return nil, err
+		}
+
+		priced := data.PriceItem(product, variant, discount, item.Quantity, now)
+		view.Items = append(view.Items, cartLine{ID: item.ID, PricedItem: priced})
+		view.Total += priced.Subtotal
+	}
+
+	return view, nil
+}
+
+func (app *application) writeCart(w http.ResponseWriter, r *http.Request, status int, cart *data.Cart) {
+	view, err := app.renderCart(cart)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"cart": view}
+	if cart.Token != "" {
+		env["cart_token"] = cart.Token
+	}
+
+	err = app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCartHandler(w http.ResponseWriter, r *http.Request) {
+	cart, err := app.resolveCart(r, false)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusOK, envelope{"cart": cartView{Items: []cartLine{}}}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeCart(w, r, http.StatusOK, cart)
+}
+
+func (app *application) addCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ProductID int64   `json:"product_id"`
+		VariantID int64   `json:"variant_id"`
+		Quantity  float64 `json:"quantity"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	product, variant, err := app.resolveVariant(input.ProductID, input.VariantID)
+	if err != nil {
+		v := validator.New()
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("product_id", "product does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, errVariantRequired):
+			v.AddError("variant_id", "must be provided for a product with several variants")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	item := &data.CartItem{
+		ProductID: product.ID,
+		VariantID: variant.ID,
+		Quantity:  input.Quantity,
+	}
+
+	v := validator.New()
+	if data.ValidateCartItem(v, item, variant.Step); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cart, err := app.resolveCart(r, true)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	item.CartID = cart.ID
+	err = app.models.Carts.AddItem(item)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeCart(w, r, http.StatusOK, cart)
+}
+
+func (app *application) updateCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	cart, err := app.resolveCart(r, false)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	item, err := app.models.Carts.GetItem(cart.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Quantity *float64 `json:"quantity"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Quantity == nil {
+		app.badRequestResponse(w, r, errors.New("quantity is required"))
+		return
+	}
+
+	if *input.Quantity == 0 {
+		err = app.models.Carts.DeleteItem(cart.ID, item.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.writeCart(w, r, http.StatusOK, cart)
+		return
+	}
+
+	variant, err := app.models.Variants.Get(item.VariantID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	item.Quantity = *input.Quantity
+
+	v := validator.New()
+	if data.ValidateCartItem(v, item, variant.Step); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Carts.UpdateItem(item)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeCart(w, r, http.StatusOK, cart)
+}
+
+func (app *application) deleteCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	cart, err := app.resolveCart(r, false)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Carts.DeleteItem(cart.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeCart(w, r, http.StatusOK, cart)
+}
+
+func (app *application) checkoutCartHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Total   *int64 `json:"total"`
+		Address string `json:"address"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	cart, err := app.models.Carts.GetOrCreateForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	items, err := app.models.Carts.GetItems(cart.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	lines := make([]orderLine, len(items))
+	for i, item := range items {
+		lines[i] = orderLine{ProductID: item.ProductID, VariantID: item.VariantID, Amount: item.Quantity}
+	}
+
+	order, priced, ok := app.placeOrder(w, r, user.ID, input.Address, input.Total, lines, cart.ID)
+	if !ok {
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"order": order, "order_items": priced}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mergeGuestCart moves the guest cart named by the token into the user's
+// cart. Unknown or already merged tokens are ignored.
+func (app *application) mergeGuestCart(userID int64, token string) error {
+	guest, err := app.models.Carts.GetByToken(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	cart, err := app.models.Carts.GetOrCreateForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Carts.Merge(guest.ID, cart.ID)
+}
@@ -10,6 +10,7 @@ import (
 
 func (app *application) addCategoryHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
+		ParentID    int64  `json:"parent_id"`
 		Name        string `json:"name"`
 		Description string `json:"description"`
 		Image       string `json:"image"`
@@ -22,6 +23,7 @@ func (app *application) addCategoryHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	category := &data.Category{
+		ParentID:    input.ParentID,
 		Name:        input.Name,
 		Description: input.Description,
 		Image:       input.Image,
@@ -35,7 +37,13 @@ func (app *application) addCategoryHandler(w http.ResponseWriter, r *http.Reques
 
 	err = app.models.Category.Insert(category)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrInvalidParent):
+			v.AddError("parent_id", "must be a listed category")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -46,12 +54,33 @@ func (app *application) addCategoryHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (app *application) listCategoriesHandler(w http.ResponseWriter, r *http.Request) {
-	categories, err := app.models.Category.GetAll()
+	filters := app.readFilters(r.URL.Query(), 100, "id", referenceSortSafelist)
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	categories, metadata, err := app.models.Category.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"categories": categories}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"categories": categories, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCategoryTreeHandler(w http.ResponseWriter, r *http.Request) {
+	tree, err := app.models.Category.GetTree()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"categories": tree}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -74,7 +103,13 @@ func (app *application) showCategoryHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+	// Archived records only resolve for the orders that refer to them.
+	if category.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, app.etagHeader(category.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -98,6 +133,16 @@ func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if category.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.ifMatch(r, category.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Name        *string `json:"name"`
 		Description *string `json:"description"`
@@ -118,8 +163,11 @@ func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Req
 		category.Description = *input.Description
 	}
 
-	if input.Image != nil {
+	// An image set by URL replaces the uploaded one, whose files go.
+	var replaced []string
+	if input.Image != nil && *input.Image != category.Image {
 		category.Image = *input.Image
+		replaced, category.ImageKeys = category.ImageKeys, nil
 	}
 
 	v := validator.New()
@@ -129,12 +177,89 @@ func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	err = app.models.Category.Update(category)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteStoredFiles(replaced)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, app.etagHeader(category.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// moveCategoryHandler puts the category, with all its subcategories, under
+// another parent, or at the root for a zero parent_id.
+func (app *application) moveCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	category, err := app.models.Category.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if category.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.ifMatch(r, category.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ParentID *int64 `json:"parent_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.ParentID != nil, "parent_id", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Category.Move(category, *input.ParentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidParent):
+			v.AddError("parent_id", "must be a listed category")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCategoryCycle):
+			v.AddError("parent_id", "must not be the category or one of its subcategories")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, app.etagHeader(category.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -152,13 +277,15 @@ func (app *application) deleteCategoryHandler(w http.ResponseWriter, r *http.Req
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrCategoryHasChildren):
+			app.categoryHasChildrenResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "category successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "category successfully archived"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+)
+
+type contextKey string
+
+const principalContextKey = contextKey("principal")
+
+// principal is the authenticated user of a request together with the
+// permissions granted to their role and the session the access token was
+// issued for.
+type principal struct {
+	user        *data.User
+	permissions data.Permissions
+	sessionID   int64
+}
+
+func (app *application) contextSetPrincipal(r *http.Request, user *data.User, permissions data.Permissions, sessionID int64) *http.Request {
+	ctx := context.WithValue(r.Context(), principalContextKey, &principal{user: user, permissions: permissions, sessionID: sessionID})
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	p, ok := r.Context().Value(principalContextKey).(*principal)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return p.user
+}
+
+func (app *application) contextGetPermissions(r *http.Request) data.Permissions {
+	p, ok := r.Context().Value(principalContextKey).(*principal)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return p.permissions
+}
+
+func (app *application) contextGetSessionID(r *http.Request) int64 {
+	p, ok := r.Context().Value(principalContextKey).(*principal)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return p.sessionID
+}
@@ -48,12 +48,20 @@ func (app *application) addCountryHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (app *application) listCountriesHandler(w http.ResponseWriter, r *http.Request) {
-	countries, err := app.models.Country.GetAll()
+	filters := app.readFilters(r.URL.Query(), 100, "id", referenceSortSafelist)
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	countries, metadata, err := app.models.Country.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"countries": countries}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"countries": countries, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -76,6 +84,12 @@ func (app *application) showCountryHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Archived records only resolve for the orders that refer to them.
+	if country.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"country": country}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -165,7 +179,7 @@ func (app *application) deleteCountryHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "country successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "country successfully archived"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -51,14 +51,20 @@ func (app *application) addDiscountHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (app *application) listDiscountsHandler(w http.ResponseWriter, r *http.Request) {
-	// v := validator.New()
-	discounts, err := app.models.Discount.GetAll()
+	filters := app.readFilters(r.URL.Query(), 100, "id", referenceSortSafelist)
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	discounts, metadata, err := app.models.Discount.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-
-	err = app.writeJSON(w, http.StatusOK, envelope{"discounts": discounts}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"discounts": discounts, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -81,7 +87,13 @@ func (app *application) showDiscountHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"discount": discount}, nil)
+	// Archived records only resolve for the orders that refer to them.
+	if discount.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"discount": discount}, app.etagHeader(discount.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -105,6 +117,11 @@ func (app *application) updateDiscountHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if !app.ifMatch(r, discount.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Name            *string    `json:"name"`
 		Description     *string    `json:"description"`
@@ -147,11 +164,16 @@ func (app *application) updateDiscountHandler(w http.ResponseWriter, r *http.Req
 
 	err = app.models.Discount.Update(discount)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"discount": discount}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"discount": discount}, app.etagHeader(discount.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -175,7 +197,7 @@ func (app *application) deleteDiscountHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "discount successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "discount successfully archived"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
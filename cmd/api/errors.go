@@ -2,7 +2,12 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -69,6 +74,44 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been changed since it was read, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) categoryHasChildrenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the category has subcategories, move or archive them first"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) workflowStatusResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the status is part of the order workflow and can not be deleted"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) orderNotEditableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the items of a shipped, delivered or cancelled order can not be changed"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) lastVariantResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a product must keep at least one variant, delete the product instead"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) restoreConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record can not be restored, another record has taken over its unique values"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) insufficientStockResponse(w http.ResponseWriter, r *http.Request, shortages []data.StockShortage) {
+	message := map[string]any{
+		"message":  "insufficient stock",
+		"products": shortages,
+	}
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -79,3 +122,14 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	message := "invalid or missing authentication token"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
+
+func (app *application) tooManyAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "too many failed attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) fileTooLargeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the uploaded file must not be larger than %d bytes", app.config.upload.maxBytes)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/commerceml"
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+)
+
+const (
+	// exchangeCookie is the cookie 1C sends back after checkauth; it holds
+	// the access token of the exchange session.
+	exchangeCookie = "exchange_token"
+
+	// exchangeOrdersLimit is the number of orders handed to 1C at once.
+	exchangeOrdersLimit = 500
+)
+
+func (app *application) importCommerceMLHandler(w http.ResponseWriter, r *http.Request) {
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false)
+
+	files, err := app.readCommerceMLFiles(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	exchangeCatalog := &data.ExchangeCatalog{}
+	for _, file := range files {
+		part, err := commerceml.ReadCatalog(file)
+		file.Close()
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		exchangeCatalog.Groups = append(exchangeCatalog.Groups, part.Groups...)
+		exchangeCatalog.Products = append(exchangeCatalog.Products, part.Products...)
+		exchangeCatalog.Offers = append(exchangeCatalog.Offers, part.Offers...)
+	}
+
+	report, err := app.models.Exchange.Import(exchangeCatalog, dryRun)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readCommerceMLFiles returns the uploaded exchange files: the "import" and
+// "offers" fields of a multipart form, in that order, or the raw request
+// body.
+func (app *application) readCommerceMLFiles(w http.ResponseWriter, r *http.Request) ([]io.ReadCloser, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return []io.ReadCloser{r.Body}, nil
+	}
+
+	err := r.ParseMultipartForm(importMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []io.ReadCloser
+	for _, field := range []string{"import", "offers"} {
+		file, _, err := r.FormFile(field)
+		if err != nil {
+			if errors.Is(err, http.ErrMissingFile) {
+				continue
+			}
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("the import or offers field must be provided")
+	}
+	return files, nil
+}
+
+// exchangeResponse writes a reply of the 1C exchange protocol: plain text
+// lines, the first of which is "success", "progress" or "failure".
+func (app *application) exchangeResponse(w http.ResponseWriter, status int, lines ...string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, strings.Join(lines, "\n")+"\n")
+}
+
+// exchangeFailure logs the error and tells 1C that the step failed, without
+// giving the error itself away.
+func (app *application) exchangeFailure(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+	app.exchangeResponse(w, http.StatusInternalServerError, "failure", "internal error")
+}
+
+// exchangeHandler speaks the exchange protocol of 1C. The type parameter
+// picks the catalog or the sale exchange and mode the step of it. 1C starts
+// with checkauth using basic auth and sends the cookie it got back with all
+// further requests.
+func (app *application) exchangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("mode") == "checkauth" {
+		app.exchangeCheckAuth(w, r)
+		return
+	}
+
+	if cookie, err := r.Cookie(exchangeCookie); err == nil {
+		r.Header.Set("Authorization", "Bearer "+cookie.Value)
+	}
+
+	app.authMiddleware(app.exchangeModeHandler).ServeHTTP(w, r)
+}
+
+// exchangePermission returns the permission the exchange of the given type
+// requires.
+func exchangePermission(exchangeType string) (string, bool) {
+	switch exchangeType {
+	case "catalog":
+		return data.PermissionProductsWrite, true
+	case "sale":
+		return data.PermissionOrdersManage, true
+	default:
+		return "", false
+	}
+}
+
+func (app *application) exchangeCheckAuth(w http.ResponseWriter, r *http.Request) {
+	permission, ok := exchangePermission(r.URL.Query().Get("type"))
+	if !ok {
+		app.exchangeResponse(w, http.StatusBadRequest, "failure", "unknown exchange type")
+		return
+	}
+
+	email, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="exchange"`)
+		app.exchangeResponse(w, http.StatusUnauthorized, "failure", "authentication required")
+		return
+	}
+
+	retryAfter, err := app.attemptRetryAfter(r, email)
+	if err != nil {
+		app.exchangeFailure(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		app.exchangeResponse(w, http.StatusTooManyRequests, "failure", "too many attempts")
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.exchangeFailure(w, r, err)
+		return
+	}
+
+	match := false
+	if user != nil {
+		match, err = user.Password.Matches(password)
+		if err != nil {
+			app.exchangeFailure(w, r, err)
+			return
+		}
+	}
+
+	if !match {
+		if err = app.recordFailedAttempt(r, email, user); err != nil {
+			app.exchangeFailure(w, r, err)
+			return
+		}
+		app.exchangeResponse(w, http.StatusUnauthorized, "failure", "invalid authentication credentials")
+		return
+	}
+
+	err = app.models.Throttles.Reset(data.ThrottleScopeAccount, accountThrottleKey(email))
+	if err != nil {
+		app.exchangeFailure(w, r, err)
+		return
+	}
+
+	if !user.Activated {
+		app.exchangeResponse(w, http.StatusForbidden, "failure", "your user account must be activated to access this resource")
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForRole(user.Role_ID)
+	if err != nil {
+		app.exchangeFailure(w, r, err)
+		return
+	}
+	if !permissions.Include(permission) {
+		app.exchangeResponse(w, http.StatusForbidden, "failure", "your user account doesn't have the necessary permissions to access this resource")
+		return
+	}
+
+	token, err := app.models.Sessions.Create(user.ID, user.Role_ID, r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		app.exchangeFailure(w, r, err)
+		return
+	}
+
+	app.exchangeResponse(w, http.StatusOK, "success", exchangeCookie, token.AccessToken)
+}
+
+func (app *application) exchangeModeHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	exchangeType := qs.Get("type")
+
+	permission, ok := exchangePermission(exchangeType)
+	if !ok {
+		app.exchangeResponse(w, http.StatusBadRequest, "failure", "unknown exchange type")
+		return
+	}
+	if !app.contextGetPermissions(r).Include(permission) {
+		app.exchangeResponse(w, http.StatusForbidden, "failure", "your user account doesn't have the necessary permissions to access this resource")
+		return
+	}
+
+	sessionID := app.contextGetSessionID(r)
+
+	switch mode := qs.Get("mode"); {
+	case mode == "init":
+		err := app.models.Exchange.ResetSession(sessionID)
+		if err != nil {
+			app.exchangeFailure(w, r, err)
+			return
+		}
+		// file_limit is the size of the parts 1C splits files into; the
+		// total size of a file is limited as well.
+		app.exchangeResponse(w, http.StatusOK, "zip=no", fmt.Sprintf("file_limit=%d", importMaxBytes), fmt.Sprintf("total_limit=%d", app.config.exchange.maxBytes))
+
+	case mode == "file":
+		app.exchangeFile(w, r, sessionID, qs.Get("filename"))
+
+	case mode == "import" && exchangeType == "catalog":
+		app.exchangeImport(w, r, sessionID, qs.Get("filename"))
+
+	case mode == "query" && exchangeType == "sale":
+		app.exchangeQuery(w, r, sessionID)
+
+	case mode == "success" && exchangeType == "sale":
+		app.exchangeSuccess(w, r, sessionID)
+
+	default:
+		app.exchangeResponse(w, http.StatusBadRequest, "failure", "unknown exchange mode")
+	}
+}
+
+// exchangeFileName cleans a file name sent by 1C. Names may contain
+// subdirectories, e.g. for images.
+func exchangeFileName(filename string) (string, error) {
+	name := path.Clean("/" + filename)
+	if filename == "" || name == "/" {
+		return "", errors.New("invalid file name")
+	}
+	return name[1:], nil
+}
+
+// exchangeFile stores an uploaded file with the session until it is
+// imported. 1C sends large files in parts, so they are appended to what has
+// been received so far.
+func (app *application) exchangeFile(w http.ResponseWriter, r *http.Request, sessionID int64, filename string) {
+	name, err := exchangeFileName(filename)
+	if err != nil {
+		app.exchangeResponse(w, http.StatusBadRequest, "failure", err.Error())
+		return
+	}
+
+	part, err := io.ReadAll(http.MaxBytesReader(w, r.Body, importMaxBytes))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.exchangeResponse(w, http.StatusRequestEntityTooLarge, "failure", "the file part is too large")
+			return
+		}
+		app.exchangeFailure(w, r, err)
+		return
+	}
+
+	err = app.models.Exchange.AppendFile(sessionID, name, part, app.config.exchange.maxBytes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrExchangeFileTooLarge):
+			app.exchangeResponse(w, http.StatusRequestEntityTooLarge, "failure", fmt.Sprintf("the file must not be larger than %d bytes", app.config.exchange.maxBytes))
+		default:
+			app.exchangeFailure(w, r, err)
+		}
+		return
+	}
+
+	app.exchangeResponse(w, http.StatusOK, "success")
+}
+
+// exchangeImport imports an uploaded catalog or offers file and deletes it.
+// Entries that could not be imported are logged; the rest is saved.
+func (app *application) exchangeImport(w http.ResponseWriter, r *http.Request, sessionID int64, filename string) {
+	name, err := exchangeFileName(filename)
+	if err != nil {
+		app.exchangeResponse(w, http.StatusBadRequest, "failure", err.Error())
+		return
+	}
+
+	content, err := app.models.Exchange.TakeFile(sessionID, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.exchangeResponse(w, http.StatusBadRequest, "failure", "the file has not been uploaded")
+		default:
+			app.exchangeFailure(w, r, err)
+		}
+		return
+	}
+
+	exchangeCatalog, err := commerceml.ReadCatalog(bytes.NewReader(content))
+	if err != nil {
+		app.exchangeResponse(w, http.StatusBadRequest, "failure", err.Error())
+		return
+	}
+
+	report, err := app.models.Exchange.Import(exchangeCatalog, false)
+	if err != nil {
+		app.exchangeFailure(w, r, err)
+		return
+	}
+
+	for _, entry := range report.Errors {
+		app.logger.PrintInfo("exchange entry skipped", map[string]string{
+			"file":        filename,
+			"external_id": entry.ExternalID,
+			"error":       entry.Error,
+		})
+	}
+
+	app.exchangeResponse(w, http.StatusOK, "success")
+}
+
+// exchangeQuery hands the orders that have not been exported yet to 1C. They
+// are marked as exported only once 1C confirms them with mode=success.
+func (app *application) exchangeQuery(w http.ResponseWriter, r *http.Request, sessionID int64) {
+	orders, err := app.models.Exchange.PendingOrders(exchangeOrdersLimit)
+	if err != nil {
+		app.exchangeFailure(w, r, err)
+		return
+	}
+
+	ids := make([]int64, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+
+	err = app.models.Exchange.SaveSaleQuery(sessionID, ids)
+	if err != nil {
+		app.exchangeFailure(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	err = commerceml.WriteOrders(w, orders, time.Now())
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) exchangeSuccess(w http.ResponseWriter, r *http.Request, sessionID int64) {
+	err := app.models.Exchange.MarkOrdersExported(sessionID)
+	if err != nil {
+		app.exchangeFailure(w, r, err)
+		return
+	}
+
+	app.exchangeResponse(w, http.StatusOK, "success")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/catalog"
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+)
+
+// exportResponse remembers whether the export has started writing, after
+// which errors can no longer be sent as a response.
+type exportResponse struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *exportResponse) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (app *application) exportProductsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	filter := app.readProductFilter(qs)
+	name := app.readString(qs, "format", "csv")
+
+	v := validator.New()
+	format, ok := catalog.ExportFormats[name]
+	v.Check(ok, "format", "must be csv, jsonl or xlsx")
+	err := app.validateAttributeFilters(v, filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateProductFilter(v, filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// A large catalog takes longer to send than the server's write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	filename := "products-" + time.Now().Format("20060102") + "." + format.Extension
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	out := &exportResponse{ResponseWriter: w}
+
+	writer, err := format.NewWriter(out)
+	if err == nil {
+		err = app.models.Products.Export(r.Context(), filter, writer.Write)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+
+	if err != nil {
+		if !out.wrote {
+			w.Header().Del("Content-Disposition")
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// The client gets a truncated file; all that's left is to log it.
+		app.logError(r, err)
+	}
+}
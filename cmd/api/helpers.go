@@ -6,14 +6,14 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 
-	"github.com/julienschmidt/httprouter"
-
 	"github.com/dexciuq/yummy-express-backend/internal/data"
+	"github.com/julienschmidt/httprouter"
 )
 
 type envelope map[string]any
@@ -27,6 +27,20 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// staticOr serves the routes that share a path level with an :id parameter,
+// which httprouter can't register side by side. Requests whose :id matches
+// one of the static segments go to its handler, all others to byID.
+func (app *application) staticOr(static map[string]http.HandlerFunc, byID http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		if next, ok := static[params.ByName("id")]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		byID.ServeHTTP(w, r)
+	}
+}
+
 func (app *application) readUUIDParam(r *http.Request) (string, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 	uuid := params.ByName("uuid")
@@ -141,6 +155,63 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int) int
 	return i
 }
 
+// readFilters reads the pagination and sort parameters of a listing from the
+// query string. A listing is paged by page number unless an after or before
+// cursor is given.
+func (app *application) readFilters(qs url.Values, pageSize int, sort string, sortSafelist []string) data.Filters {
+	return data.Filters{
+		Page:         app.readInt(qs, "page", 1),
+		PageSize:     app.readInt(qs, "page_size", pageSize),
+		Sort:         app.readString(qs, "sort", sort),
+		SortSafelist: sortSafelist,
+		After:        app.readString(qs, "after", ""),
+		Before:       app.readString(qs, "before", ""),
+	}
+}
+
+// referenceSortSafelist holds the sort values a reference-data list accepts.
+var referenceSortSafelist = []string{"id", "name", "-id", "-name"}
+
+func (app *application) readBool(qs url.Values, key string, defaultValue bool) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return defaultValue
+	}
+	return b
+}
+
+// etagHeader returns the ETag header of a record at the given version.
+func (app *application) etagHeader(version int) http.Header {
+	headers := make(http.Header)
+	headers.Set("ETag", strconv.Quote(strconv.Itoa(version)))
+	return headers
+}
+
+// ifMatch reports whether the If-Match header of the request, if it has one,
+// names the given version of the record.
+func (app *application) ifMatch(r *http.Request, version int) bool {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		return true
+	}
+
+	etag := strconv.Quote(strconv.Itoa(version))
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (app *application) background(function func()) {
 	app.wg.Add(1)
 	go func() {
@@ -167,21 +238,70 @@ func (app *application) renderTemplate(w http.ResponseWriter, r *http.Request, n
 	}
 }
 
-func (app *application) getUserIDFromHeader(w http.ResponseWriter, r *http.Request) float64 {
-	authorizationHeader := r.Header.Get("Authorization")
-	if authorizationHeader == "" {
-		app.UserUnauthorizedResponse(w, r)
+// optionalUserID returns the id of the user from a valid access token of an
+// active session, or 0 for anonymous requests. Unlike authMiddleware it never
+// rejects the request: a token of a revoked session counts as anonymous.
+func (app *application) optionalUserID(r *http.Request) int64 {
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if accessToken == "" {
+		return 0
 	}
 
-	accessToken := strings.TrimPrefix(authorizationHeader, "Bearer ")
-	if accessToken == "" {
-		app.UserUnauthorizedResponse(w, r)
+	accessTokenMap, err := app.models.Keys.DecodeAccessToken(accessToken)
+	if err != nil {
+		return 0
+	}
+
+	userId, okUser := accessTokenMap["user_id"].(float64)
+	sessionId, okSession := accessTokenMap["sid"].(float64)
+	if !okUser || !okSession {
+		return 0
 	}
-	accessTokenMap, err := data.DecodeAccessToken(accessToken)
+
+	active, err := app.models.Sessions.IsActive(int64(sessionId), int64(userId))
+	if err != nil || !active {
+		return 0
+	}
+	return int64(userId)
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is only
+// honored when the connection comes from a trusted proxy, and then the
+// rightmost address that is not a trusted proxy itself is used.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		app.UserUnauthorizedResponse(w, r)
+		host = r.RemoteAddr
+	}
+
+	if !app.isTrustedProxy(host) {
+		return host
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip == "" {
+			continue
+		}
+		if !app.isTrustedProxy(ip) {
+			return ip
+		}
+		host = ip
+	}
+	return host
+}
+
+func (app *application) isTrustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
 	}
 
-	userId := accessTokenMap["user_id"].(float64)
-	return userId
+	for _, network := range app.config.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+	"github.com/dexciuq/yummy-express-backend/internal/media"
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+)
+
+var errImageMissing = errors.New("image missing")
+
+// storedImage is an upload whose renditions were saved to storage.
+type storedImage struct {
+	URL        string
+	Renditions map[string]string
+	Keys       []string
+}
+
+// readImage reads the multipart "image" field of the request, limited to the
+// configured upload size, and renders its versions.
+func (app *application) readImage(w http.ResponseWriter, r *http.Request) ([]media.Rendition, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.upload.maxBytes)
+
+	err := r.ParseMultipartForm(app.config.upload.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) {
+			return nil, errImageMissing
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return media.Process(raw)
+}
+
+// imageErrorResponse answers a request whose image could not be read.
+func (app *application) imageErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesError *http.MaxBytesError
+
+	v := validator.New()
+	switch {
+	case errors.As(err, &maxBytesError):
+		app.fileTooLargeResponse(w, r)
+	case errors.Is(err, errImageMissing):
+		v.AddError("image", "must be provided")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, media.ErrUnsupportedType):
+		v.AddError("image", "must be a JPEG, PNG or WebP image")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, media.ErrTooLarge):
+		v.AddError("image", "must not be more than "+strconv.Itoa(media.MaxDimension)+" pixels wide or high")
+		app.failedValidationResponse(w, r, v.Errors)
+	default:
+		app.badRequestResponse(w, r, err)
+	}
+}
+
+// storeImage saves the renditions under a fresh random prefix below dir, so
+// that a replaced image never shares URLs with the one cached before it.
+func (app *application) storeImage(ctx context.Context, dir string, renditions []media.Rendition) (*storedImage, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	prefix := dir + "/" + hex.EncodeToString(b) + "/"
+
+	stored := &storedImage{Renditions: map[string]string{}}
+
+	for _, rendition := range renditions {
+		key := prefix + rendition.Name + "." + rendition.Ext
+
+		err = app.storage.Put(ctx, key, bytes.NewReader(rendition.Data), int64(len(rendition.Data)), rendition.ContentType)
+		if err != nil {
+			app.deleteStoredFiles(stored.Keys)
+			return nil, err
+		}
+
+		stored.Keys = append(stored.Keys, key)
+		if rendition.Name == "original" {
+			stored.URL = app.storage.URL(key)
+		} else {
+			stored.Renditions[rendition.Name] = app.storage.URL(key)
+		}
+	}
+
+	return stored, nil
+}
+
+// deleteStoredFiles removes the files of an image in the background. Failures
+// only leave orphaned files behind, so they are logged and not returned.
+func (app *application) deleteStoredFiles(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	app.background(func() {
+		for _, key := range keys {
+			err := app.storage.Delete(context.Background(), key)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"key": key})
+			}
+		}
+	})
+}
+
+func (app *application) readImageIDParam(r *http.Request) (int64, error) {
+	param, _ := app.readParamByNurik(r, "image_id")
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid image_id parameter")
+	}
+	return id, nil
+}
+
+func (app *application) addProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if product.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	renditions, err := app.readImage(w, r)
+	if err != nil {
+		app.imageErrorResponse(w, r, err)
+		return
+	}
+
+	primary, _ := strconv.ParseBool(r.FormValue("primary"))
+
+	stored, err := app.storeImage(r.Context(), "products/"+strconv.FormatInt(product.ID, 10), renditions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	image := &data.ProductImage{
+		ProductID:   product.ID,
+		Primary:     primary,
+		URL:         stored.URL,
+		Renditions:  stored.Renditions,
+		StorageKeys: stored.Keys,
+	}
+
+	err = app.models.Images.Insert(image)
+	if err != nil {
+		app.deleteStoredFiles(stored.Keys)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/products/%d/images/%d", image.ProductID, image.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": image}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listProductImagesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	images, err := app.models.Images.GetAllForProduct(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": images}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) reorderProductImagesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		IDs []int64 `json:"ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	err = app.models.Images.Reorder(id, input.IDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrImageOrderMismatch):
+			v.AddError("ids", "must list every image of the product exactly once")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	images, err := app.models.Images.GetAllForProduct(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": images}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	imageID, err := app.readImageIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Primary *bool `json:"primary"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// A product always has a primary image while it has any, so it can only
+	// be handed to another image, not taken away.
+	v := validator.New()
+	v.Check(input.Primary != nil && *input.Primary, "primary", "must be true")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Images.SetPrimary(id, imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	image, err := app.models.Images.Get(id, imageID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"image": image}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	imageID, err := app.readImageIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	image, err := app.models.Images.Delete(id, imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteStoredFiles(image.StorageKeys)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) uploadCategoryImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	category, err := app.models.Category.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if category.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	renditions, err := app.readImage(w, r)
+	if err != nil {
+		app.imageErrorResponse(w, r, err)
+		return
+	}
+
+	stored, err := app.storeImage(r.Context(), "categories/"+strconv.FormatInt(category.ID, 10), renditions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Categories show a single image, so the medium WebP stands in for the
+	// original.
+	replaced := category.ImageKeys
+	category.Image = stored.Renditions["medium_webp"]
+	category.ImageKeys = stored.Keys
+
+	err = app.models.Category.Update(category)
+	if err != nil {
+		app.deleteStoredFiles(stored.Keys)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteStoredFiles(replaced)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category, "renditions": stored.Renditions}, app.etagHeader(category.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mediaPath returns the path the API serves the files of the local storage
+// backend under: the path of its base URL, which may also name a host in
+// front of the API. It is empty if the URL has no path to mount on.
+func mediaPath(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// mediaHandler serves the files of the local storage backend below prefix.
+// Directory listings are not served.
+func (app *application) mediaHandler(prefix, dir string) http.Handler {
+	files := http.StripPrefix(prefix, http.FileServer(http.Dir(dir)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			app.notFoundResponse(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		files.ServeHTTP(w, r)
+	})
+}
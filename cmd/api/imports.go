@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/dexciuq/yummy-express-backend/internal/catalog"
+)
+
+// importMaxBytes limits the size of an uploaded catalog file.
+const importMaxBytes = 20 << 20
+
+// readImportFile returns the uploaded file of an import request: either the
+// "file" field of a multipart form or the raw request body.
+func (app *application) readImportFile(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+
+	err := r.ParseMultipartForm(importMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) {
+			return nil, errors.New("the file field must be provided")
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+func (app *application) importProductsHandler(w http.ResponseWriter, r *http.Request) {
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false)
+
+	file, err := app.readImportFile(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	rows, err := catalog.ReadCSV(file)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	report, err := app.models.Products.Import(rows, dryRun)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !dryRun && !report.Valid() {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{
+			"message": "some rows have errors, nothing was saved",
+			"report":  report,
+		})
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+)
+
+// jwksHandler publishes the public keys that tokens may be signed with, so
+// other services can verify them without sharing a secret.
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.Keys.JWKS()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"keys": keys}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limiterIdleTimeout is how long a bucket may go unused before it is
+	// evicted. An evicted client starts again with a full bucket.
+	limiterIdleTimeout = 3 * time.Minute
+	limiterEvictEvery  = time.Minute
+)
+
+// rateLimitPolicy is a token bucket: burst requests at once, refilled at rps
+// requests per second.
+type rateLimitPolicy struct {
+	rps   rate.Limit
+	burst int
+}
+
+// Stricter policies for the endpoints that send email or accept credentials,
+// always keyed by client IP.
+var (
+	authenticatePolicy  = rateLimitPolicy{rps: rate.Every(12 * time.Second), burst: 5}
+	registerPolicy      = rateLimitPolicy{rps: rate.Every(time.Minute), burst: 3}
+	passwordResetPolicy = rateLimitPolicy{rps: rate.Every(time.Minute), burst: 3}
+)
+
+type limiterClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	policy rateLimitPolicy
+
+	mu      sync.Mutex
+	clients map[string]*limiterClient
+}
+
+// newRateLimiter creates a limiter and starts evicting its idle buckets in
+// the background until done is closed.
+func newRateLimiter(policy rateLimitPolicy, done <-chan struct{}) *rateLimiter {
+	l := &rateLimiter{
+		policy:  policy,
+		clients: make(map[string]*limiterClient),
+	}
+
+	go func() {
+		ticker := time.NewTicker(limiterEvictEvery)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				l.evictIdle()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return l
+}
+
+func (l *rateLimiter) evictIdle() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, client := range l.clients {
+		if time.Since(client.lastSeen) > limiterIdleTimeout {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// allow takes a token from the bucket of the key. It reports how many
+// tokens are left and, when the request is rejected, how long the client has
+// to wait for the next one.
+func (l *rateLimiter) allow(key string) (ok bool, remaining int, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	client, found := l.clients[key]
+	if !found {
+		client = &limiterClient{limiter: rate.NewLimiter(l.policy.rps, l.policy.burst)}
+		l.clients[key] = client
+	}
+	client.lastSeen = now
+
+	reservation := client.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, 0, delay
+	}
+
+	return true, int(client.limiter.TokensAt(now)), 0
+}
+
+// resetAfter is the time until the bucket is full again.
+func (l *rateLimiter) resetAfter(remaining int) time.Duration {
+	missing := l.policy.burst - remaining
+	if missing <= 0 || l.policy.rps <= 0 {
+		return 0
+	}
+	return time.Duration(float64(missing) / float64(l.policy.rps) * float64(time.Second))
+}
+
+func (app *application) limit(l *rateLimiter, key string, w http.ResponseWriter, r *http.Request) bool {
+	ok, remaining, retryAfter := l.allow(key)
+
+	reset := l.resetAfter(remaining)
+	if !ok {
+		reset = retryAfter
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(l.policy.burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// rateLimit applies the global limit to every request: authenticated users
+// get a bucket of their own, everybody else shares one per client IP.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	if !app.config.limiter.enabled {
+		return next
+	}
+
+	ipLimiter := newRateLimiter(rateLimitPolicy{
+		rps:   rate.Limit(app.config.limiter.rps),
+		burst: app.config.limiter.burst,
+	}, app.done)
+	userLimiter := newRateLimiter(rateLimitPolicy{
+		rps:   rate.Limit(app.config.limiter.userRps),
+		burst: app.config.limiter.userBurst,
+	}, app.done)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID := app.optionalUserID(r); userID != 0 {
+			if !app.limit(userLimiter, strconv.FormatInt(userID, 10), w, r) {
+				return
+			}
+		} else if !app.limit(ipLimiter, app.clientIP(r), w, r) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitRoute applies a stricter per-IP policy to a single route, on top
+// of the global limit.
+func (app *application) rateLimitRoute(policy rateLimitPolicy, next http.HandlerFunc) http.HandlerFunc {
+	if !app.config.limiter.enabled {
+		return next
+	}
+
+	l := newRateLimiter(policy, app.done)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.limit(l, app.clientIP(r), w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and
+// CIDR ranges.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"sync"
@@ -21,6 +22,7 @@ import (
 	"github.com/dexciuq/yummy-express-backend/internal/data"
 	"github.com/dexciuq/yummy-express-backend/internal/jsonlog"
 	"github.com/dexciuq/yummy-express-backend/internal/mailer"
+	"github.com/dexciuq/yummy-express-backend/internal/storage"
 )
 
 const version = "1.0"
@@ -35,9 +37,11 @@ type config struct {
 		maxIdleTime  string
 	}
 	limiter struct {
-		enabled bool
-		rps     float64
-		burst   int
+		enabled   bool
+		rps       float64
+		burst     int
+		userRps   float64
+		userBurst int
 	}
 	smtp struct {
 		host     string
@@ -46,14 +50,39 @@ type config struct {
 		password string
 		sender   string
 	}
+	storage struct {
+		backend string
+		dir     string
+		url     string
+		s3      struct {
+			endpoint  string
+			region    string
+			bucket    string
+			accessKey string
+			secretKey string
+			publicURL string
+			pathStyle bool
+		}
+	}
+	upload struct {
+		maxBytes int64
+	}
+	exchange struct {
+		maxBytes int64
+	}
+	trustedProxies []*net.IPNet
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	wg      sync.WaitGroup
+	// done is closed when the server shuts down, stopping the background
+	// loops started with it.
+	done chan struct{}
 }
 
 func main() {
@@ -74,8 +103,13 @@ func main() {
 	// Set up limitations for application
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.Float64Var(&cfg.limiter.userRps, "limiter-user-rps", 5, "Rate limiter maximum requests per second for authenticated users")
+	flag.IntVar(&cfg.limiter.userBurst, "limiter-user-burst", 10, "Rate limiter maximum burst for authenticated users")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	// Proxies allowed to set X-Forwarded-For
+	trustedProxies := flag.String("trusted-proxies", os.Getenv("TRUSTED_PROXIES"), "Comma-separated IPs or CIDR ranges of trusted reverse proxies")
+
 	// Google smtp-server connection
 	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("SMTP_HOST"), "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", getInt("SMTP_PORT"), "SMTP port")
@@ -83,9 +117,34 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", os.Getenv("SMTP_SENDER"), "SMTP sender")
 
+	// Storage of uploaded images
+	flag.StringVar(&cfg.storage.backend, "storage", getString("STORAGE", "local"), "Storage backend for uploads (local|s3)")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", getString("STORAGE_DIR", "./uploads"), "Directory of the local storage backend")
+	flag.StringVar(&cfg.storage.url, "storage-url", getString("STORAGE_URL", "/media"), "Base URL the local storage backend is served from")
+	flag.StringVar(&cfg.storage.s3.endpoint, "s3-endpoint", os.Getenv("S3_ENDPOINT"), "S3 endpoint URL")
+	flag.StringVar(&cfg.storage.s3.region, "s3-region", getString("S3_REGION", "us-east-1"), "S3 region")
+	flag.StringVar(&cfg.storage.s3.bucket, "s3-bucket", os.Getenv("S3_BUCKET"), "S3 bucket")
+	flag.StringVar(&cfg.storage.s3.accessKey, "s3-access-key", os.Getenv("S3_ACCESS_KEY"), "S3 access key")
+	flag.StringVar(&cfg.storage.s3.secretKey, "s3-secret-key", os.Getenv("S3_SECRET_KEY"), "S3 secret key")
+	flag.StringVar(&cfg.storage.s3.publicURL, "s3-public-url", os.Getenv("S3_PUBLIC_URL"), "Base URL objects are served from, if not the bucket")
+	flag.BoolVar(&cfg.storage.s3.pathStyle, "s3-path-style", os.Getenv("S3_PATH_STYLE") == "true", "Address the bucket by path, as MinIO needs")
+	flag.Int64Var(&cfg.upload.maxBytes, "upload-max-bytes", 5<<20, "Maximum size of an uploaded image in bytes")
+	flag.Int64Var(&cfg.exchange.maxBytes, "exchange-max-bytes", 200<<20, "Maximum total size of a file uploaded by the 1C exchange in bytes")
+
 	flag.Parse()
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	proxies, err := parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	cfg.trustedProxies = proxies
+
+	store, err := openStorage(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -95,10 +154,12 @@ func main() {
 	logger.PrintInfo("database connection pool established", nil)
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+		done:    make(chan struct{}),
 	}
 
 	// init
@@ -109,7 +170,13 @@ func main() {
 	app.models.Category.Init()
 	app.models.Products.Init()
 	app.models.Roles.Init()
+	app.models.Permissions.Init()
 	app.models.Statuses.Init()
+
+	err = app.models.Keys.Init()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 	//app.models.Users.Init()
 
 	err = app.serve()
@@ -127,12 +194,38 @@ func getDSN() string {
 		os.Getenv("DB_NAME"))
 }
 
+func getString(key, defaultValue string) string {
+	s := os.Getenv(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
 func getInt(key string) int {
 	s := os.Getenv(key)
 	value, _ := strconv.Atoi(s)
 	return value
 }
 
+func openStorage(cfg config) (storage.Storage, error) {
+	switch cfg.storage.backend {
+	case "local":
+		if mediaPath(cfg.storage.url) == "" {
+			return nil, errors.New("local storage needs a storage url with a path to serve the files under, e.g. /media")
+		}
+		return storage.NewLocal(cfg.storage.dir, cfg.storage.url), nil
+	case "s3":
+		s3 := cfg.storage.s3
+		if s3.endpoint == "" || s3.bucket == "" {
+			return nil, errors.New("s3 storage needs an endpoint and a bucket")
+		}
+		return storage.NewS3(s3.endpoint, s3.region, s3.bucket, s3.accessKey, s3.secretKey, s3.pathStyle, s3.publicURL), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.storage.backend)
+	}
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
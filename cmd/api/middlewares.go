@@ -1,139 +1,87 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
-	"reflect"
 	"strings"
-	"time"
 
 	"github.com/dexciuq/yummy-express-backend/internal/data"
 )
 
+// authMiddleware resolves the user from the bearer access token, together
+// with the permissions of their role, and stores them in the request context.
+// Tokens of revoked sessions are rejected even before they expire.
 func (app *application) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("Auth middleware:")
-		var flag bool
-		flag = false
-		authorizationHeader := r.Header.Get("Authorization")
-		if authorizationHeader == "" {
-			flag = true
-		}
-
-		accessToken := strings.TrimPrefix(authorizationHeader, "Bearer ")
-
-		fmt.Println("accessToken |", accessToken, "|")
-
+		accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 		if accessToken == "" {
-			flag = true
+			app.UserUnauthorizedResponse(w, r)
+			return
 		}
 
-		accessTokenMap, err := data.DecodeAccessToken(accessToken)
-		fmt.Println(accessTokenMap)
-		fmt.Println("length of accessTokenMap", len(accessTokenMap))
-
+		accessTokenMap, err := app.models.Keys.DecodeAccessToken(accessToken)
 		if err != nil {
-			flag = true
-		}
-
-		if len(accessTokenMap) == 0 {
-			flag = true
-			//			app.errorResponse(w, r, http.StatusUnauthorized, "access token was expired")
-		}
-
-		if flag == false {
-			exp := int64(accessTokenMap["exp"].(float64))
-			expUnix := time.Unix(exp, 0)
-			fmt.Println(exp, reflect.TypeOf(exp))
-			fmt.Println(expUnix, reflect.TypeOf(expUnix))
-			fmt.Println(time.Now().After(expUnix), time.Now())
-			if time.Now().After(expUnix) {
-				flag = true
-				fmt.Println("token expired")
+			switch {
+			case errors.Is(err, data.ErrTokenExpired):
 				app.errorResponse(w, r, http.StatusUnauthorized, "access token was expired")
-				return
-			}
-			userId := accessTokenMap["user_id"].(float64)
-			_, err = app.models.Users.GetById(int64(userId))
-
-			if err != nil {
-				flag = true
+			case errors.Is(err, data.ErrInvalidToken):
+				app.UserUnauthorizedResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
 			}
+			return
 		}
-		fmt.Println("Auth middleware ended.")
 
-		if flag == false {
-			next.ServeHTTP(w, r)
-		} else {
+		userId, okUser := accessTokenMap["user_id"].(float64)
+		sessionId, okSession := accessTokenMap["sid"].(float64)
+		if !okUser || !okSession {
 			app.UserUnauthorizedResponse(w, r)
+			return
 		}
-	})
-}
 
-func (app *application) adminAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("Admin auth middleware:")
-		var flag bool
-		flag = false
-		authorizationHeader := r.Header.Get("Authorization")
-		if authorizationHeader == "" {
-			flag = true
+		active, err := app.models.Sessions.IsActive(int64(sessionId), int64(userId))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
 		}
-
-		accessToken := strings.TrimPrefix(authorizationHeader, "Bearer ")
-
-		fmt.Println("accessToken |", accessToken, "|")
-
-		if accessToken == "" {
-			flag = true
+		if !active {
+			app.UserUnauthorizedResponse(w, r)
+			return
 		}
 
-		accessTokenMap, err := data.DecodeAccessToken(accessToken)
-		fmt.Println(accessTokenMap)
-		fmt.Println("length of accessTokenMap", len(accessTokenMap))
-
+		user, err := app.models.Users.GetById(int64(userId))
 		if err != nil {
-			flag = true
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.UserUnauthorizedResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
 		}
 
-		if len(accessTokenMap) == 0 {
-			flag = true
-			//			app.errorResponse(w, r, http.StatusUnauthorized, "access token was expired")
+		permissions, err := app.models.Permissions.GetAllForRole(user.Role_ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
 		}
 
-		if flag == false {
-			exp := int64(accessTokenMap["exp"].(float64))
-			expUnix := time.Unix(exp, 0)
-			fmt.Println(exp, reflect.TypeOf(exp))
-			fmt.Println(expUnix, reflect.TypeOf(expUnix))
-			fmt.Println(time.Now().After(expUnix), time.Now())
-			if time.Now().After(expUnix) {
-				flag = true
-				fmt.Println("token expired")
-				app.errorResponse(w, r, http.StatusUnauthorized, "access token was expired")
-				return
-			}
-
-			userId := accessTokenMap["user_id"].(float64)
-			_, err = app.models.Users.GetById(int64(userId))
-			if err != nil {
-				flag = true
-			}
+		r = app.contextSetPrincipal(r, user, permissions, int64(sessionId))
+		next.ServeHTTP(w, r)
+	})
+}
 
-			roleId := int(accessTokenMap["role_id"].(float64))
-			if roleId != 3 {
-				flag = true
-				fmt.Println("You're not admin")
-				app.NotEnoughPermissionResponse(w, r)
-				return
-			}
+// requirePermission only lets through authenticated users whose role has been
+// granted the permission with the given code.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !app.contextGetPermissions(r).Include(code) {
+			app.NotEnoughPermissionResponse(w, r)
+			return
 		}
-		fmt.Println("Admin auth middleware ended.")
 
-		if flag == false {
-			next.ServeHTTP(w, r)
-		} else {
-			app.UserUnauthorizedResponse(w, r)
-		}
-	})
+		next.ServeHTTP(w, r)
+	}
+
+	return app.authMiddleware(fn)
 }
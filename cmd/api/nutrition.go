@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+)
+
+// setProductNutritionHandler replaces the nutrition facts and allergens of a
+// product. A null nutrition removes it; null allergens mark them as not
+// declared, while an empty list declares that the product has none.
+func (app *application) setProductNutritionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if product.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.ifMatch(r, product.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Nutrition *data.Nutrition `json:"nutrition"`
+		Allergens []string        `json:"allergens"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.Nutrition != nil {
+		data.ValidateNutrition(v, input.Nutrition)
+	}
+	if data.ValidateAllergens(v, input.Allergens); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Products.SetNutrition(product, input.Nutrition, input.Allergens)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"nutrition": input.Nutrition, "allergens": input.Allergens}, app.etagHeader(product.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -39,28 +39,21 @@ func (app *application) updateOrderItemHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	price := int64(float64(orderItem.Total) / orderItem.Quantity)
-	difference := int64((orderItem.Quantity - *input.Quantity) * float64(price))
-
-	if *input.Quantity == 0 {
-		err = app.models.OrderItems.Delete(orderItem.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
-		}
-	} else {
-		orderItem.Total = int64(float64(price) * (*input.Quantity))
-		orderItem.Quantity = *input.Quantity
-		err = app.models.OrderItems.Update(orderItem)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
-		}
+	if *input.Quantity < 0 {
+		app.badRequestResponse(w, r, errors.New("quantity can not be negative"))
+		return
 	}
 
-	order, err := app.models.Orders.Get(orderItem.OrderID)
+	err = app.models.Orders.UpdateItemQuantity(orderItem, *input.Quantity)
 	if err != nil {
+		var stockErr *data.InsufficientStockError
 		switch {
+		case errors.As(err, &stockErr):
+			app.insufficientStockResponse(w, r, stockErr.Shortages)
+		case errors.Is(err, data.ErrInvalidAmount):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, data.ErrOrderNotEditable):
+			app.orderNotEditableResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -69,14 +62,6 @@ func (app *application) updateOrderItemHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	order.Total -= difference
-
-	err = app.models.Orders.Update(order)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	err = app.writeJSON(w, http.StatusOK, envelope{"orderItem": orderItem}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -2,7 +2,7 @@ package main
 
 import (
 	"errors"
-	"math"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -10,101 +10,302 @@ import (
 	"github.com/dexciuq/yummy-express-backend/internal/validator"
 )
 
-func (app *application) addOrderHandler(w http.ResponseWriter, r *http.Request) {
-	userId := app.getUserIDFromHeader(w, r)
+// orderLine is a product of an order. VariantID may be left out for products
+// with a single variant.
+type orderLine struct {
+	ProductID int64   `json:"id"`
+	VariantID int64   `json:"variant_id"`
+	Amount    float64 `json:"amount"`
+}
 
-	type product struct {
-		ID     int64   `json:"id"`
-		Price  int64   `json:"price"`
-		Amount float64 `json:"amount"`
-	}
-	var input struct {
-		Total    int64     `json:"total"`
-		Address  string    `json:"address"`
-		Products []product `json:"products"`
+// priceOrderLines looks up every variant of the order and calculates its price
+// with the currently active discount. Problems with the input, such as unknown
+// products, are reported through the validator.
+func (app *application) priceOrderLines(v *validator.Validator, lines []orderLine) ([]*data.PricedItem, int64, error) {
+	v.Check(len(lines) > 0, "products", "must contain at least one product")
+
+	now := time.Now()
+	items := make([]*data.PricedItem, 0, len(lines))
+	var total int64
+
+	for i, line := range lines {
+		key := fmt.Sprintf("products[%d]", i)
+
+		product, variant, err := app.resolveVariant(line.ProductID, line.VariantID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError(key, fmt.Sprintf("product %d does not exist", line.ProductID))
+				continue
+			case errors.Is(err, errVariantRequired):
+				v.AddError(key, fmt.Sprintf("product %d has several variants, variant_id must be provided", line.ProductID))
+				continue
+			default:
+				return nil, 0, err
+			}
+		}
+
+		if !data.ValidAmount(line.Amount, variant.Step) {
+			v.AddError(key, fmt.Sprintf("amount must be a positive multiple of %g", variant.Step))
+			continue
+		}
+
+		discount, err := app.variantDiscount(product, variant)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		item := data.PriceItem(product, variant, discount, line.Amount, now)
+		items = append(items, item)
+		total += item.Subtotal
 	}
 
-	err := app.readJSON(w, r, &input)
+	return items, total, nil
+}
+
+// placeOrder prices the lines, checks the client-side total if one was sent
+// and places the order, emptying the cart it was checked out from, if any. On
+// failure the error response has already been sent and ok is false.
+func (app *application) placeOrder(w http.ResponseWriter, r *http.Request, userID int64, address string, clientTotal *int64, lines []orderLine, cartID int64) (order *data.Order, items []*data.PricedItem, ok bool) {
+	v := validator.New()
+	items, total, err := app.priceOrderLines(v, lines)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
+		app.serverErrorResponse(w, r, err)
+		return nil, nil, false
 	}
-
-	order := &data.Order{
-		UserID:   int64(userId),
-		Total:    input.Total,
-		Address:  input.Address,
-		StatusID: int64(1),
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, nil, false
 	}
 
-	v := validator.New()
-	if data.ValidateOrder(v, order); !v.Valid() {
+	if clientTotal != nil && *clientTotal != total {
+		v.AddError("total", fmt.Sprintf("does not match the calculated total of %d", total))
 		app.failedValidationResponse(w, r, v.Errors)
-		return
+		return nil, nil, false
 	}
 
-	err = app.models.Orders.Insert(order)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+	order = &data.Order{
+		UserID:  userID,
+		Total:   total,
+		Address: address,
 	}
 
-	for _, product := range input.Products {
-		item := &data.OrderItem{
-			OrderID:   order.ID,
-			ProductID: product.ID,
-			Quantity:  product.Amount,
-			Total:     int64(math.Floor(float64(product.Price) * product.Amount)),
+	if data.ValidateOrder(v, order); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, nil, false
+	}
+
+	orderItems := make([]*data.OrderItem, len(items))
+	for i, priced := range items {
+		orderItems[i] = &data.OrderItem{
+			ProductID:       priced.ProductID,
+			VariantID:       priced.VariantID,
+			Quantity:        priced.Amount,
+			Price:           priced.Price,
+			DiscountPercent: priced.DiscountPercent,
+			Total:           priced.Subtotal,
 		}
-		if data.ValidateOrderItem(v, item); !v.Valid() {
+	}
+
+	err = app.models.Orders.Place(order, orderItems, cartID)
+	if err != nil {
+		var stockErr *data.InsufficientStockError
+		switch {
+		case errors.As(err, &stockErr):
+			app.insufficientStockResponse(w, r, stockErr.Shortages)
+		case errors.Is(err, data.ErrInvalidAmount):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, data.ErrRecordNotFound):
+			// A variant or product was archived after the lines were priced.
+			v.AddError("products", "must only contain products that exist")
 			app.failedValidationResponse(w, r, v.Errors)
-			return
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
+		return nil, nil, false
+	}
 
-		err = app.models.OrderItems.Insert(item)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+	return order, items, true
+}
+
+// statusCode returns the workflow code of the status, which is blank for
+// statuses outside the workflow and for unknown ones.
+func (app *application) statusCode(id int64) (string, error) {
+	status, err := app.models.Statuses.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return "", nil
+		default:
+			return "", err
 		}
 	}
+	return status.Code, nil
+}
+
+func (app *application) addOrderHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Total    *int64      `json:"total"`
+		Address  string      `json:"address"`
+		Products []orderLine `json:"products"`
+	}
 
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"order": order}, nil)
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	order, items, ok := app.placeOrder(w, r, user.ID, input.Address, input.Total, input.Products, 0)
+	if !ok {
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"order": order, "order_items": items}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// orderSortSafelist holds the sort values an order listing accepts.
+var orderSortSafelist = []string{"id", "total", "created_at", "-id", "-total", "-created_at"}
+
 func (app *application) listOrdersHandler(w http.ResponseWriter, r *http.Request) {
-	orders, err := app.models.Orders.GetAll()
+	filters := app.readFilters(r.URL.Query(), 20, "-created_at", orderSortSafelist)
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orders, metadata, err := app.models.Orders.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"orders": orders}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"orders": orders, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) listUserOrdersHandler(w http.ResponseWriter, r *http.Request) {
-	userId := app.getUserIDFromHeader(w, r)
+	user := app.contextGetUser(r)
+	filters := app.readFilters(r.URL.Query(), 20, "-created_at", orderSortSafelist)
 
-	orders, err := app.models.Orders.GetAllForUser(int(userId))
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orders, metadata, err := app.models.Orders.GetAllForUser(int(user.ID), filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"orders": orders}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"orders": orders, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// orderItemView is an order item with the product, variant and references it
+// was sold as.
+type orderItemView struct {
+	ID          int64   `json:"id"`
+	ProductID   int64   `json:"product_id"`
+	VariantID   int64   `json:"variant_id"`
+	Name        string  `json:"name"`
+	VariantName string  `json:"variant_name,omitempty"`
+	Price       int64   `json:"price"`
+	Discount    int     `json:"discount_percent"`
+	UnitPrice   int64   `json:"unit_price"`
+	Description string  `json:"description"`
+	UPC         string  `json:"upc"`
+	Quantity    float64 `json:"quantity"`
+	Step        float64 `json:"step"`
+	Amount      float64 `json:"amount"`
+	Subtotal    int64   `json:"subtotal"`
+	Image       string  `json:"image"`
+	Unit        string  `json:"unit"`
+	Category    string  `json:"category"`
+	Brand       string  `json:"brand"`
+	Country     string  `json:"country"`
+}
+
+// viewOrderItem looks up what the order item refers to. Archived products and
+// references still resolve; whatever is missing altogether, e.g. the variant
+// of an item from before variants existed, is left blank.
+func (app *application) viewOrderItem(item *data.OrderItem) (*orderItemView, error) {
+	view := &orderItemView{
+		ID:        item.ID,
+		ProductID: item.ProductID,
+		VariantID: item.VariantID,
+		Price:     item.Price,
+		Discount:  item.DiscountPercent,
+		UnitPrice: item.UnitPrice(),
+		Amount:    item.Quantity,
+		Subtotal:  item.Total,
+	}
+
+	product, err := app.models.Products.Get(item.ProductID)
+	switch {
+	case err == nil:
+		view.Name = product.Name
+		view.Description = product.Description
+		view.Image = product.Image
+
+		if category, err := app.models.Category.Get(product.CategoryID); err == nil {
+			view.Category = category.Name
+		} else if !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		if brand, err := app.models.Brands.Get(product.BrandID); err == nil {
+			view.Brand = brand.Name
+		} else if !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		if country, err := app.models.Country.Get(product.CountryID); err == nil {
+			view.Country = country.Name
+		} else if !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, err
+		}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+
+	variant, err := app.models.Variants.Get(item.VariantID)
+	switch {
+	case err == nil:
+		view.VariantName = variant.Name
+		view.UPC = variant.UPC
+		view.Quantity = variant.Quantity
+		view.Step = variant.Step
+
+		if unit, err := app.models.Units.Get(variant.UnitID); err == nil {
+			view.Unit = unit.Name
+		} else if !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, err
+		}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+
+	return view, nil
+}
+
 func (app *application) showOrderHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
 	order, err := app.models.Orders.GetDB(id)
@@ -118,55 +319,28 @@ func (app *application) showOrderHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !app.canAccessOrder(r, order.UserID) {
+		app.NotEnoughPermissionResponse(w, r)
+		return
+	}
+
 	items, err := app.models.OrderItems.GetAllByOrder(order.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	type ProductItem struct {
-		ID          int64   `json:"id"`
-		ProductID   int64   `json:"product_id"`
-		Name        string  `json:"name"`
-		Price       int64   `json:"price"`
-		Description string  `json:"description"`
-		UPC         string  `json:"upc"`
-		Quantity    int64   `json:"quantity"`
-		Step        float64 `json:"step"`
-		Amount      float64 `json:"amount"`
-		Subtotal    int64   `json:"subtotal"`
-		Image       string  `json:"image"`
-		Unit        string  `json:"unit"`
-		Category    string  `json:"category"`
-		Brand       string  `json:"brand"`
-		Country     string  `json:"country"`
-	}
-
-	var productItems []ProductItem
+	productItems := make([]*orderItemView, 0, len(items))
 	for _, item := range items {
-		product, _ := app.models.Products.Get(item.ProductID)
-		unit, _ := app.models.Units.Get(product.UnitID)
-		category, _ := app.models.Category.Get(product.CategoryID)
-		brand, _ := app.models.Brands.Get(product.BrandID)
-		country, _ := app.models.Country.Get(product.CountryID)
-
-		productItem := ProductItem{
-			ID:          item.ID,
-			ProductID:   product.ID,
-			Name:        product.Name,
-			Price:       product.Price,
-			Description: product.Description,
-			Category:    category.Name,
-			UPC:         product.UPC,
-			Quantity:    product.Quantity,
-			Unit:        unit.Name,
-			Image:       product.Image,
-			Brand:       brand.Name,
-			Country:     country.Name,
-			Step:        product.Step,
-			Amount:      item.Quantity,
-			Subtotal:    item.Total,
+		productItem, err := app.viewOrderItem(item)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
 		}
 		productItems = append(productItems, productItem)
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"order": order, "order_items": productItems}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order, "order_items": productItems}, app.etagHeader(order.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -190,12 +364,21 @@ func (app *application) updateOrderHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !app.canAccessOrder(r, order.UserID) {
+		app.NotEnoughPermissionResponse(w, r)
+		return
+	}
+
+	if !app.ifMatch(r, order.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	var input struct {
-		UserID      *int64     `json:"user_id"`
-		Total       *int64     `json:"total"`
-		Address     *string    `json:"address"`
-		StatusID    *int64     `json:"status_id"`
-		DeliveredAt *time.Time `json:"delivered_at"`
+		UserID   *int64  `json:"user_id"`
+		Address  *string `json:"address"`
+		StatusID *int64  `json:"status_id"`
+		Note     string  `json:"note"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -204,39 +387,114 @@ func (app *application) updateOrderHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if input.UserID != nil {
-		order.UserID = *input.UserID
+	// Customers may only change the address of an order that has not been
+	// processed yet, or cancel it. Everything else is up to the staff.
+	if !app.isOrderManager(r) {
+		current, err := app.statusCode(order.StatusID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		var target string
+		if input.StatusID != nil {
+			target, err = app.statusCode(*input.StatusID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		switch {
+		case input.UserID != nil:
+			app.NotEnoughPermissionResponse(w, r)
+			return
+		case input.Address != nil && current != data.StatusOrdered:
+			app.NotEnoughPermissionResponse(w, r)
+			return
+		case input.StatusID != nil && target != data.StatusCancelled:
+			app.NotEnoughPermissionResponse(w, r)
+			return
+		}
 	}
 
-	if input.Total != nil {
-		order.Total = *input.Total
+	if input.UserID != nil {
+		order.UserID = *input.UserID
 	}
 
 	if input.Address != nil {
 		order.Address = *input.Address
 	}
 
-	if input.StatusID != nil {
-		order.StatusID = *input.StatusID
+	v := validator.New()
+	if data.ValidateOrder(v, order); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	if input.DeliveredAt != nil {
-		order.DeliveredAt = *input.DeliveredAt
+	var change *data.OrderStatusChange
+	if input.StatusID != nil && *input.StatusID != order.StatusID {
+		change = &data.OrderStatusChange{
+			ToStatusID: *input.StatusID,
+			ChangedBy:  app.contextGetUser(r).ID,
+			Note:       input.Note,
+		}
 	}
 
-	v := validator.New()
-	if data.ValidateOrder(v, order); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	statusID := order.StatusID
+
+	err = app.models.Orders.Update(order, change)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidTransition):
+			v.AddError("status_id", fmt.Sprintf("can not change status from %d to %d", statusID, *input.StatusID))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, app.etagHeader(order.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showOrderHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	order, err := app.models.Orders.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.models.Orders.Update(order)
+	if !app.canAccessOrder(r, order.UserID) {
+		app.NotEnoughPermissionResponse(w, r)
+		return
+	}
+
+	history, err := app.models.StatusHistory.GetAllForOrder(order.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"history": history}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -249,7 +507,23 @@ func (app *application) deleteOrderHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Orders.Delete(id)
+	order, err := app.models.Orders.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.canAccessOrder(r, order.UserID) {
+		app.NotEnoughPermissionResponse(w, r)
+		return
+	}
+
+	err = app.models.Orders.Delete(order.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+)
+
+// Policies decide whether the authenticated user may act on a resource.
+// Owners may always act on their own resources, staff with the matching
+// permission may act on everyone's.
+
+func (app *application) canAccessUser(r *http.Request, userID int64) bool {
+	return app.contextGetUser(r).ID == userID || app.isUserAdmin(r)
+}
+
+func (app *application) isUserAdmin(r *http.Request) bool {
+	return app.contextGetPermissions(r).Include(data.PermissionUsersAdmin)
+}
+
+func (app *application) canAccessOrder(r *http.Request, userID int64) bool {
+	return app.contextGetUser(r).ID == userID || app.isOrderManager(r)
+}
+
+func (app *application) isOrderManager(r *http.Request) bool {
+	return app.contextGetPermissions(r).Include(data.PermissionOrdersManage)
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/dexciuq/yummy-express-backend/internal/data"
 	"github.com/dexciuq/yummy-express-backend/internal/validator"
@@ -11,18 +11,14 @@ import (
 
 func (app *application) addProductHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name        string  `json:"name"`
-		Price       int64   `json:"price"`
-		Description string  `json:"description"`
-		CategoryID  int64   `json:"category_id"`
-		UPC         string  `json:"upc"`
-		DiscountID  int64   `json:"discount_id"`
-		Quantity    int64   `json:"quantity"`
-		UnitID      int64   `json:"unit_id"`
-		Image       string  `json:"image"`
-		BrandID     int64   `json:"brand_id"`
-		CountryID   int64   `json:"country_id"`
-		Step        float64 `json:"step"`
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		CategoryID  int64          `json:"category_id"`
+		DiscountID  int64          `json:"discount_id"`
+		Image       string         `json:"image"`
+		BrandID     int64          `json:"brand_id"`
+		CountryID   int64          `json:"country_id"`
+		Variants    []variantInput `json:"variants"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -33,26 +29,37 @@ func (app *application) addProductHandler(w http.ResponseWriter, r *http.Request
 
 	product := &data.Product{
 		Name:        input.Name,
-		Price:       input.Price,
 		Description: input.Description,
 		CategoryID:  input.CategoryID,
-		UPC:         input.UPC,
 		DiscountID:  input.DiscountID,
-		Quantity:    input.Quantity,
-		UnitID:      input.UnitID,
 		Image:       input.Image,
 		BrandID:     input.BrandID,
 		CountryID:   input.CountryID,
-		Step:        input.Step,
+	}
+	for _, variant := range input.Variants {
+		product.Variants = append(product.Variants, variant.variant())
 	}
 
 	v := validator.New()
+	v.Check(len(product.Variants) > 0, "variants", "must contain at least one variant")
 	if data.ValidateProduct(v, product); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	err = app.models.Products.Insert(product)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateUPC):
+			v.AddError("variants", "a variant with this upc already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	product.Breadcrumbs, err = app.models.Category.Path(product.CategoryID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -64,40 +71,110 @@ func (app *application) addProductHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// productSortSafelist holds the sort values a product listing accepts.
+var productSortSafelist = []string{
+	"id", "name", "price", data.SortEffectivePrice, data.SortDiscount, data.SortNewest, data.SortPopularity,
+	"-id", "-name", "-price", "-" + data.SortEffectivePrice, "-" + data.SortDiscount, "-" + data.SortNewest, "-" + data.SortPopularity,
+	data.SortRelevance,
+}
+
+// readProductFilter reads the filters shared by the product listings from
+// the query string.
+func (app *application) readProductFilter(qs url.Values) data.ProductFilter {
+	return data.ProductFilter{
+		Search:           app.readString(qs, "name", ""),
+		CategoryIDs:      app.readIntArray(qs, "category", []int{}),
+		BrandIDs:         app.readIntArray(qs, "brand", []int{}),
+		CountryIDs:       app.readIntArray(qs, "country", []int{}),
+		DiscountIDs:      app.readIntArray(qs, "discount", []int{}),
+		UnitID:           app.readInt(qs, "unit", 0),
+		MinPrice:         int64(app.readInt(qs, "min_price", 0)),
+		MaxPrice:         int64(app.readInt(qs, "max_price", 0)),
+		InStock:          app.readBool(qs, "in_stock", false),
+		OnSale:           app.readBool(qs, "on_sale", false),
+		Attributes:       app.readAttributeFilters(qs),
+		ExcludeAllergens: app.readCSV(qs, "exclude_allergens", nil),
+	}
+}
+
 func (app *application) listProductsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		CategoryID int
-		BrandIDs   []int
-		CountryID  int
-		Name       string
+		Filter data.ProductFilter
+		Facets bool
 		data.Filters
 	}
 
 	qs := r.URL.Query()
-	input.Name = app.readString(qs, "name", "")
-	//	fmt.Println("Input product name:", input.Name)
-	input.CategoryID = app.readInt(qs, "category", 0)
-	input.BrandIDs = app.readIntArray(qs, "brand", []int{})
-	input.CountryID = app.readInt(qs, "country", 0)
-	input.Filters.Page = app.readInt(qs, "page", 1)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 20)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "price",
-		"-id", "-name", "-price"}
+	input.Filter = app.readProductFilter(qs)
+	input.Facets = app.readBool(qs, "facets", false)
+	input.Filters = app.readFilters(qs, 20, "id", productSortSafelist)
 
 	v := validator.New()
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	data.ValidateFilters(v, input.Filters)
+	err := app.validateAttributeFilters(v, input.Filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateProductFilter(v, input.Filter); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	products, metadata, err := app.models.Products.GetAll(input.CategoryID, input.BrandIDs, input.CountryID, input.Name, input.Filters)
+	products, metadata, err := app.models.Products.GetAll(input.Filter, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"products": products, "metadata": metadata}, nil)
+	env := envelope{"products": products, "metadata": metadata}
+
+	if input.Facets {
+		facets, err := app.models.Products.Facets(input.Filter)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+
+	if len(products) == 0 && input.Filter.Search != "" {
+		correction, err := app.models.Products.DidYouMean(input.Filter.Search)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if correction != "" {
+			env["did_you_mean"] = correction
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) suggestProductsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	q := app.readString(qs, "q", "")
+	limit := app.readInt(qs, "limit", 5)
+
+	v := validator.New()
+	v.Check(len(q) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0 && limit <= 20, "limit", "must be between 1 and 20")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Products.Suggest(q, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -105,43 +182,28 @@ func (app *application) listProductsHandler(w http.ResponseWriter, r *http.Reque
 
 func (app *application) listProductsWithDiscountHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		CategoryID int
-		BrandIDs   []int
-		CountryID  int
-		Name       string
+		Filter data.ProductFilter
 		data.Filters
 	}
 
 	qs := r.URL.Query()
-	input.Name = app.readString(qs, "name", "")
-	//	fmt.Println("Input product name:", input.Name)
-	input.CategoryID = app.readInt(qs, "category", 0)
-	input.BrandIDs = app.readIntArray(qs, "brand", []int{})
-	input.CountryID = app.readInt(qs, "country", 0)
-	input.Filters.Page = app.readInt(qs, "page", 1)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 20)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "price",
-		"-id", "-name", "-price"}
+	input.Filter = app.readProductFilter(qs)
+	input.Filter.OnSale = true
+	input.Filters = app.readFilters(qs, 20, "id", productSortSafelist)
 
 	v := validator.New()
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-
-	discounts, err := app.models.Discount.GetAllActive()
+	data.ValidateFilters(v, input.Filters)
+	err := app.validateAttributeFilters(v, input.Filter)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	discountIDs := make([]int, len(discounts))
-	for i, discount := range discounts {
-		discountIDs[i] = int(discount.ID)
+	if data.ValidateProductFilter(v, input.Filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
-	fmt.Println("Active discounts", discountIDs)
 
-	products, metadata, err := app.models.Products.GetAllWithDiscounts(input.CategoryID, input.BrandIDs, discountIDs, input.CountryID, input.Name, input.Filters)
+	products, metadata, err := app.models.Products.GetAll(input.Filter, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -157,6 +219,7 @@ func (app *application) showProductHandler(w http.ResponseWriter, r *http.Reques
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
 	product, err := app.models.Products.GetDB(id)
@@ -170,7 +233,13 @@ func (app *application) showProductHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, nil)
+	// Archived records only resolve for the orders that refer to them.
+	if product.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, app.etagHeader(product.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -180,9 +249,10 @@ func (app *application) findProductByUPCHandler(w http.ResponseWriter, r *http.R
 	upc, err := app.readParamByNurik(r, "upc")
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
-	product, err := app.models.Products.GetByUPC(upc)
+	variant, err := app.models.Variants.GetByUPC(upc)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -193,7 +263,18 @@ func (app *application) findProductByUPCHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, nil)
+	product, err := app.models.Products.GetDB(variant.ProductID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if product.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"variant": variant, "product": product}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -217,19 +298,24 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if product.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.ifMatch(r, product.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	var input struct {
-		Name        *string  `json:"name"`
-		Price       *int64   `json:"price"`
-		Description *string  `json:"description"`
-		CategoryID  *int64   `json:"category_id"`
-		UPC         *string  `json:"upc"`
-		DiscountID  *int64   `json:"discount_id"`
-		Quantity    *int64   `json:"quantity"`
-		UnitID      *int64   `json:"unit_id"`
-		Image       *string  `json:"image"`
-		BrandID     *int64   `json:"brand_id"`
-		CountryID   *int64   `json:"country_id"`
-		Step        *float64 `json:"step"`
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		CategoryID  *int64  `json:"category_id"`
+		DiscountID  *int64  `json:"discount_id"`
+		Image       *string `json:"image"`
+		BrandID     *int64  `json:"brand_id"`
+		CountryID   *int64  `json:"country_id"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -242,10 +328,6 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		product.Name = *input.Name
 	}
 
-	if input.Price != nil {
-		product.Price = *input.Price
-	}
-
 	if input.Description != nil {
 		product.Description = *input.Description
 	}
@@ -254,22 +336,10 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		product.CategoryID = *input.CategoryID
 	}
 
-	if input.UPC != nil {
-		product.UPC = *input.UPC
-	}
-
 	if input.DiscountID != nil {
 		product.DiscountID = *input.DiscountID
 	}
 
-	if input.Quantity != nil {
-		product.Quantity = *input.Quantity
-	}
-
-	if input.UnitID != nil {
-		product.UnitID = *input.UnitID
-	}
-
 	if input.Image != nil {
 		product.Image = *input.Image
 	}
@@ -282,10 +352,6 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		product.CountryID = *input.CountryID
 	}
 
-	if input.Step != nil {
-		product.Step = *input.Step
-	}
-
 	v := validator.New()
 	if data.ValidateProduct(v, product); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
@@ -293,12 +359,23 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	err = app.models.Products.Update(product)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	product.Breadcrumbs, err = app.models.Category.Path(product.CategoryID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, app.etagHeader(product.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -322,7 +399,7 @@ func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "product successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "product successfully archived"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -5,102 +5,161 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/cors"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+	"github.com/dexciuq/yummy-express-backend/internal/storage"
 )
 
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.jwksHandler)
 
 	//products
-	router.HandlerFunc(http.MethodPost, "/v1/products", app.adminAuthMiddleware(app.addProductHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/products", app.requirePermission(data.PermissionProductsWrite, app.addProductHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/products", app.listProductsHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/products-wit-discount", app.listProductsWithDiscountHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/products/:id", app.showProductHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/products/:id", app.deleteProductHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/products/:id", app.updateProductHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/products/:id", app.staticOr(map[string]http.HandlerFunc{
+		"suggest": app.suggestProductsHandler,
+	}, app.showProductHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/products/:id", app.requirePermission(data.PermissionProductsWrite, app.deleteProductHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/products/:id", app.requirePermission(data.PermissionProductsWrite, app.updateProductHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/upc/:upc", app.findProductByUPCHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/exports/products", app.requirePermission(data.PermissionProductsWrite, app.exportProductsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/imports/products", app.requirePermission(data.PermissionProductsWrite, app.importProductsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/imports/commerceml", app.requirePermission(data.PermissionProductsWrite, app.importCommerceMLHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/exchange/1c", app.exchangeHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/exchange/1c", app.exchangeHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/products/:id/attributes", app.requirePermission(data.PermissionProductsWrite, app.setProductAttributesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/products/:id/nutrition", app.requirePermission(data.PermissionProductsWrite, app.setProductNutritionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/products/:id/variants", app.requirePermission(data.PermissionProductsWrite, app.addVariantHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/variants/:id", app.requirePermission(data.PermissionProductsWrite, app.updateVariantHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/variants/:id", app.requirePermission(data.PermissionProductsWrite, app.deleteVariantHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/products/:id/images", app.requirePermission(data.PermissionProductsWrite, app.addProductImageHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/products/:id/images", app.listProductImagesHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/products/:id/images/order", app.requirePermission(data.PermissionProductsWrite, app.reorderProductImagesHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/products/:id/images/:image_id", app.requirePermission(data.PermissionProductsWrite, app.updateProductImageHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/products/:id/images/:image_id", app.requirePermission(data.PermissionProductsWrite, app.deleteProductImageHandler))
+
+	//attributes
+	router.HandlerFunc(http.MethodPost, "/v1/attributes", app.requirePermission(data.PermissionCatalogWrite, app.addAttributeHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/attributes", app.listAttributesHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/attributes/:id", app.showAttributeHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/attributes/:id", app.requirePermission(data.PermissionCatalogWrite, app.deleteAttributeHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/attributes/:id", app.requirePermission(data.PermissionCatalogWrite, app.updateAttributeHandler))
 
 	//categories
-	router.HandlerFunc(http.MethodPost, "/v1/categories", app.addCategoryHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/categories", app.requirePermission(data.PermissionCatalogWrite, app.addCategoryHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/categories", app.listCategoriesHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/categories/:id", app.showCategoryHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/categories/:id", app.deleteCategoryHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/categories/:id", app.updateCategoryHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:id", app.staticOr(map[string]http.HandlerFunc{
+		"tree": app.showCategoryTreeHandler,
+	}, app.showCategoryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/categories/:id", app.requirePermission(data.PermissionCatalogWrite, app.deleteCategoryHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/categories/:id", app.requirePermission(data.PermissionCatalogWrite, app.updateCategoryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/categories/:id/image", app.requirePermission(data.PermissionCatalogWrite, app.uploadCategoryImageHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/categories/:id/move", app.requirePermission(data.PermissionCatalogWrite, app.moveCategoryHandler))
 
 	//units
-	router.HandlerFunc(http.MethodPost, "/v1/units", app.addUnitHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/units", app.requirePermission(data.PermissionCatalogWrite, app.addUnitHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/units", app.listUnitsHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/units/:id", app.showUnitHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/units/:id", app.deleteUnitHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/units/:id", app.updateUnitHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/units/:id", app.requirePermission(data.PermissionCatalogWrite, app.deleteUnitHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/units/:id", app.requirePermission(data.PermissionCatalogWrite, app.updateUnitHandler))
 
 	//brands
-	router.HandlerFunc(http.MethodPost, "/v1/brands", app.addBrandHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/brands", app.requirePermission(data.PermissionCatalogWrite, app.addBrandHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/brands", app.listBrandsHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/brands/:id", app.showBrandHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/brands/:id", app.deleteBrandHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/brands/:id", app.updateBrandHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/brands/:id", app.requirePermission(data.PermissionCatalogWrite, app.deleteBrandHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/brands/:id", app.requirePermission(data.PermissionCatalogWrite, app.updateBrandHandler))
 
 	//countries
-	router.HandlerFunc(http.MethodPost, "/v1/countries", app.addCountryHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/countries", app.requirePermission(data.PermissionCatalogWrite, app.addCountryHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/countries", app.listCountriesHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/countries/:id", app.showCountryHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/countries/:id", app.deleteCountryHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/countries/:id", app.updateCountryHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/countries/:id", app.requirePermission(data.PermissionCatalogWrite, app.deleteCountryHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/countries/:id", app.requirePermission(data.PermissionCatalogWrite, app.updateCountryHandler))
 
 	//discounts
-	router.HandlerFunc(http.MethodPost, "/v1/discounts", app.addDiscountHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/discounts", app.requirePermission(data.PermissionDiscountsWrite, app.addDiscountHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/discounts", app.listDiscountsHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/discounts/:id", app.showDiscountHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/discounts/:id", app.deleteDiscountHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/discounts/:id", app.updateDiscountHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/discounts/:id", app.requirePermission(data.PermissionDiscountsWrite, app.deleteDiscountHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/discounts/:id", app.requirePermission(data.PermissionDiscountsWrite, app.updateDiscountHandler))
 
 	//roles
-	router.HandlerFunc(http.MethodPost, "/v1/roles", app.addRoleHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/roles", app.requirePermission(data.PermissionRolesManage, app.addRoleHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/roles", app.listRolesHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/roles/:id", app.showRoleHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/roles/:id", app.deleteRoleHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/roles/:id", app.updateRoleHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/roles/:id", app.requirePermission(data.PermissionRolesManage, app.deleteRoleHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/roles/:id", app.requirePermission(data.PermissionRolesManage, app.updateRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/roles/:id/permissions", app.requirePermission(data.PermissionRolesManage, app.showRolePermissionsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/roles/:id/permissions", app.requirePermission(data.PermissionRolesManage, app.updateRolePermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/permissions", app.requirePermission(data.PermissionRolesManage, app.listPermissionsHandler))
 
 	//statuses
-	router.HandlerFunc(http.MethodPost, "/v1/statuses", app.addStatusHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/statuses", app.requirePermission(data.PermissionStatusesManage, app.addStatusHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/statuses", app.listStatusesHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/statuses/:id", app.showStatusHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/statuses/:id", app.deleteStatusHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/statuses/:id", app.updateStatusHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/statuses/:id", app.requirePermission(data.PermissionStatusesManage, app.deleteStatusHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/statuses/:id", app.requirePermission(data.PermissionStatusesManage, app.updateStatusHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/auth/register", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/auth/authenticate", app.authenticateUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/auth/register", app.rateLimitRoute(registerPolicy, app.registerUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/auth/authenticate", app.rateLimitRoute(authenticatePolicy, app.authenticateUserHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/auth/logout", app.authMiddleware(app.logoutUserHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/auth/refresh", app.refreshHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/request-password-reset", app.requestPasswordResetHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/verify-reset-code", app.verifyResetCodeHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/reset-password", app.resetPasswordHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/auth/refresh", app.refreshHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/request-password-reset", app.rateLimitRoute(passwordResetPolicy, app.requestPasswordResetHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/verify-reset-code", app.rateLimitRoute(passwordResetPolicy, app.verifyResetCodeHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/reset-password", app.rateLimitRoute(passwordResetPolicy, app.resetPasswordHandler))
 
-	router.HandlerFunc(http.MethodGet, "/v1/users/:id", app.showUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id", app.authMiddleware(app.showUserHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/users/:id", app.authMiddleware(app.updateUserHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/users/:id", app.authMiddleware(app.deleteUserHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/profile/me", app.authMiddleware(app.getUserInformationByToken))
+	router.HandlerFunc(http.MethodGet, "/v1/profile/sessions", app.authMiddleware(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/profile/sessions", app.authMiddleware(app.revokeAllSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/profile/sessions/:id", app.authMiddleware(app.revokeSessionHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/auth/activate/:uuid", app.activateUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/auth/unlock/:token", app.unlockAccountHandler)
 
 	//orders
-	router.HandlerFunc(http.MethodPost, "/v1/orders", app.addOrderHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/orders", app.listOrdersHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/orders", app.authMiddleware(app.addOrderHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/orders", app.requirePermission(data.PermissionOrdersManage, app.listOrdersHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/profile/orders", app.authMiddleware(app.listUserOrdersHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/orders/:id", app.showOrderHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/orders/:id", app.deleteOrderHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/orders/:id", app.updateOrderHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/orders/:id", app.authMiddleware(app.showOrderHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/orders/:id", app.requirePermission(data.PermissionOrdersManage, app.deleteOrderHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/orders/:id", app.authMiddleware(app.updateOrderHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/orders/:id/history", app.authMiddleware(app.showOrderHistoryHandler))
+
+	//cart
+	router.HandlerFunc(http.MethodGet, "/v1/cart", app.showCartHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/cart/items", app.addCartItemHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/cart/items/:id", app.updateCartItemHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/cart/items/:id", app.deleteCartItemHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/cart/checkout", app.authMiddleware(app.checkoutCartHandler))
 
 	//order-items
-	router.HandlerFunc(http.MethodPatch, "/v1/order-items/:id", app.updateOrderItemHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/order-items/:id", app.requirePermission(data.PermissionOrdersManage, app.updateOrderItemHandler))
+
+	//archive
+	router.HandlerFunc(http.MethodGet, "/v1/archive/:kind", app.authMiddleware(app.listArchiveHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/archive/:kind/:id/restore", app.authMiddleware(app.restoreArchivedHandler))
+
+	// Uploads kept on the local disk are served by the API itself.
+	if local, ok := app.storage.(*storage.Local); ok {
+		prefix := mediaPath(local.BaseURL)
+		router.Handler(http.MethodGet, prefix+"/*filepath", app.mediaHandler(prefix, local.Dir))
+	}
 
 	// Enable CORS
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PATCH", "DELETE"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
 		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"ETag", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"},
 	})
 
-	return c.Handler(router)
+	return c.Handler(app.rateLimit(router))
 }
@@ -32,6 +32,7 @@ func (app *application) serve() error {
 		defer cancel()
 
 		err := srv.Shutdown(ctx)
+		close(app.done)
 		if err != nil {
 			shutdownError <- err
 		}
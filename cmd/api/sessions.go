@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+)
+
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Sessions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	current := app.contextGetSessionID(r)
+	for _, session := range sessions {
+		session.Current = session.ID == current
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Sessions.Revoke(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Sessions.RevokeAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	logoutCookie := http.Cookie{
+		Name:   "refreshToken",
+		MaxAge: -1,
+	}
+	http.SetCookie(w, &logoutCookie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all sessions successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -44,12 +44,20 @@ func (app *application) addStatusHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *application) listStatusesHandler(w http.ResponseWriter, r *http.Request) {
-	statuses, err := app.models.Statuses.GetAll()
+	filters := app.readFilters(r.URL.Query(), 100, "id", referenceSortSafelist)
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	statuses, metadata, err := app.models.Statuses.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"statuses": statuses}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"statuses": statuses, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -145,6 +153,8 @@ func (app *application) deleteStatusHandler(w http.ResponseWriter, r *http.Reque
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrWorkflowStatus):
+			app.workflowStatusResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+)
+
+// accountThrottleKey identifies an account by the email it was tried with,
+// so that guesses against unknown emails are throttled the same way.
+func accountThrottleKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// attemptRetryAfter returns how long the client has to wait before it may
+// try again, taking both its IP address and, if given, the account into
+// account.
+func (app *application) attemptRetryAfter(r *http.Request, email string) (time.Duration, error) {
+	now := time.Now()
+
+	throttle, err := app.models.Throttles.Get(data.ThrottleScopeIP, app.clientIP(r))
+	if err != nil {
+		return 0, err
+	}
+	wait := throttle.RetryAfter(data.IPThrottlePolicy, now)
+
+	if email != "" {
+		throttle, err = app.models.Throttles.Get(data.ThrottleScopeAccount, accountThrottleKey(email))
+		if err != nil {
+			return 0, err
+		}
+		if accountWait := throttle.RetryAfter(data.AccountThrottlePolicy, now); accountWait > wait {
+			wait = accountWait
+		}
+	}
+
+	return wait, nil
+}
+
+// recordFailedAttempt counts a failed attempt against the client IP and, if
+// given, the account. When the failure locks an existing account its owner
+// gets an email with a link to unlock it.
+func (app *application) recordFailedAttempt(r *http.Request, email string, user *data.User) error {
+	_, err := app.models.Throttles.RecordFailure(data.ThrottleScopeIP, app.clientIP(r), data.IPThrottlePolicy)
+	if err != nil {
+		return err
+	}
+
+	if email == "" {
+		return nil
+	}
+
+	throttle, err := app.models.Throttles.RecordFailure(data.ThrottleScopeAccount, accountThrottleKey(email), data.AccountThrottlePolicy)
+	if err != nil {
+		return err
+	}
+
+	if user == nil || !throttle.Locked(throttle.LastFailedAt) {
+		return nil
+	}
+
+	token, err := app.models.Throttles.CreateUnlockToken(user.ID, *throttle.LockedUntil)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"name":        user.FirstName + " " + user.LastName,
+			"email":       user.Email,
+			"token":       token,
+			"lockedUntil": throttle.LockedUntil.Format("02.01.2006 15:04 MST"),
+		}
+		err := app.mailer.Send(user.Email, "account_unlock.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	return nil
+}
+
+func (app *application) unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	templName := "account_unlock"
+
+	token, _ := app.readParamByNurik(r, "token")
+
+	userID, err := app.models.Throttles.UseUnlockToken(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.renderTemplate(w, r, templName, map[string]interface{}{
+				"title":   "Error",
+				"message": "Invalid or expired unlock link.",
+			})
+		default:
+			app.renderTemplate(w, r, templName, map[string]interface{}{
+				"title":   "Error",
+				"message": "Internal server error.",
+			})
+		}
+		return
+	}
+
+	user, err := app.models.Users.GetById(userID)
+	if err != nil {
+		app.renderTemplate(w, r, templName, map[string]interface{}{
+			"title":   "Error",
+			"message": "Internal server error.",
+		})
+		return
+	}
+
+	err = app.models.Throttles.Reset(data.ThrottleScopeAccount, accountThrottleKey(user.Email))
+	if err != nil {
+		app.renderTemplate(w, r, templName, map[string]interface{}{
+			"title":   "Error",
+			"message": "Internal server error.",
+		})
+		return
+	}
+
+	app.renderTemplate(w, r, templName, map[string]interface{}{
+		"title":   "Account Unlocked",
+		"message": "Your account has been unlocked, you can sign in again.",
+	})
+}
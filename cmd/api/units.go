@@ -44,12 +44,20 @@ func (app *application) addUnitHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) listUnitsHandler(w http.ResponseWriter, r *http.Request) {
-	units, err := app.models.Units.GetAll()
+	filters := app.readFilters(r.URL.Query(), 100, "id", referenceSortSafelist)
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	units, metadata, err := app.models.Units.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"units": units}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"units": units, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -72,6 +80,12 @@ func (app *application) showUnitHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Archived records only resolve for the orders that refer to them.
+	if unit.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"unit": unit}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -151,7 +165,7 @@ func (app *application) deleteUnitHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "unit successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "unit successfully archived"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
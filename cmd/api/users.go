@@ -20,7 +20,6 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		PhoneNumber string `json:"phone_number"`
 		Email       string `json:"email"`
 		Password    string `json:"password"`
-		Role_ID     int64  `json:"role_id"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -34,7 +33,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		LastName:    input.LastName,
 		PhoneNumber: input.PhoneNumber,
 		Email:       input.Email,
-		Role_ID:     input.Role_ID,
+		Role_ID:     app.models.Roles.GetDefaultRoleID(),
 	}
 
 	err = user.Password.Set(input.Password)
@@ -184,8 +183,9 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+		CartToken string `json:"cart_token"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -194,6 +194,10 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	if input.CartToken == "" {
+		input.CartToken = r.Header.Get(cartTokenHeader)
+	}
+
 	v := validator.New()
 	data.ValidateEmail(v, input.Email)
 	data.ValidatePasswordPlaintext(v, input.Password)
@@ -202,10 +206,24 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	retryAfter, err := app.attemptRetryAfter(r, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			if err = app.recordFailedAttempt(r, input.Email, nil); err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -213,11 +231,6 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	if !user.Activated {
-		app.notActivatedResponse(w, r)
-		return
-	}
-
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -225,17 +238,36 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 	}
 
 	if !match {
+		if err = app.recordFailedAttempt(r, input.Email, user); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	token, err := data.GenerateTokens(user.ID, user.Role_ID)
+	err = app.models.Throttles.Reset(data.ThrottleScopeAccount, accountThrottleKey(input.Email))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	if err = app.models.Tokens.SaveToken(token); err != nil {
+	if !user.Activated {
+		app.notActivatedResponse(w, r)
+		return
+	}
+
+	if input.CartToken != "" {
+		err = app.mergeGuestCart(user.ID, input.CartToken)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	token, err := app.models.Sessions.Create(user.ID, user.Role_ID, r.UserAgent(), app.clientIP(r))
+	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	refreshTokenCookie := http.Cookie{
@@ -245,92 +277,71 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 		MaxAge:   30 * 24 * 60 * 60,
 	}
 
-	accessTokenMap, _ := data.DecodeAccessToken(token.AccessToken)
-	exp := int64(accessTokenMap["exp"].(float64))
-	expUnix := time.Unix(exp, 0)
-
-	if time.Now().After(expUnix) {
-		fmt.Println("access token expired")
-		//				app.errorResponse(w, r, http.StatusUnauthorized, "access token was expired")
-	}
-
-	refreshTokenMap, _ := data.DecodeRefreshToken(token.RefreshToken)
-	exp = int64(refreshTokenMap["exp"].(float64))
-	expUnix = time.Unix(exp, 0)
-
-	if time.Now().After(expUnix) {
-		fmt.Println("refresh token expired")
-		//				app.errorResponse(w, r, http.StatusUnauthorized, "access token was expired")
-	}
-	fmt.Println("-------------------------------")
-
 	http.SetCookie(w, &refreshTokenCookie)
 	if err = app.writeJSON(w, http.StatusOK, envelope{"refreshToken": token.RefreshToken, "accessToken": token.AccessToken}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// refreshHandler exchanges a refresh token for a new token pair. The refresh
+// token is rotated on every call, so the client must store the returned one.
 func (app *application) refreshHandler(w http.ResponseWriter, r *http.Request) {
-	authorizationHeader := r.Header.Get("Authorization")
-	if authorizationHeader == "" {
+	refreshToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if refreshToken == "" {
+		cookie, err := r.Cookie("refreshToken")
+		if err == nil {
+			refreshToken = cookie.Value
+		}
+	}
+	if refreshToken == "" {
 		app.UserUnauthorizedResponse(w, r)
+		return
 	}
 
-	refreshToken := strings.TrimPrefix(authorizationHeader, "Bearer ")
-
-	accessToken, err := app.models.Tokens.RefreshAccessToken(refreshToken)
+	token, err := app.models.Sessions.Rotate(refreshToken, r.UserAgent(), app.clientIP(r))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrTokenExpired):
-			//			fmt.Println("Refresh/maybe token expired")
 			app.errorResponse(w, r, http.StatusUnauthorized, "Refresh/maybe token expired")
+		case errors.Is(err, data.ErrTokenReused):
+			app.errorResponse(w, r, http.StatusUnauthorized, "refresh token was already used, the session has been revoked")
+		case errors.Is(err, data.ErrInvalidToken), errors.Is(err, data.ErrRecordNotFound), errors.Is(err, data.ErrSessionRevoked):
+			app.UserUnauthorizedResponse(w, r)
 		default:
-			//			fmt.Println("Not refresh/maybe token expired")
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	if accessToken == "" {
-		app.serverErrorResponse(w, r, data.ErrTokenExpired)
+
+	refreshTokenCookie := http.Cookie{
+		Name:     "refreshToken",
+		Value:    token.RefreshToken,
+		HttpOnly: true,
+		MaxAge:   30 * 24 * 60 * 60,
 	}
 
-	if err = app.writeJSON(w, http.StatusOK, envelope{"accessToken": accessToken}, nil); err != nil {
+	http.SetCookie(w, &refreshTokenCookie)
+	if err = app.writeJSON(w, http.StatusOK, envelope{"refreshToken": token.RefreshToken, "accessToken": token.AccessToken}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) getUserInformationByToken(w http.ResponseWriter, r *http.Request) {
-	userId := app.getUserIDFromHeader(w, r)
-
-	user, err := app.models.Users.GetById(int64(userId))
+	user := app.contextGetUser(r)
 
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
-		return
-	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request) {
-	userId := app.getUserIDFromHeader(w, r)
+	user := app.contextGetUser(r)
 
-	token, err := app.models.Tokens.FindTokenByUserId(int64(userId))
-	if err != nil {
-		app.UserUnauthorizedResponse(w, r)
-	}
-
-	err = app.models.Tokens.RemoveToken(token.RefreshToken)
-
-	if err != nil {
+	err := app.models.Sessions.Revoke(app.contextGetSessionID(r), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 	logoutCookie := http.Cookie{
 		Name:   "refreshToken",
@@ -351,6 +362,11 @@ func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !app.canAccessUser(r, id) {
+		app.NotEnoughPermissionResponse(w, r)
+		return
+	}
+
 	user, err := app.models.Users.GetById(id)
 	if err != nil {
 		switch {
@@ -374,6 +390,11 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !app.canAccessUser(r, id) {
+		app.NotEnoughPermissionResponse(w, r)
+		return
+	}
+
 	user, err := app.models.Users.GetById(id)
 	if err != nil {
 		switch {
@@ -392,6 +413,7 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		Email       *string `json:"email"`
 		Password    *string `json:"password"`
 		Role_ID     *int64  `json:"role_id"`
+		Activated   *bool   `json:"is_activated"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -400,6 +422,11 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if (input.Role_ID != nil || input.Activated != nil) && !app.isUserAdmin(r) {
+		app.NotEnoughPermissionResponse(w, r)
+		return
+	}
+
 	if input.FirstName != nil {
 		user.FirstName = *input.FirstName
 	}
@@ -413,15 +440,48 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		user.Email = *input.Email
 	}
 	if input.Password != nil {
-		user.Password.Set(*input.Password)
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 	if input.Role_ID != nil {
+		_, err = app.models.Roles.Get(*input.Role_ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v := validator.New()
+				v.AddError("role_id", "must reference an existing role")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
 		user.Role_ID = *input.Role_ID
 	}
+	if input.Activated != nil {
+		user.Activated = *input.Activated
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
 	err = app.models.Users.Update(user)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -438,6 +498,11 @@ func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !app.canAccessUser(r, id) {
+		app.NotEnoughPermissionResponse(w, r)
+		return
+	}
+
 	err = app.models.Users.Delete(id)
 	if err != nil {
 		switch {
@@ -449,7 +514,7 @@ func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully archived"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -473,24 +538,32 @@ func (app *application) requestPasswordResetHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
+	// The response is the same whether the email is registered or not, so
+	// the endpoint can't be used to find out who has an account.
+	message := "If the email is registered, a password reset code has been sent to it"
+
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-	if user == nil {
-		app.notFoundResponse(w, r)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	code, err := data.GenerateResetCode(user)
+	code, err := data.GenerateResetCode()
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	expiration := time.Now().Add(1 * time.Hour)
-	err = app.models.Users.InsertPasswordResetCode(user.ID, code, expiration)
+	err = app.models.Users.InsertPasswordResetCode(user.ID, user.Email, code, expiration)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -509,7 +582,7 @@ func (app *application) requestPasswordResetHandler(w http.ResponseWriter, r *ht
 		}
 	})
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Password reset code has been sent to your email"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -518,7 +591,8 @@ func (app *application) requestPasswordResetHandler(w http.ResponseWriter, r *ht
 
 func (app *application) verifyResetCodeHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Code string `json:"code"`
+		Email string `json:"email"`
+		Code  string `json:"code"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -527,14 +601,15 @@ func (app *application) verifyResetCodeHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	resetCode, err := app.models.Users.ValidatePasswordResetCode(input.Code)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Code != "", "code", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	if resetCode == nil || time.Now().After(resetCode.ExpiresAt) {
-		app.invalidCredentialsResponse(w, r)
+	if _, ok := app.checkResetCode(w, r, input.Email, input.Code); !ok {
 		return
 	}
 
@@ -546,6 +621,7 @@ func (app *application) verifyResetCodeHandler(w http.ResponseWriter, r *http.Re
 
 func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
+		Email       string `json:"email"`
 		Code        string `json:"code"`
 		NewPassword string `json:"new_password"`
 	}
@@ -556,15 +632,20 @@ func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	resetCode, err := app.models.Users.ValidatePasswordResetCode(input.Code)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Code != "", "code", "must be provided")
+	data.ValidatePasswordPlaintext(v, input.NewPassword)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	if resetCode == nil || time.Now().After(resetCode.ExpiresAt) {
-		app.errorResponse(w, r, http.StatusBadRequest, "Invalid or expired code")
+
+	resetCode, ok := app.checkResetCode(w, r, input.Email, input.Code)
+	if !ok {
 		return
 	}
+
 	user, err := app.models.Users.GetById(resetCode.User_ID)
 	if err != nil {
 		switch {
@@ -576,22 +657,33 @@ func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	v := validator.New()
-	data.ValidatePasswordPlaintext(v, input.NewPassword)
-	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	user.Password.Set(input.NewPassword)
-
 	err = app.models.Users.Update(user)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.models.Users.DeletePasswordResetCode(input.Code)
+	err = app.models.Users.DeletePasswordResetCode(resetCode.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Whoever knew the old password is signed out, and the owner may sign in
+	// again even if the account was locked.
+	err = app.models.Sessions.RevokeAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Throttles.Reset(data.ThrottleScopeAccount, accountThrottleKey(user.Email))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -602,3 +694,36 @@ func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Requ
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// checkResetCode checks a reset code on behalf of verifyResetCodeHandler and
+// resetPasswordHandler. Wrong codes count as failed attempts of the client
+// IP. It writes the error response itself and reports whether the code is
+// valid.
+func (app *application) checkResetCode(w http.ResponseWriter, r *http.Request, email, code string) (*data.PasswordResetCode, bool) {
+	retryAfter, err := app.attemptRetryAfter(r, "")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if retryAfter > 0 {
+		app.tooManyAttemptsResponse(w, r, retryAfter)
+		return nil, false
+	}
+
+	resetCode, err := app.models.Users.CheckPasswordResetCode(email, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidResetCode):
+			if err = app.recordFailedAttempt(r, "", nil); err != nil {
+				app.serverErrorResponse(w, r, err)
+				return nil, false
+			}
+			app.errorResponse(w, r, http.StatusBadRequest, "Invalid or expired code")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return resetCode, true
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+)
+
+var errVariantRequired = errors.New("variant required")
+
+// variantInput is the request body of a new variant.
+type variantInput struct {
+	Name       string  `json:"name"`
+	UPC        string  `json:"upc"`
+	Price      int64   `json:"price"`
+	Quantity   float64 `json:"quantity"`
+	UnitID     int64   `json:"unit_id"`
+	Step       float64 `json:"step"`
+	DiscountID int64   `json:"discount_id"`
+}
+
+func (input variantInput) variant() *data.ProductVariant {
+	return &data.ProductVariant{
+		Name:       input.Name,
+		UPC:        input.UPC,
+		Price:      input.Price,
+		Quantity:   input.Quantity,
+		UnitID:     input.UnitID,
+		Step:       input.Step,
+		DiscountID: input.DiscountID,
+	}
+}
+
+// resolveVariant finds the variant to sell for a line of a cart or order. A
+// line may name the product only if it has a single variant. The variant must
+// belong to the product if both are given, and archived products and
+// variants are not sold.
+func (app *application) resolveVariant(productID, variantID int64) (*data.Product, *data.ProductVariant, error) {
+	var variant *data.ProductVariant
+
+	if variantID != 0 {
+		var err error
+		variant, err = app.models.Variants.Get(variantID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if variant.DeletedAt != nil || productID != 0 && variant.ProductID != productID {
+			return nil, nil, data.ErrRecordNotFound
+		}
+	} else {
+		variants, err := app.models.Variants.GetAllForProduct(productID)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch len(variants) {
+		case 0:
+			return nil, nil, data.ErrRecordNotFound
+		case 1:
+			variant = variants[0]
+		default:
+			return nil, nil, errVariantRequired
+		}
+	}
+
+	product, err := app.models.Products.Get(variant.ProductID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if product.DeletedAt != nil {
+		return nil, nil, data.ErrRecordNotFound
+	}
+	return product, variant, nil
+}
+
+// variantDiscount returns the discount that applies to the variant, or nil
+// if neither the variant nor its product has one.
+func (app *application) variantDiscount(product *data.Product, variant *data.ProductVariant) (*data.Discount, error) {
+	discountID := variant.EffectiveDiscountID(product)
+	if discountID == 0 {
+		return nil, nil
+	}
+
+	discount, err := app.models.Discount.Get(discountID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	return discount, nil
+}
+
+func (app *application) addVariantHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if product.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input variantInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	variant := input.variant()
+	variant.ProductID = product.ID
+
+	v := validator.New()
+	if data.ValidateVariant(v, variant); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Variants.Insert(variant)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateUPC):
+			v.AddError("upc", "a variant with this upc already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"variant": variant}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateVariantHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	variant, err := app.models.Variants.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if variant.DeletedAt != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.ifMatch(r, variant.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name       *string  `json:"name"`
+		UPC        *string  `json:"upc"`
+		Price      *int64   `json:"price"`
+		Quantity   *float64 `json:"quantity"`
+		UnitID     *int64   `json:"unit_id"`
+		Step       *float64 `json:"step"`
+		DiscountID *int64   `json:"discount_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		variant.Name = *input.Name
+	}
+
+	if input.UPC != nil {
+		variant.UPC = *input.UPC
+	}
+
+	if input.Price != nil {
+		variant.Price = *input.Price
+	}
+
+	if input.Quantity != nil {
+		variant.Quantity = *input.Quantity
+	}
+
+	if input.UnitID != nil {
+		variant.UnitID = *input.UnitID
+	}
+
+	if input.Step != nil {
+		variant.Step = *input.Step
+	}
+
+	if input.DiscountID != nil {
+		variant.DiscountID = *input.DiscountID
+	}
+
+	v := validator.New()
+	if data.ValidateVariant(v, variant); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Variants.Update(variant)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateUPC):
+			v.AddError("upc", "a variant with this upc already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"variant": variant}, app.etagHeader(variant.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteVariantHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Variants.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastVariant):
+			app.lastVariantResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "variant successfully archived"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
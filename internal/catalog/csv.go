@@ -0,0 +1,147 @@
+// Package catalog reads and writes the product catalog in the file formats
+// merchandisers keep it in.
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+)
+
+// ImportColumns are the columns a CSV import may have, named by its header
+// row in any order. Only upc is required. The other columns of an export are
+// skipped, so that exported files can be imported again.
+var ImportColumns = []string{
+	"name", "description", "category", "brand", "country", "discount", "image",
+	"variant", "upc", "price", "quantity", "unit", "step",
+}
+
+// ReadCSV reads the rows of a CSV import. The separator is a comma, or a
+// semicolon if the header has no commas, as spreadsheets in many locales
+// write. Values that fail to parse are recorded in the row's Errors rather
+// than failing the whole file.
+func ReadCSV(r io.Reader) ([]*data.ImportRow, error) {
+	br := bufio.NewReader(r)
+
+	// Spreadsheets often start UTF-8 files with a byte order mark.
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+
+	// Peek returns what the reads above buffered, which holds the header
+	// unless it is unusually long.
+	header, _ := br.Peek(br.Buffered())
+	header, _, _ = bytes.Cut(header, []byte("\n"))
+
+	cr := csv.NewReader(br)
+	if !bytes.ContainsRune(header, ',') && bytes.ContainsRune(header, ';') {
+		cr.Comma = ';'
+	}
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	columns, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("the file is empty")
+		}
+		return nil, err
+	}
+
+	index := map[string]int{}
+	for i, column := range columns {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !isImportColumn(column) && !isExportColumn(column) {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+		if _, ok := index[column]; ok {
+			return nil, fmt.Errorf("duplicate column %q", column)
+		}
+		index[column] = i
+	}
+
+	if _, ok := index["upc"]; !ok {
+		return nil, errors.New(`missing column "upc"`)
+	}
+
+	rows := []*data.ImportRow{}
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+		field := func(column string) string {
+			i, ok := index[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row := &data.ImportRow{
+			Line:        line,
+			Name:        field("name"),
+			Description: field("description"),
+			Category:    field("category"),
+			Brand:       field("brand"),
+			Country:     field("country"),
+			Discount:    field("discount"),
+			Image:       field("image"),
+			Variant:     field("variant"),
+			UPC:         field("upc"),
+			Unit:        field("unit"),
+			Errors:      map[string]string{},
+		}
+
+		if s := field("price"); s != "" {
+			price, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				row.Errors["price"] = "must be an integer"
+			} else {
+				row.Price = &price
+			}
+		}
+		row.Quantity = parseFloat(row, "quantity", field("quantity"))
+		row.Step = parseFloat(row, "step", field("step"))
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// parseFloat reads a decimal number, accepting a decimal comma.
+func parseFloat(row *data.ImportRow, column, s string) *float64 {
+	if s == "" {
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil {
+		row.Errors[column] = "must be a number"
+		return nil
+	}
+	return &f
+}
+
+func isImportColumn(column string) bool {
+	for _, c := range ImportColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
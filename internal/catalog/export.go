@@ -0,0 +1,150 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+)
+
+// exportColumn is a column of a tabular export. Columns that share a name
+// with an import column hold what the import expects, so an exported file
+// can be edited and imported again.
+type exportColumn struct {
+	name  string
+	value func(row *data.ProductExportRow) any
+}
+
+var exportColumns = []exportColumn{
+	{"product_id", func(row *data.ProductExportRow) any { return row.ProductID }},
+	{"name", func(row *data.ProductExportRow) any { return row.Name }},
+	{"description", func(row *data.ProductExportRow) any { return row.Description }},
+	{"image", func(row *data.ProductExportRow) any { return row.Image }},
+	{"category", func(row *data.ProductExportRow) any { return row.CategoryName }},
+	{"brand", func(row *data.ProductExportRow) any { return row.BrandName }},
+	{"country", func(row *data.ProductExportRow) any { return row.CountryName }},
+	{"discount", func(row *data.ProductExportRow) any { return row.DiscountName }},
+	{"variant", func(row *data.ProductExportRow) any { return row.VariantName }},
+	{"upc", func(row *data.ProductExportRow) any { return row.UPC }},
+	{"price", func(row *data.ProductExportRow) any { return row.Price }},
+	{"quantity", func(row *data.ProductExportRow) any { return row.Quantity }},
+	{"unit", func(row *data.ProductExportRow) any { return row.UnitName }},
+	{"step", func(row *data.ProductExportRow) any { return row.Step }},
+	{"variant_id", func(row *data.ProductExportRow) any { return row.VariantID }},
+	{"category_id", func(row *data.ProductExportRow) any { return row.CategoryID }},
+	{"brand_id", func(row *data.ProductExportRow) any { return row.BrandID }},
+	{"country_id", func(row *data.ProductExportRow) any { return row.CountryID }},
+	{"alpha2", func(row *data.ProductExportRow) any { return row.Alpha2 }},
+	{"discount_id", func(row *data.ProductExportRow) any { return row.DiscountID }},
+	{"discount_percent", func(row *data.ProductExportRow) any { return row.DiscountPercent }},
+	{"unit_id", func(row *data.ProductExportRow) any { return row.UnitID }},
+	{"variant_discount_id", func(row *data.ProductExportRow) any { return row.VariantDiscountID }},
+	{"created_at", func(row *data.ProductExportRow) any { return row.CreatedAt }},
+}
+
+// isExportColumn reports whether the column is written by an export, so
+// that imports can skip the columns they don't use.
+func isExportColumn(column string) bool {
+	for _, c := range exportColumns {
+		if c.name == column {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportWriter writes the rows of an export as they come. Close finishes the
+// file; it does not close the underlying writer.
+type ExportWriter interface {
+	Write(row *data.ProductExportRow) error
+	Close() error
+}
+
+// ExportFormat is a file format an export can be written in.
+type ExportFormat struct {
+	ContentType string
+	Extension   string
+	NewWriter   func(w io.Writer) (ExportWriter, error)
+}
+
+// ExportFormats are the export formats by name.
+var ExportFormats = map[string]ExportFormat{
+	"csv": {
+		ContentType: "text/csv; charset=utf-8",
+		Extension:   "csv",
+		NewWriter:   newCSVWriter,
+	},
+	"jsonl": {
+		ContentType: "application/jsonl; charset=utf-8",
+		Extension:   "jsonl",
+		NewWriter:   newJSONLWriter,
+	},
+	"xlsx": {
+		ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		Extension:   "xlsx",
+		NewWriter:   newXLSXWriter,
+	},
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer) (ExportWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), record: make([]string, len(exportColumns))}
+
+	for i, column := range exportColumns {
+		cw.record[i] = column.name
+	}
+	return cw, cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Write(row *data.ProductExportRow) error {
+	for i, column := range exportColumns {
+		cw.record[i] = formatValue(column.value(row))
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// jsonlWriter writes every row as a JSON object on a line of its own.
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) (ExportWriter, error) {
+	return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+}
+
+func (jw *jsonlWriter) Write(row *data.ProductExportRow) error {
+	return jw.enc.Encode(row)
+}
+
+func (jw *jsonlWriter) Close() error {
+	return nil
+}
+
+func formatValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case int:
+		return strconv.Itoa(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case time.Time:
+		return value.Format(time.RFC3339)
+	default:
+		return ""
+	}
+}
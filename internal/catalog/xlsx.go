@@ -0,0 +1,129 @@
+package catalog
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+)
+
+// The fixed parts of a workbook with a single sheet.
+var xlsxParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Products" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// xlsxWriter writes a workbook whose only sheet is streamed row by row, so
+// that the export never holds more than a row in memory. Strings are stored
+// inline rather than in a shared string table for the same reason.
+type xlsxWriter struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+func newXLSXWriter(w io.Writer) (ExportWriter, error) {
+	xw := &xlsxWriter{zip: zip.NewWriter(w)}
+
+	for _, part := range xlsxParts {
+		f, err := xw.zip.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(f, part.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := xw.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	xw.sheet = bufio.NewWriter(f)
+	xw.sheet.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]any, len(exportColumns))
+	for i, column := range exportColumns {
+		header[i] = column.name
+	}
+	return xw, xw.writeRow(header)
+}
+
+func (xw *xlsxWriter) Write(row *data.ProductExportRow) error {
+	values := make([]any, len(exportColumns))
+	for i, column := range exportColumns {
+		values[i] = column.value(row)
+	}
+	return xw.writeRow(values)
+}
+
+func (xw *xlsxWriter) writeRow(values []any) error {
+	xw.row++
+	rowRef := strconv.Itoa(xw.row)
+
+	xw.sheet.WriteString(`<row r="` + rowRef + `">`)
+	for i, value := range values {
+		ref := columnName(i) + rowRef
+
+		switch value := value.(type) {
+		case int, int64, float64:
+			xw.sheet.WriteString(`<c r="` + ref + `"><v>` + formatValue(value) + `</v></c>`)
+		case time.Time:
+			xw.writeString(ref, value.Format(time.RFC3339))
+		default:
+			xw.writeString(ref, formatValue(value))
+		}
+	}
+	_, err := xw.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (xw *xlsxWriter) writeString(ref, s string) {
+	xw.sheet.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+	xml.EscapeText(xw.sheet, []byte(s))
+	xw.sheet.WriteString(`</t></is></c>`)
+}
+
+func (xw *xlsxWriter) Close() error {
+	_, err := xw.sheet.WriteString(`</sheetData></worksheet>`)
+	if err != nil {
+		return err
+	}
+
+	err = xw.sheet.Flush()
+	if err != nil {
+		return err
+	}
+	return xw.zip.Close()
+}
+
+// columnName returns the spreadsheet name of the zero-based column, e.g. "A"
+// for 0 and "AA" for 26.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
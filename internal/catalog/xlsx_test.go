@@ -0,0 +1,26 @@
+package catalog
+
+import "testing"
+
+func TestColumnName(t *testing.T) {
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{0, "A"},
+		{1, "B"},
+		{25, "Z"},
+		{26, "AA"},
+		{27, "AB"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+	}
+
+	for _, tt := range tests {
+		if got := columnName(tt.index); got != tt.want {
+			t.Errorf("columnName(%d) = %q, want %q", tt.index, got, tt.want)
+		}
+	}
+}
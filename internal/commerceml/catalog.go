@@ -0,0 +1,276 @@
+// Package commerceml reads and writes CommerceML 2, the XML format 1C and
+// other accounting systems exchange catalogs and orders in.
+package commerceml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+)
+
+// SchemaVersion is the CommerceML version of the documents written.
+const SchemaVersion = "2.05"
+
+var ErrNotCommerceML = errors.New("not a CommerceML document")
+
+type document struct {
+	XMLName    xml.Name      `xml:"КоммерческаяИнформация"`
+	Classifier *classifier   `xml:"Классификатор"`
+	Catalog    *catalog      `xml:"Каталог"`
+	Offers     *offerPackage `xml:"ПакетПредложений"`
+	// Newer versions send only the changes of offers in their own element.
+	OfferChanges *offerPackage `xml:"ИзмененияПакетаПредложений"`
+}
+
+type classifier struct {
+	Groups     []group    `xml:"Группы>Группа"`
+	Properties []property `xml:"Свойства>Свойство"`
+}
+
+type group struct {
+	ID     string  `xml:"Ид"`
+	Name   string  `xml:"Наименование"`
+	Groups []group `xml:"Группы>Группа"`
+}
+
+type property struct {
+	ID     string          `xml:"Ид"`
+	Name   string          `xml:"Наименование"`
+	Values []propertyValue `xml:"ВариантыЗначений>Справочник"`
+}
+
+type propertyValue struct {
+	ID    string `xml:"ИдЗначения"`
+	Value string `xml:"Значение"`
+}
+
+type catalog struct {
+	Products []product `xml:"Товары>Товар"`
+}
+
+type product struct {
+	ID           string       `xml:"Ид"`
+	Barcode      string       `xml:"Штрихкод"`
+	Name         string       `xml:"Наименование"`
+	Description  string       `xml:"Описание"`
+	BaseUnit     unit         `xml:"БазоваяЕдиница"`
+	Groups       []string     `xml:"Группы>Ид"`
+	Manufacturer manufacturer `xml:"Изготовитель"`
+	Properties   []valueRef   `xml:"ЗначенияСвойств>ЗначенияСвойства"`
+}
+
+type manufacturer struct {
+	Name string `xml:"Наименование"`
+}
+
+type valueRef struct {
+	ID    string `xml:"Ид"`
+	Value string `xml:"Значение"`
+}
+
+type unit struct {
+	Name     string `xml:",chardata"`
+	Code     string `xml:"Код,attr,omitempty"`
+	FullName string `xml:"НаименованиеПолное,attr,omitempty"`
+}
+
+type offerPackage struct {
+	Offers []offer `xml:"Предложения>Предложение"`
+}
+
+type offer struct {
+	ID              string           `xml:"Ид"`
+	Barcode         string           `xml:"Штрихкод"`
+	Name            string           `xml:"Наименование"`
+	BaseUnit        unit             `xml:"БазоваяЕдиница"`
+	Characteristics []characteristic `xml:"ХарактеристикиТовара>ХарактеристикаТовара"`
+	Prices          []price          `xml:"Цены>Цена"`
+	Quantity        string           `xml:"Количество"`
+	Stocks          []string         `xml:"Остатки>Остаток>Склад>Количество"`
+}
+
+type characteristic struct {
+	Name  string `xml:"Наименование"`
+	Value string `xml:"Значение"`
+}
+
+type price struct {
+	PriceTypeID  string `xml:"ИдТипаЦены"`
+	PricePerUnit string `xml:"ЦенаЗаЕдиницу"`
+	Currency     string `xml:"Валюта"`
+}
+
+// brandProperties are the names 1C configurations give the property that
+// holds the brand of a product.
+var brandProperties = []string{"производитель", "бренд", "торговая марка", "brand", "manufacturer"}
+
+// ReadCatalog reads an exchange file, the catalog (import.xml) or the offers
+// (offers.xml), or a file holding both. Groups nested in the classifier are
+// flattened, keeping their parents. Prices are converted to tiyn; the first
+// price of an offer is used.
+func ReadCatalog(r io.Reader) (*data.ExchangeCatalog, error) {
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+
+	var doc document
+	err := xml.NewDecoder(br).Decode(&doc)
+	if err != nil {
+		var syntaxErr *xml.SyntaxError
+		if errors.As(err, &syntaxErr) || strings.Contains(err.Error(), "expected element type") {
+			return nil, ErrNotCommerceML
+		}
+		return nil, err
+	}
+
+	result := &data.ExchangeCatalog{}
+
+	brandValues := map[string]string{}
+	brandPropertyIDs := map[string]bool{}
+
+	if doc.Classifier != nil {
+		result.Groups = flattenGroups(doc.Classifier.Groups, "", nil)
+
+		for _, p := range doc.Classifier.Properties {
+			if !isBrandProperty(p.Name) {
+				continue
+			}
+			brandPropertyIDs[p.ID] = true
+			for _, value := range p.Values {
+				brandValues[value.ID] = value.Value
+			}
+		}
+	}
+
+	if doc.Catalog != nil {
+		for _, p := range doc.Catalog.Products {
+			item := data.ExchangeProduct{
+				ExternalID:  strings.TrimSpace(p.ID),
+				UPC:         strings.TrimSpace(p.Barcode),
+				Name:        strings.TrimSpace(p.Name),
+				Description: strings.TrimSpace(p.Description),
+				Brand:       strings.TrimSpace(p.Manufacturer.Name),
+				Unit:        strings.TrimSpace(p.BaseUnit.Name),
+			}
+			if len(p.Groups) > 0 {
+				item.Group = strings.TrimSpace(p.Groups[0])
+			}
+
+			for _, value := range p.Properties {
+				if item.Brand != "" || !brandPropertyIDs[value.ID] {
+					continue
+				}
+				// Reference properties hold the id of one of their values.
+				if name, ok := brandValues[value.Value]; ok {
+					item.Brand = name
+				} else {
+					item.Brand = strings.TrimSpace(value.Value)
+				}
+			}
+
+			result.Products = append(result.Products, item)
+		}
+	}
+
+	for _, pkg := range []*offerPackage{doc.Offers, doc.OfferChanges} {
+		if pkg == nil {
+			continue
+		}
+		for _, o := range pkg.Offers {
+			result.Offers = append(result.Offers, readOffer(o))
+		}
+	}
+
+	return result, nil
+}
+
+func readOffer(o offer) data.ExchangeOffer {
+	id := strings.TrimSpace(o.ID)
+	productID, _, _ := strings.Cut(id, "#")
+
+	item := data.ExchangeOffer{
+		ExternalID:        id,
+		ProductExternalID: productID,
+		UPC:               strings.TrimSpace(o.Barcode),
+		Unit:              strings.TrimSpace(o.BaseUnit.Name),
+	}
+
+	// Only offers of a characteristic are told apart by name; the base
+	// offer keeps the name of its product.
+	if id != productID {
+		values := make([]string, 0, len(o.Characteristics))
+		for _, c := range o.Characteristics {
+			values = append(values, strings.TrimSpace(c.Value))
+		}
+		item.Name = strings.Join(values, ", ")
+	}
+
+	if len(o.Prices) > 0 {
+		if amount, ok := parseDecimal(o.Prices[0].PricePerUnit); ok {
+			tiyn := int64(math.Round(amount * 100))
+			item.Price = &tiyn
+		}
+	}
+
+	if quantity, ok := parseDecimal(o.Quantity); ok {
+		item.Quantity = &quantity
+	} else if len(o.Stocks) > 0 {
+		var total float64
+		for _, s := range o.Stocks {
+			if quantity, ok := parseDecimal(s); ok {
+				total += quantity
+			}
+		}
+		item.Quantity = &total
+	}
+
+	return item
+}
+
+// flattenGroups lists the groups and all their subgroups, each after its
+// parent.
+func flattenGroups(groups []group, parent string, result []data.ExchangeGroup) []data.ExchangeGroup {
+	for _, g := range groups {
+		id := strings.TrimSpace(g.ID)
+		result = append(result, data.ExchangeGroup{
+			ExternalID: id,
+			Name:       strings.TrimSpace(g.Name),
+			Parent:     parent,
+		})
+		result = flattenGroups(g.Groups, id, result)
+	}
+	return result
+}
+
+func isBrandProperty(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, p := range brandProperties {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDecimal reads a number with a decimal point or comma and optional
+// spaces between thousands, as 1C writes them.
+func parseDecimal(s string) (float64, bool) {
+	s = strings.NewReplacer(" ", "", "\u00a0", "", ",", ".").Replace(strings.TrimSpace(s))
+	if s == "" {
+		return 0, false
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
@@ -0,0 +1,165 @@
+package commerceml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/data"
+)
+
+// Currency is the currency of all prices, which are kept in tiyn.
+const Currency = "KZT"
+
+type ordersDocument struct {
+	XMLName       xml.Name        `xml:"КоммерческаяИнформация"`
+	SchemaVersion string          `xml:"ВерсияСхемы,attr"`
+	CreatedAt     string          `xml:"ДатаФормирования,attr"`
+	Documents     []orderDocument `xml:"Документ"`
+}
+
+type orderDocument struct {
+	ID           string         `xml:"Ид"`
+	Number       string         `xml:"Номер"`
+	Date         string         `xml:"Дата"`
+	Time         string         `xml:"Время"`
+	Operation    string         `xml:"ХозОперация"`
+	Role         string         `xml:"Роль"`
+	Currency     string         `xml:"Валюта"`
+	Rate         string         `xml:"Курс"`
+	Sum          string         `xml:"Сумма"`
+	Counterparts []counterpart  `xml:"Контрагенты>Контрагент"`
+	Items        []orderItem    `xml:"Товары>Товар"`
+	Requisites   []requisiteVal `xml:"ЗначенияРеквизитов>ЗначениеРеквизита"`
+}
+
+type counterpart struct {
+	ID        string    `xml:"Ид"`
+	Name      string    `xml:"Наименование"`
+	Role      string    `xml:"Роль"`
+	FullName  string    `xml:"ПолноеНаименование"`
+	LastName  string    `xml:"Фамилия"`
+	FirstName string    `xml:"Имя"`
+	Address   address   `xml:"АдресРегистрации"`
+	Contacts  []contact `xml:"Контакты>Контакт"`
+}
+
+type address struct {
+	Presentation string `xml:"Представление"`
+}
+
+type contact struct {
+	Type  string `xml:"Тип"`
+	Value string `xml:"Значение"`
+}
+
+type orderItem struct {
+	ID           string         `xml:"Ид"`
+	Barcode      string         `xml:"Штрихкод,omitempty"`
+	Name         string         `xml:"Наименование"`
+	BaseUnit     unit           `xml:"БазоваяЕдиница"`
+	PricePerUnit string         `xml:"ЦенаЗаЕдиницу"`
+	Quantity     string         `xml:"Количество"`
+	Sum          string         `xml:"Сумма"`
+	Requisites   []requisiteVal `xml:"ЗначенияРеквизитов>ЗначениеРеквизита"`
+}
+
+type requisiteVal struct {
+	Name  string `xml:"Наименование"`
+	Value string `xml:"Значение"`
+}
+
+// WriteOrders writes the orders as a CommerceML document of "Заказ товара"
+// documents, as the exchange with 1C expects them. Items are identified by
+// the external id of their variant or product if they came from 1C, and by
+// the id of the product otherwise.
+func WriteOrders(w io.Writer, orders []*data.ExchangeOrder, now time.Time) error {
+	doc := ordersDocument{
+		SchemaVersion: SchemaVersion,
+		CreatedAt:     now.Format("2006-01-02T15:04:05"),
+		Documents:     make([]orderDocument, 0, len(orders)),
+	}
+
+	for _, order := range orders {
+		id := strconv.FormatInt(order.ID, 10)
+		name := strings.TrimSpace(order.FirstName + " " + order.LastName)
+
+		document := orderDocument{
+			ID:        id,
+			Number:    id,
+			Date:      order.CreatedAt.Format("2006-01-02"),
+			Time:      order.CreatedAt.Format("15:04:05"),
+			Operation: "Заказ товара",
+			Role:      "Продавец",
+			Currency:  Currency,
+			Rate:      "1",
+			Sum:       formatMoney(order.Total),
+			Counterparts: []counterpart{{
+				ID:        strconv.FormatInt(order.UserID, 10),
+				Name:      name,
+				Role:      "Покупатель",
+				FullName:  name,
+				LastName:  order.LastName,
+				FirstName: order.FirstName,
+				Address:   address{Presentation: order.Address},
+				Contacts:  []contact{{Type: "Почта", Value: order.Email}},
+			}},
+			Requisites: []requisiteVal{
+				{Name: "Статус заказа", Value: order.StatusName},
+			},
+		}
+
+		for _, item := range order.Items {
+			itemID := item.VariantExternalID
+			if itemID == "" {
+				itemID = item.ProductExternalID
+			}
+			if itemID == "" {
+				itemID = strconv.FormatInt(item.ProductID, 10)
+			}
+
+			itemName := item.Name
+			if item.VariantName != "" {
+				itemName += " (" + item.VariantName + ")"
+			}
+
+			document.Items = append(document.Items, orderItem{
+				ID:           itemID,
+				Barcode:      item.UPC,
+				Name:         itemName,
+				BaseUnit:     unit{Name: item.UnitName},
+				PricePerUnit: formatMoney(item.Price),
+				Quantity:     strconv.FormatFloat(item.Quantity, 'f', -1, 64),
+				Sum:          formatMoney(item.Total),
+				Requisites: []requisiteVal{
+					{Name: "ВидНоменклатуры", Value: "Товар"},
+					{Name: "ТипНоменклатуры", Value: "Товар"},
+				},
+			})
+		}
+
+		doc.Documents = append(doc.Documents, document)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	return enc.Encode(doc)
+}
+
+// formatMoney writes an amount in tiyn as tenge with two decimals.
+func formatMoney(tiyn int64) string {
+	sign := ""
+	if tiyn < 0 {
+		sign = "-"
+		tiyn = -tiyn
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, tiyn/100, tiyn%100)
+}
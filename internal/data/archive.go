@@ -0,0 +1,343 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrUnknownArchive is returned for a kind of record that is not archived.
+	ErrUnknownArchive = errors.New("unknown archive")
+	// ErrRestoreConflict is returned for restoring a row whose unique value,
+	// e.g. a UPC, has been given to another row while it was archived.
+	ErrRestoreConflict = errors.New("restore conflict")
+)
+
+// archiveTable describes a table whose rows are archived instead of deleted.
+// Archived rows are hidden from listings but still resolve by id, so that
+// past orders keep their products and references.
+type archiveTable struct {
+	// name is the SQL expression an archived row is listed by.
+	name string
+	// versioned tables count archiving as an edit of the row.
+	versioned bool
+	// unused is true for rows nothing refers to anymore; only those are
+	// purged.
+	unused string
+	// files is the column holding the storage keys of the row's uploaded
+	// files, if it has one. Purge leaves the files to its caller.
+	files string
+}
+
+var archiveTables = map[string]archiveTable{
+	"product_variants": {
+		name: `concat_ws(' ', (SELECT products.name FROM products WHERE products.id = product_variants.product_id),
+			NULLIF(product_variants.name, ''))`,
+		versioned: true,
+		unused:    `NOT EXISTS (SELECT 1 FROM order_items WHERE order_items.variant_id = product_variants.id)`,
+	},
+	"products": {
+		name:      "products.name",
+		versioned: true,
+		unused: `NOT EXISTS (SELECT 1 FROM order_items WHERE order_items.product_id = products.id)
+			AND NOT EXISTS (
+				SELECT 1 FROM order_items
+				INNER JOIN product_variants ON product_variants.id = order_items.variant_id
+				WHERE product_variants.product_id = products.id)`,
+	},
+	"categories": {
+		name:      "categories.name",
+		versioned: true,
+		unused: `NOT EXISTS (SELECT 1 FROM products WHERE products.category_id = categories.id)
+			AND NOT EXISTS (SELECT 1 FROM categories children WHERE children.parent_id = categories.id)`,
+		files: "image_keys",
+	},
+	"brands": {
+		name:   "brands.name",
+		unused: `NOT EXISTS (SELECT 1 FROM products WHERE products.brand_id = brands.id)`,
+	},
+	"units": {
+		name:   "units.name",
+		unused: `NOT EXISTS (SELECT 1 FROM product_variants WHERE product_variants.unit_id = units.id)`,
+	},
+	"countries": {
+		name:   "countries.name",
+		unused: `NOT EXISTS (SELECT 1 FROM products WHERE products.country_id = countries.id)`,
+	},
+	"discounts": {
+		name:      "discounts.name",
+		versioned: true,
+		unused: `NOT EXISTS (SELECT 1 FROM products WHERE products.discount_id = discounts.id)
+			AND NOT EXISTS (SELECT 1 FROM product_variants WHERE product_variants.discount_id = discounts.id)`,
+	},
+	"users": {
+		name:   "users.email",
+		unused: `NOT EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id)`,
+	},
+}
+
+// purgeOrder lists the archived tables in the order they are purged: variants
+// and products first, so that the references only they held can go in the
+// same run.
+var purgeOrder = []string{"product_variants", "products", "categories", "brands", "units", "countries", "discounts", "users"}
+
+// ArchivedRecord is an archived row as listed for the staff.
+type ArchivedRecord struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// PurgeReport counts the archived rows past the retention window by table:
+// those deleted for good and those kept because something still refers to
+// them. StorageKeys are the stored image files of the purged products and
+// categories.
+type PurgeReport struct {
+	DryRun      bool             `json:"dry_run"`
+	Purged      map[string]int64 `json:"purged"`
+	Kept        map[string]int64 `json:"kept"`
+	StorageKeys []string         `json:"-"`
+}
+
+type ArchiveModel struct {
+	DB *sql.DB
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// archive marks the row as deleted. It returns ErrRecordNotFound if there is
+// no such row or it has been archived already.
+func archive(ctx context.Context, db execer, table string, id int64) error {
+	set := "deleted_at = NOW()"
+	if archiveTables[table].versioned {
+		set += ", version = version + 1"
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET %s WHERE id = $1 AND deleted_at IS NULL`, table, set)
+
+	result, err := db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll returns a page of the archived rows of the table, most recently
+// archived first by default.
+func (m ArchiveModel) GetAll(kind string, filters Filters) ([]*ArchivedRecord, Metadata, error) {
+	table, ok := archiveTables[kind]
+	if !ok {
+		return nil, Metadata{}, ErrUnknownArchive
+	}
+
+	args := sqlArgs{}
+	filters.SortColumns = map[string]string{
+		"id":         kind + ".id",
+		"name":       table.name,
+		"deleted_at": kind + ".deleted_at",
+	}
+
+	page, orderBy, limit := filters.pageSQL(kind+".id", &args)
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, id, %s, deleted_at
+		FROM %s
+		WHERE deleted_at IS NOT NULL AND %s
+		ORDER BY %s
+		%s`, filters.countSQL(), filters.sortKeySQL(), table.name, kind, page, orderBy, limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	records := []*ArchivedRecord{}
+	var keys []pageKey
+
+	for rows.Next() {
+		var record ArchivedRecord
+		var key pageKey
+		err := rows.Scan(
+			&totalRecords,
+			&key.Key,
+			&record.ID,
+			&record.Name,
+			&record.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		key.ID = record.ID
+		records = append(records, &record)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	records, metadata := paginate(filters, totalRecords, records, keys)
+	return records, metadata, nil
+}
+
+// Restore takes the row out of the archive. It returns ErrRecordNotFound if
+// there is no such archived row and ErrRestoreConflict if a listed row has
+// taken over one of its unique values.
+func (m ArchiveModel) Restore(kind string, id int64) error {
+	table, ok := archiveTables[kind]
+	if !ok {
+		return ErrUnknownArchive
+	}
+
+	set := "deleted_at = NULL"
+	if table.versioned {
+		set += ", version = version + 1"
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET %s WHERE id = $1 AND deleted_at IS NOT NULL`, kind, set)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrRestoreConflict
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Purge deletes the rows archived before the given time that nothing refers
+// to anymore, in a single transaction that is rolled back on a dry run.
+func (m ArchiveModel) Purge(before time.Time, dryRun bool) (*PurgeReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := &PurgeReport{
+		DryRun:      dryRun,
+		Purged:      map[string]int64{},
+		Kept:        map[string]int64{},
+		StorageKeys: []string{},
+	}
+
+	// The images of purged products go with them, their files are left to
+	// the caller.
+	rows, err := tx.QueryContext(ctx, `
+		SELECT unnest(storage_keys)
+		FROM product_images
+		INNER JOIN products ON products.id = product_images.product_id
+		WHERE products.deleted_at < $1 AND `+archiveTables["products"].unused, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		err := rows.Scan(&key)
+		if err != nil {
+			return nil, err
+		}
+		report.StorageKeys = append(report.StorageKeys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, kind := range purgeOrder {
+		table := archiveTables[kind]
+		query := fmt.Sprintf(`DELETE FROM %s WHERE deleted_at < $1 AND %s`, kind, table.unused)
+
+		if table.files != "" {
+			report.Purged[kind], err = purgeFiles(ctx, tx, query+` RETURNING `+table.files, before, report)
+		} else {
+			var result sql.Result
+			result, err = tx.ExecContext(ctx, query, before)
+			if err == nil {
+				report.Purged[kind], err = result.RowsAffected()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var kept int64
+		err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*) FROM %s WHERE deleted_at < $1`, kind), before).Scan(&kept)
+		if err != nil {
+			return nil, err
+		}
+		report.Kept[kind] = kept
+	}
+
+	if dryRun {
+		report.StorageKeys = []string{}
+		return report, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// purgeFiles runs a purge query returning the storage keys of every deleted
+// row, adds the keys to the report and returns the number of rows deleted.
+func purgeFiles(ctx context.Context, tx *sql.Tx, query string, before time.Time, report *PurgeReport) (int64, error) {
+	rows, err := tx.QueryContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var purged int64
+	for rows.Next() {
+		var keys []string
+		err := rows.Scan(pq.Array(&keys))
+		if err != nil {
+			return 0, err
+		}
+		report.StorageKeys = append(report.StorageKeys, keys...)
+		purged++
+	}
+	return purged, rows.Err()
+}
@@ -0,0 +1,484 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateAttributeCode = errors.New("duplicate attribute code")
+
+// Attribute types. Enum attributes take one of their options.
+const (
+	AttributeBool   = "bool"
+	AttributeEnum   = "enum"
+	AttributeNumber = "number"
+	AttributeText   = "text"
+)
+
+var attributeTypes = []string{AttributeBool, AttributeEnum, AttributeNumber, AttributeText}
+
+// Attribute defines a property products may have, e.g. "vegan" or
+// "fat_content". Code names it in the query string of a product listing.
+// Filterable attributes can be filtered and faceted by.
+type Attribute struct {
+	ID         int64     `json:"id"`
+	Code       string    `json:"code"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Options    []string  `json:"options"`
+	Unit       string    `json:"unit"`
+	Filterable bool      `json:"filterable"`
+	CreatedAt  time.Time `json:"created_at"`
+	Version    int       `json:"version"`
+}
+
+// ProductAttribute is the value of an attribute for a product. Value is a
+// bool, a float64 or a string, depending on the type of the attribute.
+type ProductAttribute struct {
+	AttributeID int64  `json:"attribute_id"`
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Unit        string `json:"unit,omitempty"`
+	Value       any    `json:"value"`
+}
+
+type AttributeModel struct {
+	DB *sql.DB
+}
+
+func ValidateAttribute(v *validator.Validator, attribute *Attribute) {
+	v.Check(attribute.Code != "", "code", "must be provided")
+	v.Check(len(attribute.Code) <= 50, "code", "must not be more than 50 bytes long")
+	v.Check(validator.Matches(attribute.Code, validator.CodeRX), "code", "must start with a letter and contain only lowercase letters, digits and underscores")
+	v.Check(attribute.Name != "", "name", "must be provided")
+	v.Check(len(attribute.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(validator.PermittedValue(attribute.Type, attributeTypes...), "type", "must be one of bool, enum, number or text")
+	v.Check(len(attribute.Unit) <= 20, "unit", "must not be more than 20 bytes long")
+
+	if attribute.Type == AttributeEnum {
+		v.Check(len(attribute.Options) > 0, "options", "must contain at least one option")
+		v.Check(len(attribute.Options) <= 100, "options", "must not contain more than 100 options")
+		v.Check(validator.Unique(attribute.Options), "options", "must not contain duplicate values")
+		for _, option := range attribute.Options {
+			v.Check(option != "", "options", "must not contain empty values")
+			v.Check(len(option) <= 100, "options", "must not contain values more than 100 bytes long")
+		}
+	} else {
+		v.Check(len(attribute.Options) == 0, "options", "are only allowed for enum attributes")
+	}
+}
+
+// ValidateProductAttribute checks that the value suits the attribute. The
+// value is as decoded from JSON.
+func ValidateProductAttribute(v *validator.Validator, attribute *Attribute, value any) {
+	key := "attributes." + attribute.Code
+
+	switch attribute.Type {
+	case AttributeBool:
+		_, ok := value.(bool)
+		v.Check(ok, key, "must be true or false")
+	case AttributeNumber:
+		_, ok := value.(float64)
+		v.Check(ok, key, "must be a number")
+	case AttributeEnum:
+		s, ok := value.(string)
+		v.Check(ok && validator.In(s, attribute.Options...), key, "must be one of the options of the attribute")
+	case AttributeText:
+		s, ok := value.(string)
+		v.Check(ok && s != "", key, "must be a non-empty string")
+		v.Check(len(s) <= 500, key, "must not be more than 500 bytes long")
+	}
+}
+
+// ValidateAttributeFilters checks the attribute filters of a product listing
+// against the filterable attributes by code.
+func ValidateAttributeFilters(v *validator.Validator, filters []AttributeFilter, attributes map[string]*Attribute) {
+	for _, f := range filters {
+		key := attributeFacet(f.Code)
+
+		attribute, ok := attributes[f.Code]
+		if !ok {
+			v.AddError(key, "must be a filterable attribute")
+			continue
+		}
+
+		switch attribute.Type {
+		case AttributeBool:
+			for _, value := range f.Values {
+				v.Check(validator.In(value, "true", "false"), key, "must be true or false")
+			}
+		case AttributeEnum:
+			for _, value := range f.Values {
+				v.Check(validator.In(value, attribute.Options...), key, "must be one of the options of the attribute")
+			}
+		case AttributeNumber:
+			v.Check(len(f.Values) == 0, key, "must be filtered by "+key+".min and "+key+".max")
+		}
+
+		if attribute.Type != AttributeNumber {
+			v.Check(f.Min == nil && f.Max == nil, key, "can only be filtered by range if it is a number")
+		}
+		if f.Min != nil && f.Max != nil {
+			v.Check(*f.Min <= *f.Max, key+".max", "must not be less than "+key+".min")
+		}
+	}
+}
+
+// attributeError turns a unique violation on the code into
+// ErrDuplicateAttributeCode.
+func attributeError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "attributes_code_key" {
+		return ErrDuplicateAttributeCode
+	}
+	return err
+}
+
+const attributeColumns = `id, code, name, type, options, unit, filterable, created_at, version`
+
+func scanAttribute(row interface{ Scan(...any) error }, attribute *Attribute) error {
+	return row.Scan(
+		&attribute.ID,
+		&attribute.Code,
+		&attribute.Name,
+		&attribute.Type,
+		pq.Array(&attribute.Options),
+		&attribute.Unit,
+		&attribute.Filterable,
+		&attribute.CreatedAt,
+		&attribute.Version,
+	)
+}
+
+func (m AttributeModel) Insert(attribute *Attribute) error {
+	if attribute.Options == nil {
+		attribute.Options = []string{}
+	}
+
+	query := `
+	INSERT INTO attributes (code, name, type, options, unit, filterable)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at, version`
+
+	args := []any{
+		attribute.Code,
+		attribute.Name,
+		attribute.Type,
+		pq.Array(attribute.Options),
+		attribute.Unit,
+		attribute.Filterable,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&attribute.ID, &attribute.CreatedAt, &attribute.Version)
+	return attributeError(err)
+}
+
+// GetAll returns every attribute, sorted by name. There are few enough of
+// them for a storefront to load them all.
+func (m AttributeModel) GetAll() ([]*Attribute, error) {
+	return m.getAll(false)
+}
+
+// GetAllFilterable returns the attributes products can be filtered by, by
+// code.
+func (m AttributeModel) GetAllFilterable() (map[string]*Attribute, error) {
+	attributes, err := m.getAll(true)
+	if err != nil {
+		return nil, err
+	}
+	return attributesByCode(attributes), nil
+}
+
+func (m AttributeModel) getAll(filterable bool) ([]*Attribute, error) {
+	query := `SELECT ` + attributeColumns + ` FROM attributes WHERE filterable OR NOT $1 ORDER BY name, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filterable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attributes := []*Attribute{}
+
+	for rows.Next() {
+		var attribute Attribute
+		err := scanAttribute(rows, &attribute)
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, &attribute)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return attributes, nil
+}
+
+// GetAllByCode returns every attribute by code.
+func (m AttributeModel) GetAllByCode() (map[string]*Attribute, error) {
+	attributes, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return attributesByCode(attributes), nil
+}
+
+func attributesByCode(attributes []*Attribute) map[string]*Attribute {
+	byCode := make(map[string]*Attribute, len(attributes))
+	for _, attribute := range attributes {
+		byCode[attribute.Code] = attribute
+	}
+	return byCode
+}
+
+func (m AttributeModel) Get(id int64) (*Attribute, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + attributeColumns + ` FROM attributes WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var attribute Attribute
+	err := scanAttribute(m.DB.QueryRowContext(ctx, query, id), &attribute)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &attribute, nil
+}
+
+// Update saves the attribute if it is still at the version it was read at,
+// and returns ErrEditConflict otherwise. The type of an attribute is not
+// changed, as its values are stored by type.
+func (m AttributeModel) Update(attribute *Attribute) error {
+	if attribute.Options == nil {
+		attribute.Options = []string{}
+	}
+
+	query := `UPDATE attributes
+	SET code = $1, name = $2, options = $3, unit = $4, filterable = $5, version = version + 1
+	WHERE id = $6 AND version = $7
+	RETURNING version`
+
+	args := []any{
+		attribute.Code,
+		attribute.Name,
+		pq.Array(attribute.Options),
+		attribute.Unit,
+		attribute.Filterable,
+		attribute.ID,
+		attribute.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&attribute.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return attributeError(err)
+		}
+	}
+	return nil
+}
+
+// Delete removes the attribute along with its values.
+func (m AttributeModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM attributes WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAllForProduct returns the attribute values of the product, sorted by
+// the name of the attribute.
+func (m AttributeModel) GetAllForProduct(productID int64) ([]*ProductAttribute, error) {
+	query := `
+		SELECT attributes.id, attributes.code, attributes.name, attributes.type, attributes.unit,
+			product_attributes.bool_value, product_attributes.number_value, product_attributes.text_value
+		FROM product_attributes
+		INNER JOIN attributes ON attributes.id = product_attributes.attribute_id
+		WHERE product_attributes.product_id = $1
+		ORDER BY attributes.name, attributes.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []*ProductAttribute{}
+
+	for rows.Next() {
+		var value ProductAttribute
+		var boolValue sql.NullBool
+		var numberValue sql.NullFloat64
+		var textValue sql.NullString
+
+		err := rows.Scan(
+			&value.AttributeID,
+			&value.Code,
+			&value.Name,
+			&value.Type,
+			&value.Unit,
+			&boolValue,
+			&numberValue,
+			&textValue,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		switch value.Type {
+		case AttributeBool:
+			value.Value = boolValue.Bool
+		case AttributeNumber:
+			value.Value = numberValue.Float64
+		default:
+			value.Value = textValue.String
+		}
+		values = append(values, &value)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// SetProductAttributes replaces the attribute values of the product, which
+// counts as an edit of the product: it returns ErrEditConflict if the
+// product is no longer at the version it was read at.
+func (m AttributeModel) SetProductAttributes(product *Product, values []*ProductAttribute) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE products
+		SET version = version + 1
+		WHERE id = $1 AND version = $2
+		RETURNING version`, product.ID, product.Version).Scan(&product.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_attributes WHERE product_id = $1`, product.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		var boolValue, numberValue, textValue any
+		switch value.Type {
+		case AttributeBool:
+			boolValue = value.Value
+		case AttributeNumber:
+			numberValue = value.Value
+		default:
+			textValue = value.Value
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO product_attributes (product_id, attribute_id, bool_value, number_value, text_value)
+			VALUES ($1, $2, $3, $4, $5)`, product.ID, value.AttributeID, boolValue, numberValue, textValue)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AttributeFilter selects the products by the value of a filterable
+// attribute: one of Values for bool, enum and text attributes, or a range
+// between Min and Max for number attributes.
+type AttributeFilter struct {
+	Code   string
+	Values []string
+	Min    *float64
+	Max    *float64
+}
+
+// attributeFacet is the facet dimension of an attribute, which is also the
+// name of its filter in the query string.
+func attributeFacet(code string) string {
+	return "attr." + code
+}
+
+// condition returns the SQL condition of the filter. Bool values compare as
+// "true" and "false".
+func (f AttributeFilter) condition(args *sqlArgs) string {
+	conditions := []string{
+		"product_attributes.product_id = products.id",
+		"attributes.code = " + args.add(f.Code),
+		"attributes.filterable",
+	}
+
+	if len(f.Values) > 0 {
+		conditions = append(conditions, "COALESCE(product_attributes.text_value, product_attributes.bool_value::text) = ANY("+args.add(pq.Array(f.Values))+")")
+	}
+	if f.Min != nil {
+		conditions = append(conditions, "product_attributes.number_value >= "+args.add(*f.Min))
+	}
+	if f.Max != nil {
+		conditions = append(conditions, "product_attributes.number_value <= "+args.add(*f.Max))
+	}
+
+	return fmt.Sprintf(`EXISTS (
+		SELECT 1
+		FROM product_attributes
+		INNER JOIN attributes ON attributes.id = product_attributes.attribute_id
+		WHERE %s)`, strings.Join(conditions, " AND "))
+}
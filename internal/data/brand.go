@@ -4,15 +4,17 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/dexciuq/yummy-express-backend/internal/validator"
 )
 
 type Brand struct {
-	ID          int64  `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 }
 
 type BrandModel struct {
@@ -48,16 +50,27 @@ func (b BrandModel) Insert(brand *Brand) error {
 	return nil
 }
 
-func (b BrandModel) GetAll() ([]*Brand, error) {
-	query := `SELECT count(*) OVER(), id, name, description FROM brands`
+// GetAll returns a page of the brands.
+func (b BrandModel) GetAll(filters Filters) ([]*Brand, Metadata, error) {
+	args := sqlArgs{}
+	filters.SortColumns = referenceSortColumns("brands")
+
+	page, orderBy, limit := filters.pageSQL("brands.id", &args)
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, id, name, description
+		FROM brands
+		WHERE deleted_at IS NULL AND %s
+		ORDER BY %s
+		%s`, filters.countSQL(), filters.sortKeySQL(), page, orderBy, limit)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
-	rows, err := b.DB.QueryContext(ctx, query)
+	rows, err := b.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
@@ -65,25 +78,31 @@ func (b BrandModel) GetAll() ([]*Brand, error) {
 	totalRecords := 0
 
 	brands := []*Brand{}
+	var keys []pageKey
 
 	for rows.Next() {
 		var brand Brand
+		var key pageKey
 		err := rows.Scan(
 			&totalRecords,
+			&key.Key,
 			&brand.ID,
 			&brand.Name,
 			&brand.Description,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
+		key.ID = brand.ID
 		brands = append(brands, &brand)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
-	return brands, nil
+	brands, metadata := paginate(filters, totalRecords, brands, keys)
+	return brands, metadata, nil
 }
 
 func (b BrandModel) Get(id int64) (*Brand, error) {
@@ -92,7 +111,7 @@ func (b BrandModel) Get(id int64) (*Brand, error) {
 	}
 
 	query := `
-		SELECT id, name, description
+		SELECT id, name, description, deleted_at
 		FROM brands
 		WHERE id = $1`
 
@@ -101,6 +120,7 @@ func (b BrandModel) Get(id int64) (*Brand, error) {
 		&brand.ID,
 		&brand.Name,
 		&brand.Description,
+		&brand.DeletedAt,
 	)
 	if err != nil {
 		switch {
@@ -128,24 +148,13 @@ func (b BrandModel) Update(brand *Brand) error {
 	return b.DB.QueryRow(query, args...).Scan(&brand.ID)
 }
 
+// Delete archives the brand. It is no longer listed, but still resolves by
+// id for the products and orders that refer to it.
 func (b BrandModel) Delete(id int64) error {
-	query := `
-		DELETE FROM brands
-		WHERE id = $1`
-	result, err := b.DB.Exec(query, id)
-	if err != nil {
-		return nil
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-	return nil
+	return archive(ctx, b.DB, "brands", id)
 }
 
 func (b BrandModel) Init() error {
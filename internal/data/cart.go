@@ -0,0 +1,302 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+)
+
+type Cart struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id,omitempty"`
+	Token     string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CartItem struct {
+	ID        int64     `json:"id"`
+	CartID    int64     `json:"cart_id"`
+	ProductID int64     `json:"product_id"`
+	VariantID int64     `json:"variant_id"`
+	Quantity  float64   `json:"quantity"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CartModel struct {
+	DB *sql.DB
+}
+
+func ValidateCartItem(v *validator.Validator, item *CartItem, step float64) {
+	v.Check(item.ProductID > 0, "product_id", "must be provided")
+	v.Check(item.VariantID > 0, "variant_id", "must be provided")
+	v.Check(item.Quantity > 0, "quantity", "must be greater than zero")
+	v.Check(ValidAmount(item.Quantity, step), "quantity", "must be a multiple of the product step")
+}
+
+// hashCartToken returns the value stored in the database for a guest cart
+// token, so that a leaked database does not expose usable tokens.
+func hashCartToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// CreateGuest creates an anonymous cart and generates the opaque token that
+// identifies it. The plaintext token is only available on the returned cart.
+func (c CartModel) CreateGuest() (*Cart, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	cart := &Cart{Token: base64.RawURLEncoding.EncodeToString(randomBytes)}
+
+	query := `
+	INSERT INTO carts (token_hash)
+	VALUES ($1)
+	RETURNING id, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = c.DB.QueryRowContext(ctx, query, hashCartToken(cart.Token)).Scan(&cart.ID, &cart.CreatedAt, &cart.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// GetOrCreateForUser returns the cart of the user, creating an empty one on
+// first use.
+func (c CartModel) GetOrCreateForUser(userID int64) (*Cart, error) {
+	query := `
+	INSERT INTO carts (user_id)
+	VALUES ($1)
+	ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+	RETURNING id, user_id, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var cart Cart
+	err := c.DB.QueryRowContext(ctx, query, userID).Scan(&cart.ID, &cart.UserID, &cart.CreatedAt, &cart.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
+
+func (c CartModel) GetByToken(token string) (*Cart, error) {
+	if token == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, updated_at
+		FROM carts
+		WHERE token_hash = $1 AND user_id IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	cart := Cart{Token: token}
+	err := c.DB.QueryRowContext(ctx, query, hashCartToken(token)).Scan(&cart.ID, &cart.CreatedAt, &cart.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &cart, nil
+}
+
+func (c CartModel) GetItems(cartID int64) ([]*CartItem, error) {
+	query := `
+		SELECT id, cart_id, product_id, variant_id, quantity, created_at
+		FROM cart_items
+		WHERE cart_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, cartID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*CartItem{}
+
+	for rows.Next() {
+		var item CartItem
+		err := rows.Scan(
+			&item.ID,
+			&item.CartID,
+			&item.ProductID,
+			&item.VariantID,
+			&item.Quantity,
+			&item.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (c CartModel) GetItem(cartID, id int64) (*CartItem, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, cart_id, product_id, variant_id, quantity, created_at
+		FROM cart_items
+		WHERE id = $1 AND cart_id = $2`
+
+	var item CartItem
+	err := c.DB.QueryRow(query, id, cartID).Scan(
+		&item.ID,
+		&item.CartID,
+		&item.ProductID,
+		&item.VariantID,
+		&item.Quantity,
+		&item.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &item, nil
+}
+
+// AddItem puts the product variant into the cart. Adding a variant that is
+// already in the cart increases its quantity instead of creating a second
+// line.
+func (c CartModel) AddItem(item *CartItem) error {
+	query := `
+	INSERT INTO cart_items (cart_id, product_id, variant_id, quantity)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (cart_id, variant_id) DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity
+	RETURNING id, quantity, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := c.DB.QueryRowContext(ctx, query, item.CartID, item.ProductID, item.VariantID, item.Quantity).Scan(&item.ID, &item.Quantity, &item.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return c.touch(item.CartID)
+}
+
+func (c CartModel) UpdateItem(item *CartItem) error {
+	query := `
+	UPDATE cart_items
+	SET quantity = $1
+	WHERE id = $2 AND cart_id = $3
+	RETURNING id`
+
+	err := c.DB.QueryRow(query, item.Quantity, item.ID, item.CartID).Scan(&item.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return c.touch(item.CartID)
+}
+
+func (c CartModel) DeleteItem(cartID, id int64) error {
+	query := `
+		DELETE FROM cart_items
+		WHERE id = $1 AND cart_id = $2`
+	result, err := c.DB.Exec(query, id, cartID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return c.touch(cartID)
+}
+
+// clearCart removes every item of the cart, within the transaction that
+// places the order they were checked out into.
+func clearCart(ctx context.Context, db execer, cartID int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM cart_items WHERE cart_id = $1`, cartID)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `UPDATE carts SET updated_at = NOW() WHERE id = $1`, cartID)
+	return err
+}
+
+// Merge moves every item of the guest cart into the user's cart, summing the
+// quantities of variants present in both, and removes the guest cart.
+func (c CartModel) Merge(guestCartID, userCartID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO cart_items (cart_id, product_id, variant_id, quantity)
+	SELECT $2, product_id, variant_id, quantity
+	FROM cart_items
+	WHERE cart_id = $1
+	ON CONFLICT (cart_id, variant_id) DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity`
+
+	_, err = tx.ExecContext(ctx, query, guestCartID, userCartID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM carts WHERE id = $1`, guestCartID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE carts SET updated_at = NOW() WHERE id = $1`, userCartID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c CartModel) touch(cartID int64) error {
+	_, err := c.DB.Exec(`UPDATE carts SET updated_at = NOW() WHERE id = $1`, cartID)
+	return err
+}
@@ -4,16 +4,36 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/dexciuq/yummy-express-backend/internal/validator"
+	"github.com/lib/pq"
 )
 
+var (
+	// ErrInvalidParent is returned for a parent that is not a listed category.
+	ErrInvalidParent = errors.New("invalid parent category")
+	// ErrCategoryCycle is returned for a move under the category itself or
+	// one of its descendants.
+	ErrCategoryCycle = errors.New("category cycle")
+	// ErrCategoryHasChildren is returned for archiving a category that still
+	// has listed subcategories.
+	ErrCategoryHasChildren = errors.New("category has children")
+)
+
+// Category is a node of the category tree. A zero ParentID makes it a root
+// category. ImageKeys are the stored files of an uploaded image; they are only
+// loaded by Get.
 type Category struct {
-	ID          int64  `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Image       string `json:"image"`
+	ID          int64      `json:"id"`
+	ParentID    int64      `json:"parent_id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Image       string     `json:"image"`
+	ImageKeys   []string   `json:"-"`
+	Version     int        `json:"version"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 }
 
 type CategoryModel struct {
@@ -26,40 +46,60 @@ func ValidateCategory(v *validator.Validator, category *Category) {
 	v.Check(category.Description != "", "description", "must be provided")
 }
 
+// Insert adds the category under its parent. It returns ErrInvalidParent if
+// the parent is not a listed category.
 func (c CategoryModel) Insert(category *Category) error {
 	query := `
-	INSERT INTO categories (name, description, image)
-	VALUES ($1, $2, $3)
-	RETURNING id`
+	INSERT INTO categories (name, description, image, parent_id)
+	SELECT $1, $2, $3, NULLIF($4, 0)
+	WHERE $4 = 0 OR EXISTS (SELECT 1 FROM categories WHERE id = $4 AND deleted_at IS NULL)
+	RETURNING id, version`
 
 	args := []any{
 		category.Name,
 		category.Description,
 		category.Image,
+		category.ParentID,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := c.DB.QueryRowContext(ctx, query, args...).Scan(&category.ID)
+	err := c.DB.QueryRowContext(ctx, query, args...).Scan(&category.ID, &category.Version)
 
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrInvalidParent
+		default:
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (c CategoryModel) GetAll() ([]*Category, error) {
-	query := `SELECT count(*) OVER(), id, name, description, image FROM categories`
+// GetAll returns a page of the categories.
+func (c CategoryModel) GetAll(filters Filters) ([]*Category, Metadata, error) {
+	args := sqlArgs{}
+	filters.SortColumns = referenceSortColumns("categories")
+
+	page, orderBy, limit := filters.pageSQL("categories.id", &args)
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, id, COALESCE(parent_id, 0), name, description, image, version
+		FROM categories
+		WHERE deleted_at IS NULL AND %s
+		ORDER BY %s
+		%s`, filters.countSQL(), filters.sortKeySQL(), page, orderBy, limit)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
-	rows, err := c.DB.QueryContext(ctx, query)
+	rows, err := c.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
@@ -67,26 +107,34 @@ func (c CategoryModel) GetAll() ([]*Category, error) {
 	totalRecords := 0
 
 	categories := []*Category{}
+	var keys []pageKey
 
 	for rows.Next() {
 		var category Category
+		var key pageKey
 		err := rows.Scan(
 			&totalRecords,
+			&key.Key,
 			&category.ID,
+			&category.ParentID,
 			&category.Name,
 			&category.Description,
 			&category.Image,
+			&category.Version,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
+		key.ID = category.ID
 		categories = append(categories, &category)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
-	return categories, nil
+	categories, metadata := paginate(filters, totalRecords, categories, keys)
+	return categories, metadata, nil
 }
 
 func (c CategoryModel) Get(id int64) (*Category, error) {
@@ -95,16 +143,20 @@ func (c CategoryModel) Get(id int64) (*Category, error) {
 	}
 
 	query := `
-		SELECT id, name, description, image
+		SELECT id, COALESCE(parent_id, 0), name, description, image, image_keys, version, deleted_at
 		FROM categories
 		WHERE id = $1`
 
 	var category Category
 	err := c.DB.QueryRow(query, id).Scan(
 		&category.ID,
+		&category.ParentID,
 		&category.Name,
 		&category.Description,
 		&category.Image,
+		pq.Array(&category.ImageKeys),
+		&category.Version,
+		&category.DeletedAt,
 	)
 	if err != nil {
 		switch {
@@ -117,42 +169,140 @@ func (c CategoryModel) Get(id int64) (*Category, error) {
 	return &category, nil
 }
 
+// Update saves the category if it is still at the version it was read at,
+// and returns ErrEditConflict otherwise.
 func (c CategoryModel) Update(category *Category) error {
 	query := `UPDATE categories
-	SET name = $1, description = $2, image = $3
-	WHERE id = $4
-	RETURNING id`
+	SET name = $1, description = $2, image = $3, image_keys = COALESCE($4::text[], '{}'), version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING version`
 
 	args := []any{
 		category.Name,
 		category.Description,
 		category.Image,
+		pq.Array(category.ImageKeys),
 		category.ID,
+		category.Version,
 	}
 
-	return c.DB.QueryRow(query, args...).Scan(&category.ID)
+	err := c.DB.QueryRow(query, args...).Scan(&category.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
 }
 
-func (c CategoryModel) Delete(id int64) error {
-	query := `
-		DELETE FROM categories
-		WHERE id = $1`
-	result, err := c.DB.Exec(query, id)
+// Move puts the category, with its whole subtree, under another parent, or
+// makes it a root category for a zero parentID. It returns ErrInvalidParent
+// if the parent is not a listed category, ErrCategoryCycle if it lies within
+// the subtree, and ErrEditConflict if the category is no longer at the
+// version it was read at.
+func (c CategoryModel) Move(category *Category, parentID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Two moves checked side by side could still close a cycle, so moves
+	// take turns. Reads are not blocked.
+	_, err = tx.ExecContext(ctx, `LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return err
+	}
+
+	err = checkCategoryParent(ctx, tx, category.ID, parentID)
 	if err != nil {
+		return err
+	}
+
+	query := `UPDATE categories
+	SET parent_id = NULLIF($1, 0), version = version + 1
+	WHERE id = $2 AND version = $3
+	RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, parentID, category.ID, category.Version).Scan(&category.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	category.ParentID = parentID
+	return nil
+}
+
+// checkCategoryParent returns ErrInvalidParent if the parent is not a listed
+// category and ErrCategoryCycle if it is the category itself or one of its
+// descendants. A zero parentID, the root, is always valid.
+func checkCategoryParent(ctx context.Context, db queryRower, id, parentID int64) error {
+	if parentID == 0 {
 		return nil
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	// The ancestors of the new parent, the parent included, must not contain
+	// the category. UNION stops at a cycle that is already there.
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM categories WHERE id = $1 AND deleted_at IS NULL
+			UNION
+			SELECT categories.id, categories.parent_id
+			FROM categories
+			INNER JOIN ancestors ON categories.id = ancestors.parent_id
+		)
+		SELECT bool_or(id = $2) FROM ancestors`
+
+	var cycle sql.NullBool
+	err := db.QueryRowContext(ctx, query, parentID, id).Scan(&cycle)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	switch {
+	case !cycle.Valid:
+		return ErrInvalidParent
+	case cycle.Bool:
+		return ErrCategoryCycle
 	}
 	return nil
 }
 
+// Delete archives the category. It is no longer listed, but still resolves by
+// id for the products and orders that refer to it. It returns
+// ErrCategoryHasChildren while the category has listed subcategories, which
+// would drop out of the tree with it.
+func (c CategoryModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var children bool
+	err := c.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM categories WHERE parent_id = $1 AND deleted_at IS NULL)`, id).Scan(&children)
+	if err != nil {
+		return err
+	}
+	if children {
+		return ErrCategoryHasChildren
+	}
+
+	return archive(ctx, c.DB, "categories", id)
+}
+
 func (c CategoryModel) Init() error {
 	var count int
 	err := c.DB.QueryRow("SELECT COUNT(*) FROM categories").Scan(&count)
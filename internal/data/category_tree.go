@@ -0,0 +1,146 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CategoryNode is a category with its subcategories.
+type CategoryNode struct {
+	*Category
+	Children []*CategoryNode `json:"children"`
+}
+
+// Breadcrumb is a category on the path from the root to a category.
+type Breadcrumb struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// Breadcrumbs is the path from a root category down to a category, the
+// category included. It scans from the JSON array built by categoryPathSQL.
+type Breadcrumbs []Breadcrumb
+
+func (b *Breadcrumbs) Scan(src any) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, b)
+	case string:
+		return json.Unmarshal([]byte(src), b)
+	case nil:
+		*b = Breadcrumbs{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Breadcrumbs", src)
+	}
+}
+
+// categoryDepthMax bounds the walk up the tree, should a cycle have made it
+// into the table.
+const categoryDepthMax = 32
+
+// categoryPathSQL returns a subquery selecting the breadcrumbs of the
+// category whose id is the given SQL expression, root first.
+func categoryPathSQL(id string) string {
+	return fmt.Sprintf(`(
+		WITH RECURSIVE path AS (
+			SELECT categories.id, categories.name, categories.parent_id, 0 AS depth
+			FROM categories
+			WHERE categories.id = %s
+			UNION ALL
+			SELECT categories.id, categories.name, categories.parent_id, path.depth + 1
+			FROM categories
+			INNER JOIN path ON categories.id = path.parent_id
+			WHERE path.depth < %d
+		)
+		SELECT COALESCE(json_agg(json_build_object('id', path.id, 'name', path.name) ORDER BY path.depth DESC), '[]')
+		FROM path)`, id, categoryDepthMax)
+}
+
+// categorySubtreeSQL returns a subquery selecting the ids of the categories
+// given by the SQL expression, an array of ids, and of all their descendants.
+func categorySubtreeSQL(ids string) string {
+	return fmt.Sprintf(`(
+		WITH RECURSIVE subtree AS (
+			SELECT categories.id FROM categories WHERE categories.id = ANY(%s)
+			UNION
+			SELECT categories.id
+			FROM categories
+			INNER JOIN subtree ON categories.parent_id = subtree.id
+		)
+		SELECT subtree.id FROM subtree)`, ids)
+}
+
+// Path returns the breadcrumbs of the category, or none for a zero id.
+func (c CategoryModel) Path(id int64) (Breadcrumbs, error) {
+	if id == 0 {
+		return Breadcrumbs{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var path Breadcrumbs
+	err := c.DB.QueryRowContext(ctx, `SELECT `+categoryPathSQL("$1"), id).Scan(&path)
+	if err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// GetTree returns the listed categories as a tree, siblings sorted by name.
+// A category whose parent is archived is listed as a root.
+func (c CategoryModel) GetTree() ([]*CategoryNode, error) {
+	query := `
+		SELECT id, COALESCE(parent_id, 0), name, description, image, version
+		FROM categories
+		WHERE deleted_at IS NULL
+		ORDER BY name, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []*CategoryNode
+	byID := map[int64]*CategoryNode{}
+
+	for rows.Next() {
+		var category Category
+		err := rows.Scan(
+			&category.ID,
+			&category.ParentID,
+			&category.Name,
+			&category.Description,
+			&category.Image,
+			&category.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		node := &CategoryNode{Category: &category, Children: []*CategoryNode{}}
+		nodes = append(nodes, node)
+		byID[category.ID] = node
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	roots := []*CategoryNode{}
+	for _, node := range nodes {
+		parent, ok := byID[node.ParentID]
+		if !ok {
+			roots = append(roots, node)
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+	}
+	return roots, nil
+}
@@ -4,17 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/dexciuq/yummy-express-backend/internal/validator"
 )
 
 type Country struct {
-	ID          int64  `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Alpha2      string `json:"alpha2"`
-	Alpha3      string `json:"alpha3"`
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Alpha2      string     `json:"alpha2"`
+	Alpha3      string     `json:"alpha3"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 }
 
 type CountryModel struct {
@@ -54,16 +56,27 @@ func (c CountryModel) Insert(country *Country) error {
 	return nil
 }
 
-func (c CountryModel) GetAll() ([]*Country, error) {
-	query := `SELECT count(*) OVER(), id, name, description, alpha2, alpha3 FROM countries`
+// GetAll returns a page of the countries.
+func (c CountryModel) GetAll(filters Filters) ([]*Country, Metadata, error) {
+	args := sqlArgs{}
+	filters.SortColumns = referenceSortColumns("countries")
+
+	page, orderBy, limit := filters.pageSQL("countries.id", &args)
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, id, name, description, alpha2, alpha3
+		FROM countries
+		WHERE deleted_at IS NULL AND %s
+		ORDER BY %s
+		%s`, filters.countSQL(), filters.sortKeySQL(), page, orderBy, limit)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
-	rows, err := c.DB.QueryContext(ctx, query)
+	rows, err := c.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
@@ -71,11 +84,14 @@ func (c CountryModel) GetAll() ([]*Country, error) {
 	totalRecords := 0
 
 	countries := []*Country{}
+	var keys []pageKey
 
 	for rows.Next() {
 		var country Country
+		var key pageKey
 		err := rows.Scan(
 			&totalRecords,
+			&key.Key,
 			&country.ID,
 			&country.Name,
 			&country.Description,
@@ -83,15 +99,18 @@ func (c CountryModel) GetAll() ([]*Country, error) {
 			&country.Alpha3,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
+		key.ID = country.ID
 		countries = append(countries, &country)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
-	return countries, nil
+	countries, metadata := paginate(filters, totalRecords, countries, keys)
+	return countries, metadata, nil
 }
 
 func (c CountryModel) Get(id int64) (*Country, error) {
@@ -100,7 +119,7 @@ func (c CountryModel) Get(id int64) (*Country, error) {
 	}
 
 	query := `
-		SELECT id, name, description, alpha2, alpha3
+		SELECT id, name, description, alpha2, alpha3, deleted_at
 		FROM countries
 		WHERE id = $1`
 
@@ -111,6 +130,7 @@ func (c CountryModel) Get(id int64) (*Country, error) {
 		&country.Description,
 		&country.Alpha2,
 		&country.Alpha3,
+		&country.DeletedAt,
 	)
 	if err != nil {
 		switch {
@@ -140,24 +160,13 @@ func (c CountryModel) Update(country *Country) error {
 	return c.DB.QueryRow(query, args...).Scan(&country.ID)
 }
 
+// Delete archives the country. It is no longer listed, but still resolves by
+// id for the products and orders that refer to it.
 func (c CountryModel) Delete(id int64) error {
-	query := `
-		DELETE FROM countries
-		WHERE id = $1`
-	result, err := c.DB.Exec(query, id)
-	if err != nil {
-		return nil
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-	return nil
+	return archive(ctx, c.DB, "countries", id)
 }
 
 func (c CountryModel) Init() error {
@@ -0,0 +1,146 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the position of a row in a sorted listing: the sort it belongs
+// to, the row's sort key as text and its id as the tie-breaker. Clients only
+// see it base64 encoded.
+type cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   int64  `json:"i"`
+}
+
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(js, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// pageKey is the sort key and id scanned alongside every row of a listing,
+// from which the cursors of the page are built.
+type pageKey struct {
+	Key string
+	ID  int64
+}
+
+func (f Filters) keyset() bool {
+	return f.After != "" || f.Before != ""
+}
+
+// countSQL is the select item holding the total number of matching records.
+// Keyset pages skip the count, as it would scan every matching row.
+func (f Filters) countSQL() string {
+	if f.keyset() {
+		return "0"
+	}
+	return "count(*) OVER()"
+}
+
+// sortKeySQL is the select item holding the sort key of a row as text.
+func (f Filters) sortKeySQL() string {
+	return fmt.Sprintf("(%s)::text", f.sortColumn())
+}
+
+// pageSQL returns the condition, ORDER BY list and LIMIT clause that select
+// the requested page. idColumn breaks ties between equal sort keys. Keyset
+// pages before a cursor are read in reverse order and fetch one row more
+// than the page size to tell whether there is a further page; paginate
+// puts both right.
+func (f Filters) pageSQL(idColumn string, args *sqlArgs) (condition, orderBy, limit string) {
+	column := f.sortColumn()
+	direction := f.sortDirection()
+
+	if !f.keyset() {
+		orderBy = fmt.Sprintf("%s %s, %s %s", column, direction, idColumn, direction)
+		limit = fmt.Sprintf("LIMIT %s OFFSET %s", args.add(f.limit()), args.add(f.offset()))
+		return "TRUE", orderBy, limit
+	}
+
+	// Invalid cursors are rejected by ValidateFilters.
+	token, forward := f.After, true
+	if f.Before != "" {
+		token, forward = f.Before, false
+	}
+	c, _ := decodeCursor(token)
+
+	ascending := direction == "ASC"
+	if !forward {
+		ascending = !ascending
+	}
+
+	operator, order := ">", "ASC"
+	if !ascending {
+		operator, order = "<", "DESC"
+	}
+
+	condition = fmt.Sprintf("(%s, %s) %s (%s, %s)", column, idColumn, operator, args.add(c.Key), args.add(c.ID))
+	orderBy = fmt.Sprintf("%s %s, %s %s", column, order, idColumn, order)
+	limit = "LIMIT " + args.add(f.PageSize+1)
+	return condition, orderBy, limit
+}
+
+// paginate trims the rows read for a page, restores their order and returns
+// them with the page's metadata. keys holds the pageKey of every row.
+func paginate[T any](f Filters, totalRecords int, rows []T, keys []pageKey) ([]T, Metadata) {
+	cursorAt := func(i int) string {
+		return encodeCursor(cursor{Sort: f.Sort, Key: keys[i].Key, ID: keys[i].ID})
+	}
+
+	if !f.keyset() {
+		metadata := calculateMetadata(totalRecords, f.Page, f.PageSize)
+		if len(rows) > 0 {
+			if f.Page < metadata.LastPage {
+				metadata.NextCursor = cursorAt(len(rows) - 1)
+			}
+			if f.Page > 1 {
+				metadata.PrevCursor = cursorAt(0)
+			}
+		}
+		return rows, metadata
+	}
+
+	more := len(rows) > f.PageSize
+	if more {
+		rows, keys = rows[:f.PageSize], keys[:f.PageSize]
+	}
+
+	if f.Before != "" {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	// A page after a cursor always has rows before it, and one before a
+	// cursor always has rows after it.
+	metadata := Metadata{PageSize: f.PageSize}
+	if len(rows) > 0 {
+		if f.After != "" || more {
+			metadata.PrevCursor = cursorAt(0)
+		}
+		if f.Before != "" || more {
+			metadata.NextCursor = cursorAt(len(rows) - 1)
+		}
+	}
+	return rows, metadata
+}
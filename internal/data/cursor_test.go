@@ -0,0 +1,191 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testFilters(sort, after, before string) Filters {
+	return Filters{
+		Page:         1,
+		PageSize:     2,
+		Sort:         sort,
+		SortSafelist: []string{"id", "name", "-name"},
+		SortColumns:  map[string]string{"id": "t.id", "name": "t.name"},
+		After:        after,
+		Before:       before,
+	}
+}
+
+func TestPageSQL(t *testing.T) {
+	key := func(sort string) string {
+		return encodeCursor(cursor{Sort: sort, Key: "b", ID: 5})
+	}
+
+	tests := []struct {
+		name      string
+		filters   Filters
+		condition string
+		orderBy   string
+		limit     string
+		args      sqlArgs
+	}{
+		{
+			name:      "page number",
+			filters:   Filters{Page: 3, PageSize: 2, Sort: "name", SortSafelist: []string{"name"}, SortColumns: map[string]string{"name": "t.name"}},
+			condition: "TRUE",
+			orderBy:   "t.name ASC, t.id ASC",
+			limit:     "LIMIT $1 OFFSET $2",
+			args:      sqlArgs{2, 4},
+		},
+		{
+			name:      "after ascending",
+			filters:   testFilters("name", key("name"), ""),
+			condition: "(t.name, t.id) > ($1, $2)",
+			orderBy:   "t.name ASC, t.id ASC",
+			limit:     "LIMIT $3",
+			args:      sqlArgs{"b", int64(5), 3},
+		},
+		{
+			name:      "before ascending",
+			filters:   testFilters("name", "", key("name")),
+			condition: "(t.name, t.id) < ($1, $2)",
+			orderBy:   "t.name DESC, t.id DESC",
+			limit:     "LIMIT $3",
+			args:      sqlArgs{"b", int64(5), 3},
+		},
+		{
+			name:      "after descending",
+			filters:   testFilters("-name", key("-name"), ""),
+			condition: "(t.name, t.id) < ($1, $2)",
+			orderBy:   "t.name DESC, t.id DESC",
+			limit:     "LIMIT $3",
+			args:      sqlArgs{"b", int64(5), 3},
+		},
+		{
+			name:      "before descending",
+			filters:   testFilters("-name", "", key("-name")),
+			condition: "(t.name, t.id) > ($1, $2)",
+			orderBy:   "t.name ASC, t.id ASC",
+			limit:     "LIMIT $3",
+			args:      sqlArgs{"b", int64(5), 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args sqlArgs
+			condition, orderBy, limit := tt.filters.pageSQL("t.id", &args)
+
+			if condition != tt.condition {
+				t.Errorf("condition = %q, want %q", condition, tt.condition)
+			}
+			if orderBy != tt.orderBy {
+				t.Errorf("orderBy = %q, want %q", orderBy, tt.orderBy)
+			}
+			if limit != tt.limit {
+				t.Errorf("limit = %q, want %q", limit, tt.limit)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	at := func(sort string, id int64) string {
+		return encodeCursor(cursor{Sort: sort, Key: "k", ID: id})
+	}
+	keysOf := func(ids []int64) []pageKey {
+		keys := make([]pageKey, len(ids))
+		for i, id := range ids {
+			keys[i] = pageKey{Key: "k", ID: id}
+		}
+		return keys
+	}
+	withPage := func(f Filters, page int) Filters {
+		f.Page = page
+		return f
+	}
+
+	tests := []struct {
+		name    string
+		filters Filters
+		total   int
+		rows    []int64
+		want    []int64
+		next    string
+		prev    string
+	}{
+		{
+			name:    "first numbered page",
+			filters: testFilters("id", "", ""),
+			total:   5,
+			rows:    []int64{1, 2},
+			want:    []int64{1, 2},
+			next:    at("id", 2),
+		},
+		{
+			name:    "last numbered page",
+			filters: withPage(testFilters("id", "", ""), 3),
+			total:   5,
+			rows:    []int64{5},
+			want:    []int64{5},
+			prev:    at("id", 5),
+		},
+		{
+			name:    "after with more rows",
+			filters: testFilters("id", at("id", 2), ""),
+			rows:    []int64{3, 4, 5},
+			want:    []int64{3, 4},
+			next:    at("id", 4),
+			prev:    at("id", 3),
+		},
+		{
+			name:    "after at the end",
+			filters: testFilters("id", at("id", 2), ""),
+			rows:    []int64{3, 4},
+			want:    []int64{3, 4},
+			prev:    at("id", 3),
+		},
+		{
+			name:    "before with more rows",
+			filters: testFilters("id", "", at("id", 6)),
+			rows:    []int64{5, 4, 3},
+			want:    []int64{4, 5},
+			next:    at("id", 5),
+			prev:    at("id", 4),
+		},
+		{
+			name:    "before at the start",
+			filters: testFilters("id", "", at("id", 2)),
+			rows:    []int64{1},
+			want:    []int64{1},
+			next:    at("id", 1),
+		},
+		{
+			name:    "after past the end",
+			filters: testFilters("id", at("id", 9), ""),
+			rows:    []int64{},
+			want:    []int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := append([]int64{}, tt.rows...)
+			got, metadata := paginate(tt.filters, tt.total, rows, keysOf(rows))
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %v, want %v", got, tt.want)
+			}
+			if metadata.NextCursor != tt.next {
+				t.Errorf("next cursor = %q, want %q", metadata.NextCursor, tt.next)
+			}
+			if metadata.PrevCursor != tt.prev {
+				t.Errorf("prev cursor = %q, want %q", metadata.PrevCursor, tt.prev)
+			}
+		})
+	}
+}
@@ -4,19 +4,22 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/dexciuq/yummy-express-backend/internal/validator"
 )
 
 type Discount struct {
-	ID              int64     `json:"id"`
-	Name            string    `json:"name"`
-	Description     string    `json:"description"`
-	DiscountPercent int       `json:"discount_percent"`
-	CreatedAt       time.Time `json:"created_at"`
-	StartedAt       time.Time `json:"started_at"`
-	EndedAt         time.Time `json:"ended_at"`
+	ID              int64      `json:"id"`
+	Name            string     `json:"name"`
+	Description     string     `json:"description"`
+	DiscountPercent int        `json:"discount_percent"`
+	CreatedAt       time.Time  `json:"created_at"`
+	StartedAt       time.Time  `json:"started_at"`
+	EndedAt         time.Time  `json:"ended_at"`
+	Version         int        `json:"version"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
 }
 
 type DiscountModel struct {
@@ -30,11 +33,17 @@ func ValidateDiscount(v *validator.Validator, discount *Discount) {
 	v.Check(discount.StartedAt.Before(discount.EndedAt), "ended_at", "must be later than started_at")
 }
 
+// IsActive reports whether the discount applies at the given moment.
+// Archived discounts never do.
+func (d *Discount) IsActive(t time.Time) bool {
+	return d.DeletedAt == nil && !t.Before(d.StartedAt) && !t.After(d.EndedAt)
+}
+
 func (d DiscountModel) Insert(discount *Discount) error {
 	query := `
 	INSERT INTO discounts (name, description, discount_percent, started_at, ended_at)
 	VALUES ($1, $2, $3, $4, $5)
-	RETURNING id, created_at`
+	RETURNING id, created_at, version`
 
 	args := []any{
 		discount.Name,
@@ -47,7 +56,7 @@ func (d DiscountModel) Insert(discount *Discount) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := d.DB.QueryRowContext(ctx, query, args...).Scan(&discount.ID, &discount.CreatedAt)
+	err := d.DB.QueryRowContext(ctx, query, args...).Scan(&discount.ID, &discount.CreatedAt, &discount.Version)
 
 	if err != nil {
 		return err
@@ -56,19 +65,27 @@ func (d DiscountModel) Insert(discount *Discount) error {
 	return nil
 }
 
-func (d DiscountModel) GetAll() ([]*Discount, error) {
-	// Update the SQL query to include the window function which counts the total
-	// (filtered) records.
-	query := `SELECT count(*) OVER(), id, name, description, discount_percent, created_at, started_at, ended_at
-		FROM discounts`
+// GetAll returns a page of the discounts.
+func (d DiscountModel) GetAll(filters Filters) ([]*Discount, Metadata, error) {
+	args := sqlArgs{}
+	filters.SortColumns = referenceSortColumns("discounts")
+
+	page, orderBy, limit := filters.pageSQL("discounts.id", &args)
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, id, name, description, discount_percent, created_at, started_at, ended_at, version
+		FROM discounts
+		WHERE deleted_at IS NULL AND %s
+		ORDER BY %s
+		%s`, filters.countSQL(), filters.sortKeySQL(), page, orderBy, limit)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
-	rows, err := d.DB.QueryContext(ctx, query)
+	rows, err := d.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
@@ -76,11 +93,14 @@ func (d DiscountModel) GetAll() ([]*Discount, error) {
 	totalRecords := 0
 
 	discounts := []*Discount{}
+	var keys []pageKey
 
 	for rows.Next() {
 		var discount Discount
+		var key pageKey
 		err := rows.Scan(
 			&totalRecords,
+			&key.Key,
 			&discount.ID,
 			&discount.Name,
 			&discount.Description,
@@ -88,23 +108,27 @@ func (d DiscountModel) GetAll() ([]*Discount, error) {
 			&discount.CreatedAt,
 			&discount.StartedAt,
 			&discount.EndedAt,
+			&discount.Version,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
+		key.ID = discount.ID
 		discounts = append(discounts, &discount)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
-	return discounts, nil
+	discounts, metadata := paginate(filters, totalRecords, discounts, keys)
+	return discounts, metadata, nil
 }
 
 func (d DiscountModel) GetAllActive() ([]*Discount, error) {
-	query := `SELECT count(*) OVER(), id, name, description, discount_percent, created_at, started_at, ended_at
+	query := `SELECT count(*) OVER(), id, name, description, discount_percent, created_at, started_at, ended_at, version
 		FROM discounts
-		WHERE started_at <= NOW() AND ended_at >= NOW() AND id != 1`
+		WHERE started_at <= NOW() AND ended_at >= NOW() AND id != 1 AND deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -131,6 +155,7 @@ func (d DiscountModel) GetAllActive() ([]*Discount, error) {
 			&discount.CreatedAt,
 			&discount.StartedAt,
 			&discount.EndedAt,
+			&discount.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -150,7 +175,7 @@ func (d DiscountModel) Get(id int64) (*Discount, error) {
 	}
 
 	query := `
-		SELECT id, name, description, discount_percent, created_at, started_at, ended_at
+		SELECT id, name, description, discount_percent, created_at, started_at, ended_at, version, deleted_at
 		FROM discounts
 		WHERE id = $1`
 
@@ -163,6 +188,8 @@ func (d DiscountModel) Get(id int64) (*Discount, error) {
 		&discount.CreatedAt,
 		&discount.StartedAt,
 		&discount.EndedAt,
+		&discount.Version,
+		&discount.DeletedAt,
 	)
 	if err != nil {
 		switch {
@@ -175,11 +202,13 @@ func (d DiscountModel) Get(id int64) (*Discount, error) {
 	return &discount, nil
 }
 
+// Update saves the discount if it is still at the version it was read at,
+// and returns ErrEditConflict otherwise.
 func (d DiscountModel) Update(discount *Discount) error {
 	query := `UPDATE discounts
-	SET name = $1, description = $2, discount_percent = $3, created_at = $4, started_at = $5, ended_at = $6
-	WHERE id = $7
-	RETURNING id`
+	SET name = $1, description = $2, discount_percent = $3, created_at = $4, started_at = $5, ended_at = $6, version = version + 1
+	WHERE id = $7 AND version = $8
+	RETURNING version`
 
 	args := []any{
 		discount.Name,
@@ -189,29 +218,28 @@ func (d DiscountModel) Update(discount *Discount) error {
 		discount.StartedAt,
 		discount.EndedAt,
 		discount.ID,
+		discount.Version,
 	}
 
-	return d.DB.QueryRow(query, args...).Scan(&discount.ID)
-}
-
-func (d DiscountModel) Delete(id int64) error {
-	query := `
-		DELETE FROM discounts
-		WHERE id = $1`
-	result, err := d.DB.Exec(query, id)
+	err := d.DB.QueryRow(query, args...).Scan(&discount.Version)
 	if err != nil {
-		return nil
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
 	}
+	return nil
+}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
+// Delete archives the discount. It is no longer listed, but still resolves by
+// id for the products and orders that refer to it.
+func (d DiscountModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-	return nil
+	return archive(ctx, d.DB, "discounts", id)
 }
 
 func (d DiscountModel) Init() error {
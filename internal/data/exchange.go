@@ -0,0 +1,663 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// errArchived is returned for an entry that matches an archived row. The
+// exchange leaves those alone and skips the entry.
+var errArchived = errors.New("archived")
+
+// ExchangeGroup is a product group of the accounting system, imported as a
+// category. Parent is the external id of the group it is nested in, which
+// comes before it.
+type ExchangeGroup struct {
+	ExternalID string
+	Name       string
+	Parent     string
+}
+
+// ExchangeProduct is a product of the accounting system's catalog. Group is
+// the external id of its group. Blank fields are left as they are.
+type ExchangeProduct struct {
+	ExternalID  string
+	UPC         string
+	Name        string
+	Description string
+	Group       string
+	Brand       string
+	Unit        string
+}
+
+// ExchangeOffer is the price and stock of a product, or of one of its
+// characteristics, which become variants. ProductExternalID is the product
+// the offer belongs to; nil numbers are left as they are.
+type ExchangeOffer struct {
+	ExternalID        string
+	ProductExternalID string
+	UPC               string
+	Name              string
+	Price             *int64
+	Quantity          *float64
+	Unit              string
+}
+
+// ExchangeCatalog is what one exchange file holds: a catalog with its groups
+// and products, a package of offers, or both.
+type ExchangeCatalog struct {
+	Groups   []ExchangeGroup
+	Products []ExchangeProduct
+	Offers   []ExchangeOffer
+}
+
+// ExchangeError is an entry of the catalog that could not be imported.
+type ExchangeError struct {
+	ExternalID string `json:"external_id"`
+	Error      string `json:"error"`
+}
+
+// ExchangeReport is the outcome of a catalog exchange. Entries with errors
+// are skipped; the rest is saved unless it was a dry run.
+type ExchangeReport struct {
+	DryRun            bool            `json:"dry_run"`
+	CategoriesCreated int             `json:"categories_created"`
+	ProductsCreated   int             `json:"products_created"`
+	ProductsUpdated   int             `json:"products_updated"`
+	VariantsCreated   int             `json:"variants_created"`
+	VariantsUpdated   int             `json:"variants_updated"`
+	Errors            []ExchangeError `json:"errors"`
+}
+
+type ExchangeModel struct {
+	DB *sql.DB
+}
+
+// exchange holds the state of one catalog import.
+type exchange struct {
+	ctx    context.Context
+	tx     *sql.Tx
+	report *ExchangeReport
+	groups map[string]int64
+}
+
+// Import saves the catalog in a single transaction. Groups are matched to
+// categories by external id or name. Products are matched by external id,
+// or else by the UPC of one of their variants; new products get a single
+// variant that their offer fills in later. Offers are matched to variants the
+// same way, and an offer of an unknown characteristic adds a variant to its
+// product.
+func (m ExchangeModel) Import(catalog *ExchangeCatalog, dryRun bool) (*ExchangeReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ex := &exchange{
+		ctx:    ctx,
+		tx:     tx,
+		report: &ExchangeReport{DryRun: dryRun, Errors: []ExchangeError{}},
+		groups: map[string]int64{},
+	}
+
+	for _, group := range catalog.Groups {
+		err = ex.entry(group.ExternalID, func() error { return ex.importGroup(group) })
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, product := range catalog.Products {
+		err = ex.entry(product.ExternalID, func() error { return ex.importProduct(product) })
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, offer := range catalog.Offers {
+		err = ex.entry(offer.ExternalID, func() error { return ex.importOffer(offer) })
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return ex.report, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return ex.report, nil
+}
+
+// entry runs fn within a savepoint. If fn breaks a constraint, only its
+// changes are rolled back and the entry is reported as skipped; any other
+// error aborts the import.
+func (ex *exchange) entry(externalID string, fn func() error) error {
+	_, err := ex.tx.ExecContext(ex.ctx, `SAVEPOINT exchange_entry`)
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+
+	var pqErr *pq.Error
+	if errors.Is(err, ErrDuplicateUPC) || (errors.As(err, &pqErr) && pqErr.Code.Class() == "23") {
+		_, rollbackErr := ex.tx.ExecContext(ex.ctx, `ROLLBACK TO SAVEPOINT exchange_entry`)
+		if rollbackErr != nil {
+			return rollbackErr
+		}
+		ex.skip(externalID, err.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = ex.tx.ExecContext(ex.ctx, `RELEASE SAVEPOINT exchange_entry`)
+	return err
+}
+
+// skip records an entry that could not be imported.
+func (ex *exchange) skip(externalID, message string) {
+	ex.report.Errors = append(ex.report.Errors, ExchangeError{ExternalID: externalID, Error: message})
+}
+
+func (ex *exchange) importGroup(group ExchangeGroup) error {
+	if group.ExternalID == "" || group.Name == "" {
+		ex.skip(group.ExternalID, "group needs an id and a name")
+		return nil
+	}
+
+	// A category of the same name is adopted by the group, unless it is
+	// archived.
+	var id int64
+	var archived bool
+	err := ex.tx.QueryRowContext(ex.ctx, `
+		SELECT id, deleted_at IS NOT NULL
+		FROM categories
+		WHERE external_id = $1 OR (external_id IS NULL AND lower(name) = lower($2))
+		ORDER BY external_id IS NULL
+		LIMIT 1
+		FOR UPDATE`, group.ExternalID, group.Name).Scan(&id, &archived)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		err = ex.tx.QueryRowContext(ex.ctx, `
+			INSERT INTO categories (name, description, image, external_id)
+			VALUES ($1, $1, '', $2)
+			RETURNING id`, group.Name, group.ExternalID).Scan(&id)
+		if err == nil {
+			ex.report.CategoriesCreated++
+		}
+	case err == nil && archived:
+		ex.skip(group.ExternalID, "category \""+group.Name+"\" is archived")
+		return nil
+	case err == nil:
+		_, err = ex.tx.ExecContext(ex.ctx, `
+			UPDATE categories
+			SET name = $2, external_id = $1, version = version + 1
+			WHERE id = $3`, group.ExternalID, group.Name, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Nested groups become subcategories. A category adopted by name keeps
+	// its place if the group's would close a cycle.
+	if parentID, ok := ex.groups[group.Parent]; ok && group.Parent != "" {
+		err = checkCategoryParent(ex.ctx, ex.tx, id, parentID)
+		if err == nil {
+			_, err = ex.tx.ExecContext(ex.ctx, `
+				UPDATE categories
+				SET parent_id = $2, version = version + 1
+				WHERE id = $1 AND parent_id IS DISTINCT FROM $2`, id, parentID)
+		}
+		if err != nil && !errors.Is(err, ErrCategoryCycle) && !errors.Is(err, ErrInvalidParent) {
+			return err
+		}
+	}
+
+	ex.groups[group.ExternalID] = id
+	return nil
+}
+
+// category returns the listed category of the group, which may come from an
+// earlier exchange.
+func (ex *exchange) category(externalID string) (int64, error) {
+	if id, ok := ex.groups[externalID]; ok {
+		return id, nil
+	}
+
+	var id int64
+	err := ex.tx.QueryRowContext(ex.ctx, `SELECT id FROM categories WHERE external_id = $1 AND deleted_at IS NULL`, externalID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	ex.groups[externalID] = id
+	return id, nil
+}
+
+// reference returns the id of the brand or unit with the name, adding it if
+// there is none. It returns errArchived if that one is archived.
+func (ex *exchange) reference(table, name string) (int64, error) {
+	var id int64
+	var archived bool
+	err := ex.tx.QueryRowContext(ex.ctx, `SELECT id, deleted_at IS NOT NULL FROM `+table+` WHERE lower(name) = lower($1)`, name).Scan(&id, &archived)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ex.tx.QueryRowContext(ex.ctx, `INSERT INTO `+table+` (name, description) VALUES ($1, $1) RETURNING id`, name).Scan(&id)
+	}
+	if err == nil && archived {
+		return 0, errArchived
+	}
+	return id, err
+}
+
+// fallback returns the id of the first listed row of the table the condition
+// holds for. Products from the accounting system carry no country or
+// discount, and often no brand, so new ones get these, just like the seed
+// products.
+func (ex *exchange) fallback(table, condition string) (int64, error) {
+	var id int64
+	err := ex.tx.QueryRowContext(ex.ctx, `SELECT id FROM `+table+` WHERE deleted_at IS NULL AND `+condition+` ORDER BY id LIMIT 1`).Scan(&id)
+	return id, err
+}
+
+// findVariant returns the variant with the external id, or else the listed
+// variant with the UPC, or ErrRecordNotFound.
+func (ex *exchange) findVariant(externalID, upc string) (*ProductVariant, error) {
+	var variant ProductVariant
+
+	err := scanVariant(ex.tx.QueryRowContext(ex.ctx, `
+		SELECT `+variantColumns+`
+		FROM product_variants
+		WHERE external_id = $1 OR (upc = $2 AND $2 <> '' AND deleted_at IS NULL)
+		ORDER BY external_id = $1 DESC NULLS LAST
+		LIMIT 1
+		FOR UPDATE`, externalID, upc), &variant)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &variant, nil
+}
+
+func (ex *exchange) findProduct(externalID string) (*Product, error) {
+	product, err := lockProduct(ex.ctx, ex.tx, "external_id", externalID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecordNotFound
+	}
+	return product, err
+}
+
+func (ex *exchange) importProduct(item ExchangeProduct) error {
+	if item.ExternalID == "" {
+		ex.skip("", "product \""+item.Name+"\" has no id")
+		return nil
+	}
+
+	product, err := ex.findProduct(item.ExternalID)
+	if errors.Is(err, ErrRecordNotFound) {
+		// A product added before the exchange is recognised by its UPC.
+		variant, err := ex.findVariant(item.ExternalID, item.UPC)
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			product = &Product{}
+		case err != nil:
+			return err
+		default:
+			product, err = lockProduct(ex.ctx, ex.tx, "id", variant.ProductID)
+			if err != nil {
+				return err
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if product.DeletedAt != nil {
+		ex.skip(item.ExternalID, "the product is archived")
+		return nil
+	}
+
+	if item.Name != "" {
+		product.Name = item.Name
+	}
+	if item.Description != "" {
+		product.Description = item.Description
+	}
+	if product.ID == 0 && product.Description == "" {
+		product.Description = product.Name
+	}
+
+	if item.Group != "" {
+		product.CategoryID, err = ex.category(item.Group)
+		if errors.Is(err, sql.ErrNoRows) {
+			ex.skip(item.ExternalID, "unknown group "+item.Group)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if item.Brand != "" {
+		product.BrandID, err = ex.reference("brands", item.Brand)
+		if errors.Is(err, errArchived) {
+			ex.skip(item.ExternalID, "brand \""+item.Brand+"\" is archived")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range []struct {
+		id        *int64
+		table     string
+		condition string
+	}{
+		{&product.CategoryID, "categories", "TRUE"},
+		{&product.BrandID, "brands", "TRUE"},
+		{&product.CountryID, "countries", "TRUE"},
+		{&product.DiscountID, "discounts", "discount_percent = 0"},
+	} {
+		if *ref.id != 0 {
+			continue
+		}
+		*ref.id, err = ex.fallback(ref.table, ref.condition)
+		if errors.Is(err, sql.ErrNoRows) {
+			ex.skip(item.ExternalID, "there is no "+strings.TrimSuffix(ref.table, "s")+" to give the new product")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if product.Name == "" || len(product.Name) > 100 {
+		ex.skip(item.ExternalID, "name must be between 1 and 100 bytes long")
+		return nil
+	}
+
+	created := product.ID == 0
+	if created {
+		err = insertProduct(ex.ctx, ex.tx, product)
+	} else {
+		err = updateProduct(ex.ctx, ex.tx, product)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = ex.tx.ExecContext(ex.ctx, `UPDATE products SET external_id = $1 WHERE id = $2`, item.ExternalID, product.ID)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		ex.report.ProductsCreated++
+	} else {
+		ex.report.ProductsUpdated++
+	}
+
+	// A product without characteristics is sold as its base variant, which
+	// carries the product's own UPC and unit.
+	var hasCharacteristics bool
+	err = ex.tx.QueryRowContext(ex.ctx, `
+		SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1 AND external_id LIKE $2 || '#%')`,
+		product.ID, item.ExternalID).Scan(&hasCharacteristics)
+	if err != nil || hasCharacteristics {
+		return err
+	}
+
+	return ex.importOffer(ExchangeOffer{
+		ExternalID:        item.ExternalID,
+		ProductExternalID: item.ExternalID,
+		UPC:               item.UPC,
+		Unit:              item.Unit,
+	})
+}
+
+func (ex *exchange) importOffer(offer ExchangeOffer) error {
+	if offer.ExternalID == "" {
+		ex.skip("", "offer \""+offer.Name+"\" has no id")
+		return nil
+	}
+
+	variant, err := ex.findVariant(offer.ExternalID, offer.UPC)
+	switch {
+	case errors.Is(err, ErrRecordNotFound):
+		product, err := ex.findProduct(offer.ProductExternalID)
+		if errors.Is(err, ErrRecordNotFound) {
+			ex.skip(offer.ExternalID, "unknown product "+offer.ProductExternalID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if product.DeletedAt != nil {
+			ex.skip(offer.ExternalID, "the product is archived")
+			return nil
+		}
+
+		// The first offer of a product takes over its only variant if that
+		// isn't linked to an offer yet, or is the product's own base variant.
+		variant = &ProductVariant{ProductID: product.ID, Step: 1}
+		err = scanVariant(ex.tx.QueryRowContext(ex.ctx, `
+			SELECT `+variantColumns+`
+			FROM product_variants
+			WHERE product_id = $1 AND (external_id IS NULL OR external_id = $2) AND deleted_at IS NULL
+				AND (SELECT count(*) FROM product_variants WHERE product_id = $1 AND deleted_at IS NULL) = 1
+			FOR UPDATE`, product.ID, offer.ProductExternalID), variant)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	case err != nil:
+		return err
+	case variant.DeletedAt != nil:
+		ex.skip(offer.ExternalID, "the variant is archived")
+		return nil
+	default:
+		product, err := lockProduct(ex.ctx, ex.tx, "id", variant.ProductID)
+		if err != nil {
+			return err
+		}
+		if product.DeletedAt != nil {
+			ex.skip(offer.ExternalID, "the product is archived")
+			return nil
+		}
+	}
+
+	if offer.UPC != "" {
+		variant.UPC = offer.UPC
+	}
+	if offer.Name != "" {
+		variant.Name = offer.Name
+	}
+	if offer.Price != nil {
+		variant.Price = *offer.Price
+	}
+	if offer.Quantity != nil {
+		variant.Quantity = *offer.Quantity
+	}
+	if offer.Unit != "" {
+		variant.UnitID, err = ex.reference("units", offer.Unit)
+		if errors.Is(err, errArchived) {
+			ex.skip(offer.ExternalID, "unit \""+offer.Unit+"\" is archived")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if variant.Price < 0 || variant.Quantity < 0 || len(variant.Name) > 100 || len(variant.UPC) > 50 {
+		ex.skip(offer.ExternalID, "price and quantity must not be negative, the name at most 100 and the upc 50 bytes long")
+		return nil
+	}
+
+	created := variant.ID == 0
+	if created {
+		err = insertVariant(ex.ctx, ex.tx, variant)
+	} else {
+		err = updateVariant(ex.ctx, ex.tx, variant)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = ex.tx.ExecContext(ex.ctx, `UPDATE product_variants SET external_id = $1 WHERE id = $2`, offer.ExternalID, variant.ID)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		ex.report.VariantsCreated++
+	} else {
+		ex.report.VariantsUpdated++
+	}
+	return nil
+}
+
+// ExchangeOrder is an order as handed to the accounting system.
+type ExchangeOrder struct {
+	OrderDB
+	Items []*ExchangeOrderItem
+}
+
+// ExchangeOrderItem is an order item with what the accounting system needs
+// to recognise the variant sold. Price is the unit price after the discount.
+type ExchangeOrderItem struct {
+	ProductID         int64
+	VariantID         int64
+	ProductExternalID string
+	VariantExternalID string
+	Name              string
+	VariantName       string
+	UPC               string
+	UnitName          string
+	Quantity          float64
+	Price             int64
+	DiscountPercent   int
+	Total             int64
+}
+
+// PendingOrders returns up to limit orders that have not been confirmed as
+// exported yet, oldest first, with their items.
+func (m ExchangeModel) PendingOrders(limit int) ([]*ExchangeOrder, error) {
+	query := `
+		SELECT o.id, o.user_id, u.firstname, u.lastname, u.email, COALESCE(o.total, 0), COALESCE(o.address, ''),
+			o.status_id, o.created_at, COALESCE(o.delivered_at, o.created_at), s.name, s.description
+		FROM orders o
+		INNER JOIN users u ON o.user_id = u.id
+		INNER JOIN statuses s ON o.status_id = s.id
+		WHERE o.exported_at IS NULL
+		ORDER BY o.id
+		LIMIT $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*ExchangeOrder{}
+	byID := map[int64]*ExchangeOrder{}
+	ids := []int64{}
+
+	for rows.Next() {
+		var order ExchangeOrder
+		err := rows.Scan(
+			&order.ID,
+			&order.UserID,
+			&order.FirstName,
+			&order.LastName,
+			&order.Email,
+			&order.Total,
+			&order.Address,
+			&order.StatusID,
+			&order.CreatedAt,
+			&order.DeliveredAt,
+			&order.StatusName,
+			&order.StatusDescription,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, &order)
+		byID[order.ID] = &order
+		ids = append(ids, order.ID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return orders, nil
+	}
+
+	query = `
+		SELECT oi.order_id, oi.product_id, COALESCE(oi.variant_id, 0),
+			COALESCE(p.external_id, ''), COALESCE(pv.external_id, ''),
+			COALESCE(p.name, ''), COALESCE(pv.name, ''), COALESCE(pv.upc, ''), COALESCE(un.name, ''),
+			oi.quantity, oi.price, oi.discount_percent, oi.total
+		FROM order_items oi
+		LEFT JOIN products p ON p.id = oi.product_id
+		LEFT JOIN product_variants pv ON pv.id = oi.variant_id
+		LEFT JOIN units un ON un.id = pv.unit_id
+		WHERE oi.order_id = ANY($1)
+		ORDER BY oi.order_id, oi.id`
+
+	itemRows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var orderID int64
+		var item ExchangeOrderItem
+		err := itemRows.Scan(
+			&orderID,
+			&item.ProductID,
+			&item.VariantID,
+			&item.ProductExternalID,
+			&item.VariantExternalID,
+			&item.Name,
+			&item.VariantName,
+			&item.UPC,
+			&item.UnitName,
+			&item.Quantity,
+			&item.Price,
+			&item.DiscountPercent,
+			&item.Total,
+		)
+		if err != nil {
+			return nil, err
+		}
+		item.Price = DiscountedPrice(item.Price, item.DiscountPercent)
+		byID[orderID].Items = append(byID[orderID].Items, &item)
+	}
+
+	if err = itemRows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrExchangeFileTooLarge is returned for a part that would grow an uploaded
+// exchange file beyond its limit.
+var ErrExchangeFileTooLarge = errors.New("exchange file too large")
+
+// exchangeSessionTTL is how long the files and sale query of an abandoned
+// exchange session are kept.
+const exchangeSessionTTL = 24 * time.Hour
+
+// ResetSession drops what an earlier exchange of the session left behind,
+// along with the leftovers of sessions abandoned more than a day ago.
+func (m ExchangeModel) ResetSession(sessionID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stale := time.Now().Add(-exchangeSessionTTL)
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM exchange_files WHERE session_id = $1 OR updated_at < $2`, sessionID, stale)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM exchange_sale_queries WHERE session_id = $1 OR updated_at < $2`, sessionID, stale)
+	return err
+}
+
+// AppendFile adds a part of an uploaded file to what has been received of it
+// so far. 1C sends large files in parts. It returns ErrExchangeFileTooLarge,
+// leaving the file as it was, if the file would grow beyond maxBytes.
+func (m ExchangeModel) AppendFile(sessionID int64, name string, part []byte, maxBytes int64) error {
+	if int64(len(part)) > maxBytes {
+		return ErrExchangeFileTooLarge
+	}
+
+	query := `
+		INSERT INTO exchange_files (session_id, name, content)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (session_id, name) DO UPDATE
+		SET content = exchange_files.content || EXCLUDED.content, updated_at = NOW()
+		WHERE octet_length(exchange_files.content) + octet_length(EXCLUDED.content) <= $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, sessionID, name, part, maxBytes)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrExchangeFileTooLarge
+	}
+	return nil
+}
+
+// TakeFile removes the uploaded file and returns its content, or
+// ErrRecordNotFound if there is no such file.
+func (m ExchangeModel) TakeFile(sessionID int64, name string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var content []byte
+	err := m.DB.QueryRowContext(ctx, `DELETE FROM exchange_files WHERE session_id = $1 AND name = $2 RETURNING content`, sessionID, name).Scan(&content)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+// SaveSaleQuery remembers the orders handed to 1C by the session's last sale
+// query until it confirms them.
+func (m ExchangeModel) SaveSaleQuery(sessionID int64, ids []int64) error {
+	query := `
+		INSERT INTO exchange_sale_queries (session_id, order_ids)
+		VALUES ($1, $2)
+		ON CONFLICT (session_id) DO UPDATE
+		SET order_ids = EXCLUDED.order_ids, updated_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, sessionID, pq.Array(ids))
+	return err
+}
+
+// MarkOrdersExported records that the accounting system confirmed the orders
+// of the session's last sale query, so that they are not handed to it again.
+// Without a sale query there is nothing to confirm.
+func (m ExchangeModel) MarkOrdersExported(sessionID int64) error {
+	query := `
+		WITH query AS (
+			DELETE FROM exchange_sale_queries
+			WHERE session_id = $1
+			RETURNING order_ids
+		)
+		UPDATE orders
+		SET exported_at = NOW()
+		WHERE id = ANY(SELECT unnest(order_ids) FROM query) AND exported_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, sessionID)
+	return err
+}
@@ -0,0 +1,271 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// priceBucketBounds are the lower bounds, in tiyn, of the price facet
+// buckets. The last bucket is open-ended.
+var priceBucketBounds = []int64{0, 50000, 100000, 200000, 500000}
+
+type FacetValue struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type PriceBucket struct {
+	Min   int64  `json:"min"`
+	Max   *int64 `json:"max"`
+	Count int    `json:"count"`
+}
+
+// AttributeFacet counts the products per value of a bool or enum attribute,
+// or gives the range of a number attribute. Text attributes are not
+// faceted.
+type AttributeFacet struct {
+	Code   string                 `json:"code"`
+	Name   string                 `json:"name"`
+	Type   string                 `json:"type"`
+	Unit   string                 `json:"unit,omitempty"`
+	Values []*AttributeFacetValue `json:"values,omitempty"`
+	Min    *float64               `json:"min,omitempty"`
+	Max    *float64               `json:"max,omitempty"`
+}
+
+// AttributeFacetValue is a value of an attribute as its filter takes it,
+// bools as "true" and "false".
+type AttributeFacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type Facets struct {
+	Categories []*FacetValue     `json:"categories"`
+	Brands     []*FacetValue     `json:"brands"`
+	Countries  []*FacetValue     `json:"countries"`
+	Discounts  []*FacetValue     `json:"discounts"`
+	Prices     []*PriceBucket    `json:"prices"`
+	Attributes []*AttributeFacet `json:"attributes"`
+}
+
+// Facets counts the products matching the filter per category, brand,
+// country, active discount, price bucket and value of the filterable
+// attributes. Each dimension ignores its own filter.
+func (p ProductModel) Facets(filter ProductFilter) (*Facets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	facets := &Facets{}
+
+	for _, dimension := range []struct {
+		name   string
+		table  string
+		extra  string
+		values *[]*FacetValue
+	}{
+		{FacetCategory, "categories", "", &facets.Categories},
+		{FacetBrand, "brands", "", &facets.Brands},
+		{FacetCountry, "countries", "", &facets.Countries},
+		{FacetDiscount, "discounts", "AND " + activeDiscountSQL, &facets.Discounts},
+	} {
+		args := sqlArgs{}
+		from := filter.from(&args)
+		where := filter.where(dimension.name, &args)
+
+		query := fmt.Sprintf(`
+			SELECT %[1]s.id, %[1]s.name, count(*)
+			FROM %[2]s
+			WHERE %[3]s AND %[1]s.id IS NOT NULL AND %[1]s.deleted_at IS NULL %[4]s
+			GROUP BY %[1]s.id, %[1]s.name
+			ORDER BY count(*) DESC, %[1]s.name ASC`, dimension.table, from, where, dimension.extra)
+
+		*dimension.values, err = scanFacetValues(ctx, tx, query, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	facets.Prices, err = priceFacet(ctx, tx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	facets.Attributes, err = attributeFacets(ctx, tx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return facets, tx.Commit()
+}
+
+func scanFacetValues(ctx context.Context, tx *sql.Tx, query string, args sqlArgs) ([]*FacetValue, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []*FacetValue{}
+
+	for rows.Next() {
+		var value FacetValue
+		err := rows.Scan(&value.ID, &value.Name, &value.Count)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, &value)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// priceFacet counts the products per price bucket of their effective price.
+// Empty buckets are included so the UI can render a stable list.
+func priceFacet(ctx context.Context, tx *sql.Tx, filter ProductFilter) ([]*PriceBucket, error) {
+	args := sqlArgs{}
+	from := filter.from(&args)
+	where := filter.where(FacetPrice, &args)
+
+	query := fmt.Sprintf(`
+		SELECT width_bucket(%s::bigint, %s::bigint[]), count(*)
+		FROM %s
+		WHERE %s
+		GROUP BY 1`, effectivePriceSQL, args.add(pq.Array(priceBucketBounds)), from, where)
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := make([]*PriceBucket, len(priceBucketBounds))
+	for i, min := range priceBucketBounds {
+		buckets[i] = &PriceBucket{Min: min}
+		if i+1 < len(priceBucketBounds) {
+			max := priceBucketBounds[i+1]
+			buckets[i].Max = &max
+		}
+	}
+
+	for rows.Next() {
+		var bucket, count int
+		err := rows.Scan(&bucket, &count)
+		if err != nil {
+			return nil, err
+		}
+		// width_bucket numbers the buckets from 1; 0 is below the first bound,
+		// which can't happen for prices.
+		if bucket >= 1 && bucket <= len(buckets) {
+			buckets[bucket-1].Count += count
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// attributeFacets facets the products by every filterable attribute that is
+// not a text attribute.
+func attributeFacets(ctx context.Context, tx *sql.Tx, filter ProductFilter) ([]*AttributeFacet, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT code, name, type, unit
+		FROM attributes
+		WHERE filterable AND type <> $1
+		ORDER BY name, id`, AttributeText)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := []*AttributeFacet{}
+
+	for rows.Next() {
+		var facet AttributeFacet
+		err := rows.Scan(&facet.Code, &facet.Name, &facet.Type, &facet.Unit)
+		if err != nil {
+			return nil, err
+		}
+		facets = append(facets, &facet)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, facet := range facets {
+		args := sqlArgs{}
+		from := filter.from(&args)
+		where := filter.where(attributeFacet(facet.Code), &args)
+		join := fmt.Sprintf(`INNER JOIN product_attributes ON product_attributes.product_id = products.id
+			INNER JOIN attributes ON attributes.id = product_attributes.attribute_id AND attributes.code = %s`, args.add(facet.Code))
+
+		if facet.Type == AttributeNumber {
+			query := fmt.Sprintf(`
+				SELECT min(product_attributes.number_value), max(product_attributes.number_value)
+				FROM %s
+				%s
+				WHERE %s`, from, join, where)
+
+			err = tx.QueryRowContext(ctx, query, args...).Scan(&facet.Min, &facet.Max)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		query := fmt.Sprintf(`
+			SELECT COALESCE(product_attributes.text_value, product_attributes.bool_value::text), count(*)
+			FROM %s
+			%s
+			WHERE %s
+			GROUP BY 1
+			ORDER BY count(*) DESC, 1`, from, join, where)
+
+		facet.Values, err = scanAttributeFacetValues(ctx, tx, query, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return facets, nil
+}
+
+func scanAttributeFacetValues(ctx context.Context, tx *sql.Tx, query string, args sqlArgs) ([]*AttributeFacetValue, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []*AttributeFacetValue{}
+
+	for rows.Next() {
+		var value AttributeFacetValue
+		err := rows.Scan(&value.Value, &value.Count)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, &value)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
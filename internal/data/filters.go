@@ -12,6 +12,13 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// SortColumns maps a sort value, without its "-" prefix, to the SQL
+	// expression it orders by. It is set by the model running the query.
+	SortColumns map[string]string
+	// After and Before are cursors taken from a previous page's Metadata.
+	// Setting either one switches from page numbers to keyset pagination.
+	After  string
+	Before string
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -20,12 +27,36 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	//	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Check(f.After == "" || f.Before == "", "before", "must not be used together with after")
+	if f.After != "" {
+		c, err := decodeCursor(f.After)
+		v.Check(err == nil && c.Sort == f.Sort, "after", "invalid cursor")
+	}
+	if f.Before != "" {
+		c, err := decodeCursor(f.Before)
+		v.Check(err == nil && c.Sort == f.Sort, "before", "invalid cursor")
+	}
+}
+
+// SortRelevance orders full-text search results by their rank, best match
+// first.
+const SortRelevance = "relevance"
+
+// referenceSortColumns are the sort columns of a reference-data list such as
+// brands or units.
+func referenceSortColumns(table string) map[string]string {
+	return map[string]string{
+		"id":   table + ".id",
+		"name": table + ".name",
+	}
 }
 
 func (f Filters) sortColumn() string {
 	for _, safeValue := range f.SortSafelist {
 		if f.Sort == safeValue {
-			return "products." + strings.TrimPrefix(f.Sort, "-")
+			if column, ok := f.SortColumns[strings.TrimPrefix(f.Sort, "-")]; ok {
+				return column
+			}
 		}
 	}
 	panic("unsafe sort parameter: " + f.Sort)
@@ -34,7 +65,7 @@ func (f Filters) sortColumn() string {
 // Return the sort direction ("ASC" or "DESC") depending on the prefix character of the
 // Sort field.
 func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
+	if strings.HasPrefix(f.Sort, "-") || f.Sort == SortRelevance {
 		return "DESC"
 	}
 	return "ASC"
@@ -49,11 +80,13 @@ func (f Filters) offset() int {
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata
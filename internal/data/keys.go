@@ -0,0 +1,231 @@
+package data
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// keyReloadInterval is how often the key manager picks up keys generated or
+// retired by other instances.
+const keyReloadInterval = time.Minute
+
+// keyMissReloadInterval limits the reloads caused by tokens with an unknown
+// kid. Such a token may be signed with a key another instance has just
+// generated, or it may be forged.
+const keyMissReloadInterval = 5 * time.Second
+
+var ErrNoSigningKey = errors.New("no active signing key")
+
+// JWK is the public part of a signing key as published in the JWKS document.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// KeyManager signs tokens with the newest active key and verifies them with
+// any active key, so that tokens signed before a rotation stay valid until
+// the old key is retired.
+type KeyManager struct {
+	Model SigningKeyModel
+
+	mu         sync.RWMutex
+	keys       []*SigningKey
+	byKID      map[string]*SigningKey
+	loadedAt   time.Time
+	missLoadAt time.Time
+}
+
+// Init makes sure there is at least one active key, generating an Ed25519
+// key on first start.
+func (k *KeyManager) Init() error {
+	keys, err := k.Model.GetActive()
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		key, err := GenerateSigningKey(AlgorithmEdDSA)
+		if err != nil {
+			return err
+		}
+		if err = k.Model.Insert(key); err != nil {
+			return err
+		}
+	}
+
+	return k.Load()
+}
+
+// Load reads the active keys from the database.
+func (k *KeyManager) Load() error {
+	keys, err := k.Model.GetActive()
+	if err != nil {
+		return err
+	}
+
+	byKID := make(map[string]*SigningKey, len(keys))
+	for _, key := range keys {
+		byKID[key.KID] = key
+	}
+
+	k.mu.Lock()
+	k.keys, k.byKID, k.loadedAt = keys, byKID, time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *KeyManager) reloadIfStale() error {
+	k.mu.RLock()
+	stale := time.Since(k.loadedAt) > keyReloadInterval
+	k.mu.RUnlock()
+
+	if !stale {
+		return nil
+	}
+	return k.Load()
+}
+
+// lookup returns the active key with the kid, or nil. On a miss the keys are
+// reloaded once, at most every keyMissReloadInterval, in case the key was
+// generated since they were last loaded.
+func (k *KeyManager) lookup(kid string) (*SigningKey, error) {
+	k.mu.RLock()
+	key, ok := k.byKID[kid]
+	k.mu.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+
+	k.mu.Lock()
+	reload := time.Since(k.missLoadAt) >= keyMissReloadInterval
+	if reload {
+		k.missLoadAt = time.Now()
+	}
+	k.mu.Unlock()
+
+	if !reload {
+		return nil, nil
+	}
+	if err := k.Load(); err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.byKID[kid], nil
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+}
+
+// Sign signs the claims with the current signing key and sets its kid in
+// the token header.
+func (k *KeyManager) Sign(claims jwt.MapClaims) (string, error) {
+	if err := k.reloadIfStale(); err != nil {
+		return "", err
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.keys) == 0 {
+		return "", ErrNoSigningKey
+	}
+	key := k.keys[0]
+
+	method, err := signingMethod(key.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.KID
+	return token.SignedString(key.PrivateKey)
+}
+
+// Parse verifies the token against the active key named by its kid header
+// and returns its claims. An unknown kid triggers a reload of the keys.
+func (k *KeyManager) Parse(tokenString string) (jwt.MapClaims, error) {
+	if err := k.reloadIfStale(); err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		key, err := k.lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("incorrect signing method: %v", token.Header["alg"])
+		}
+		return key.PublicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, errors.New("invalid token")
+}
+
+// JWKS returns the public keys of every active key.
+func (k *KeyManager) JWKS() ([]JWK, error) {
+	if err := k.reloadIfStale(); err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	jwks := make([]JWK, 0, len(k.keys))
+	for _, key := range k.keys {
+		jwk := JWK{KeyID: key.KID, Algorithm: key.Algorithm, Use: "sig"}
+
+		switch public := key.PublicKey.(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+
+		jwks = append(jwks, jwk)
+	}
+	return jwks, nil
+}
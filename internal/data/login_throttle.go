@@ -0,0 +1,209 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	ThrottleScopeAccount = "account"
+	ThrottleScopeIP      = "ip"
+)
+
+// ThrottlePolicy describes how failed attempts are punished. After
+// DelayAfter failures every further attempt has to wait twice as long as
+// the previous one, up to MaxDelay, and after LockAfter failures the key is
+// locked for LockFor. Failures older than Window are forgotten.
+type ThrottlePolicy struct {
+	DelayAfter int
+	MaxDelay   time.Duration
+	LockAfter  int
+	LockFor    time.Duration
+	Window     time.Duration
+}
+
+var (
+	AccountThrottlePolicy = ThrottlePolicy{
+		DelayAfter: 3,
+		MaxDelay:   5 * time.Minute,
+		LockAfter:  10,
+		LockFor:    30 * time.Minute,
+		Window:     time.Hour,
+	}
+	IPThrottlePolicy = ThrottlePolicy{
+		DelayAfter: 10,
+		MaxDelay:   time.Minute,
+		LockAfter:  50,
+		LockFor:    time.Hour,
+		Window:     time.Hour,
+	}
+)
+
+// Throttle is the failure count of a single account or IP address.
+type Throttle struct {
+	Scope        string
+	Key          string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+type ThrottleModel struct {
+	DB *sql.DB
+}
+
+// RetryAfter returns how long the key has to wait before its next attempt,
+// or zero if it may try right away.
+func (t *Throttle) RetryAfter(policy ThrottlePolicy, now time.Time) time.Duration {
+	if t.LockedUntil != nil && t.LockedUntil.After(now) {
+		return t.LockedUntil.Sub(now)
+	}
+
+	if t.Failures < policy.DelayAfter || now.Sub(t.LastFailedAt) > policy.Window {
+		return 0
+	}
+
+	delay := time.Duration(math.Pow(2, float64(t.Failures-policy.DelayAfter))) * time.Second
+	if delay > policy.MaxDelay || delay <= 0 {
+		delay = policy.MaxDelay
+	}
+
+	if wait := t.LastFailedAt.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Locked reports whether the last failure locked the key.
+func (t *Throttle) Locked(now time.Time) bool {
+	return t.LockedUntil != nil && t.LockedUntil.After(now)
+}
+
+// Get returns the throttle of the key. Keys without failures get an empty
+// throttle rather than an error.
+func (m ThrottleModel) Get(scope, key string) (*Throttle, error) {
+	query := `
+		SELECT failures, last_failed_at, locked_until
+		FROM login_throttles
+		WHERE scope = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	throttle := &Throttle{Scope: scope, Key: key}
+	err := m.DB.QueryRowContext(ctx, query, scope, key).Scan(&throttle.Failures, &throttle.LastFailedAt, &throttle.LockedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return throttle, nil
+}
+
+// RecordFailure counts a failed attempt and locks the key once it reaches
+// the policy limit.
+func (m ThrottleModel) RecordFailure(scope, key string, policy ThrottlePolicy) (*Throttle, error) {
+	query := `
+	INSERT INTO login_throttles (scope, key, failures, last_failed_at)
+	VALUES ($1, $2, 1, NOW())
+	ON CONFLICT (scope, key) DO UPDATE
+	SET failures = CASE
+			WHEN login_throttles.last_failed_at < NOW() - make_interval(secs => $3) THEN 1
+			ELSE login_throttles.failures + 1
+		END,
+		last_failed_at = NOW()
+	RETURNING failures, last_failed_at, locked_until`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	throttle := &Throttle{Scope: scope, Key: key}
+	err := m.DB.QueryRowContext(ctx, query, scope, key, policy.Window.Seconds()).Scan(&throttle.Failures, &throttle.LastFailedAt, &throttle.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	if throttle.Failures >= policy.LockAfter {
+		lockedUntil := throttle.LastFailedAt.Add(policy.LockFor)
+		query = `
+		UPDATE login_throttles
+		SET failures = 0, locked_until = $3
+		WHERE scope = $1 AND key = $2`
+
+		_, err = m.DB.ExecContext(ctx, query, scope, key, lockedUntil)
+		if err != nil {
+			return nil, err
+		}
+		throttle.LockedUntil = &lockedUntil
+	}
+
+	return throttle, nil
+}
+
+// Reset forgets the failures of the key and lifts its lock.
+func (m ThrottleModel) Reset(scope, key string) error {
+	query := `DELETE FROM login_throttles WHERE scope = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, key)
+	return err
+}
+
+func hashUnlockToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// CreateUnlockToken generates a single-use token that lifts the lock of the
+// user's account before it expires.
+func (m ThrottleModel) CreateUnlockToken(userID int64, expiresAt time.Time) (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	query := `
+	INSERT INTO account_unlock_tokens (token_hash, user_id, expires_at)
+	VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, hashUnlockToken(token), userID, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// UseUnlockToken consumes the token and returns the id of the user whose
+// account it unlocks.
+func (m ThrottleModel) UseUnlockToken(token string) (int64, error) {
+	query := `
+	DELETE FROM account_unlock_tokens
+	WHERE token_hash = $1 AND expires_at > NOW()
+	RETURNING user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+	err := m.DB.QueryRowContext(ctx, query, hashUnlockToken(token)).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return userID, nil
+}
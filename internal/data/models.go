@@ -12,34 +12,56 @@ var (
 
 type Models struct {
 	Products        ProductModel
+	Variants        ProductVariantModel
+	Images          ProductImageModel
 	Brands          BrandModel
 	Category        CategoryModel
 	Units           UnitModel
 	Country         CountryModel
 	Discount        DiscountModel
 	Roles           RoleModel
+	Permissions     PermissionModel
 	Users           UserModel
-	Tokens          TokenModel
+	Sessions        SessionModel
+	Throttles       ThrottleModel
+	Keys            *KeyManager
 	Statuses        StatusModel
 	Orders          OrderModel
 	OrderItems      OrderItemModel
+	StatusHistory   OrderStatusHistoryModel
 	ActivationLinks ActivationLinkModel
+	Carts           CartModel
+	Exchange        ExchangeModel
+	Archive         ArchiveModel
+	Attributes      AttributeModel
 }
 
 func NewModels(db *sql.DB) Models {
+	keys := &KeyManager{Model: SigningKeyModel{DB: db}}
+
 	return Models{
 		Products:        ProductModel{DB: db},
+		Variants:        ProductVariantModel{DB: db},
+		Images:          ProductImageModel{DB: db},
 		Brands:          BrandModel{DB: db},
 		Category:        CategoryModel{DB: db},
 		Units:           UnitModel{DB: db},
 		Country:         CountryModel{DB: db},
 		Discount:        DiscountModel{DB: db},
 		Roles:           RoleModel{DB: db},
+		Permissions:     PermissionModel{DB: db},
 		Users:           UserModel{DB: db},
-		Tokens:          TokenModel{DB: db},
+		Sessions:        SessionModel{DB: db, Keys: keys},
+		Throttles:       ThrottleModel{DB: db},
+		Keys:            keys,
 		Statuses:        StatusModel{DB: db},
 		Orders:          OrderModel{DB: db},
 		OrderItems:      OrderItemModel{DB: db},
+		StatusHistory:   OrderStatusHistoryModel{DB: db},
 		ActivationLinks: ActivationLinkModel{DB: db},
+		Carts:           CartModel{DB: db},
+		Exchange:        ExchangeModel{DB: db},
+		Archive:         ArchiveModel{DB: db},
+		Attributes:      AttributeModel{DB: db},
 	}
 }
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/lib/pq"
+)
+
+// newTestModels connects to the database named by TEST_DB_DSN, migrates it
+// and seeds the reference data products need. Tests using it are skipped
+// when the variable is not set.
+func newTestModels(t *testing.T) Models {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := migrate.New("file://../../migrations", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatal(err)
+	}
+
+	models := NewModels(db)
+	for _, seed := range []func() error{
+		models.Units.Init,
+		models.Discount.Init,
+		models.Brands.Init,
+		models.Country.Init,
+		models.Category.Init,
+	} {
+		if err := seed(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return models
+}
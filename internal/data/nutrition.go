@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Allergens are the codes of the 14 allergens food labels in the EU have to
+// declare (Regulation (EU) No 1169/2011, Annex II).
+var Allergens = []string{
+	"gluten", "crustaceans", "eggs", "fish", "peanuts", "soybeans", "milk",
+	"nuts", "celery", "mustard", "sesame", "sulphites", "lupin", "molluscs",
+}
+
+// Bases of the nutrition facts.
+const (
+	NutritionPer100g  = "100g"
+	NutritionPer100ml = "100ml"
+)
+
+// Nutrition holds the nutrition facts of a product per 100 g, or per 100 ml
+// for liquids. Energy is in kJ and kcal, everything else in grams.
+type Nutrition struct {
+	Per           string  `json:"per"`
+	EnergyKJ      float64 `json:"energy_kj"`
+	EnergyKcal    float64 `json:"energy_kcal"`
+	Fat           float64 `json:"fat"`
+	Saturates     float64 `json:"saturates"`
+	Carbohydrates float64 `json:"carbohydrates"`
+	Sugars        float64 `json:"sugars"`
+	Protein       float64 `json:"protein"`
+	Salt          float64 `json:"salt"`
+}
+
+func ValidateNutrition(v *validator.Validator, nutrition *Nutrition) {
+	v.Check(validator.PermittedValue(nutrition.Per, NutritionPer100g, NutritionPer100ml), "nutrition.per", "must be 100g or 100ml")
+
+	for _, fact := range []struct {
+		key   string
+		value float64
+	}{
+		{"energy_kj", nutrition.EnergyKJ},
+		{"energy_kcal", nutrition.EnergyKcal},
+		{"fat", nutrition.Fat},
+		{"saturates", nutrition.Saturates},
+		{"carbohydrates", nutrition.Carbohydrates},
+		{"sugars", nutrition.Sugars},
+		{"protein", nutrition.Protein},
+		{"salt", nutrition.Salt},
+	} {
+		v.Check(fact.value >= 0, "nutrition."+fact.key, "can not be negative")
+	}
+
+	// Pure fat has the most energy of any food, 900 kcal per 100 g.
+	v.Check(nutrition.EnergyKcal <= 900, "nutrition.energy_kcal", "must not be more than 900")
+	v.Check(nutrition.EnergyKJ <= 3800, "nutrition.energy_kj", "must not be more than 3800")
+	v.Check(nutrition.Saturates <= nutrition.Fat, "nutrition.saturates", "must not be more than fat")
+	v.Check(nutrition.Sugars <= nutrition.Carbohydrates, "nutrition.sugars", "must not be more than carbohydrates")
+
+	if nutrition.Per == NutritionPer100g {
+		total := nutrition.Fat + nutrition.Carbohydrates + nutrition.Protein + nutrition.Salt
+		v.Check(total <= 100, "nutrition", "fat, carbohydrates, protein and salt must not add up to more than 100 g")
+	}
+}
+
+func ValidateAllergens(v *validator.Validator, allergens []string) {
+	for _, allergen := range allergens {
+		v.Check(validator.PermittedValue(allergen, Allergens...), "allergens", "must only contain the codes of the EU 14 allergens")
+	}
+	v.Check(validator.Unique(allergens), "allergens", "must not contain duplicate values")
+}
+
+// GetNutrition returns the nutrition facts of the product, or nil if they
+// have not been entered.
+func (p ProductModel) GetNutrition(productID int64) (*Nutrition, error) {
+	query := `
+		SELECT per, energy_kj, energy_kcal, fat, saturates, carbohydrates, sugars, protein, salt
+		FROM product_nutrition
+		WHERE product_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var nutrition Nutrition
+	err := p.DB.QueryRowContext(ctx, query, productID).Scan(
+		&nutrition.Per,
+		&nutrition.EnergyKJ,
+		&nutrition.EnergyKcal,
+		&nutrition.Fat,
+		&nutrition.Saturates,
+		&nutrition.Carbohydrates,
+		&nutrition.Sugars,
+		&nutrition.Protein,
+		&nutrition.Salt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	return &nutrition, nil
+}
+
+// SetNutrition replaces the nutrition facts and allergens of the product. A
+// nil nutrition removes it and nil allergens mark them as not declared. It
+// counts as an edit of the product: it returns ErrEditConflict if the
+// product is no longer at the version it was read at.
+func (p ProductModel) SetNutrition(product *Product, nutrition *Nutrition, allergens []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE products
+		SET allergens = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`, pq.Array(allergens), product.ID, product.Version).Scan(&product.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_nutrition WHERE product_id = $1`, product.ID)
+	if err != nil {
+		return err
+	}
+
+	if nutrition != nil {
+		query := `
+			INSERT INTO product_nutrition (product_id, per, energy_kj, energy_kcal, fat, saturates, carbohydrates, sugars, protein, salt)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+		args := []any{
+			product.ID,
+			nutrition.Per,
+			nutrition.EnergyKJ,
+			nutrition.EnergyKcal,
+			nutrition.Fat,
+			nutrition.Saturates,
+			nutrition.Carbohydrates,
+			nutrition.Sugars,
+			nutrition.Protein,
+			nutrition.Salt,
+		}
+
+		_, err = tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+)
+
+func TestValidateNutrition(t *testing.T) {
+	valid := func() Nutrition {
+		return Nutrition{
+			Per:           NutritionPer100g,
+			EnergyKJ:      1500,
+			EnergyKcal:    360,
+			Fat:           10,
+			Saturates:     4,
+			Carbohydrates: 60,
+			Sugars:        20,
+			Protein:       8,
+			Salt:          1,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(n *Nutrition)
+		errors []string
+	}{
+		{
+			name:   "valid",
+			modify: func(n *Nutrition) {},
+		},
+		{
+			name:   "unknown base",
+			modify: func(n *Nutrition) { n.Per = "100oz" },
+			errors: []string{"nutrition.per"},
+		},
+		{
+			name:   "negative fact",
+			modify: func(n *Nutrition) { n.Protein = -1 },
+			errors: []string{"nutrition.protein"},
+		},
+		{
+			name:   "too much energy",
+			modify: func(n *Nutrition) { n.EnergyKcal, n.EnergyKJ = 901, 3801 },
+			errors: []string{"nutrition.energy_kcal", "nutrition.energy_kj"},
+		},
+		{
+			name:   "more saturates than fat",
+			modify: func(n *Nutrition) { n.Saturates = 11 },
+			errors: []string{"nutrition.saturates"},
+		},
+		{
+			name:   "more sugars than carbohydrates",
+			modify: func(n *Nutrition) { n.Sugars = 61 },
+			errors: []string{"nutrition.sugars"},
+		},
+		{
+			name:   "more than 100 g",
+			modify: func(n *Nutrition) { n.Carbohydrates = 90 },
+			errors: []string{"nutrition"},
+		},
+		{
+			name:   "more than 100 g of a liquid",
+			modify: func(n *Nutrition) { n.Per, n.Carbohydrates = NutritionPer100ml, 90 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nutrition := valid()
+			tt.modify(&nutrition)
+
+			v := validator.New()
+			ValidateNutrition(v, &nutrition)
+
+			keys := []string{}
+			for key := range v.Errors {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			want := append([]string{}, tt.errors...)
+			sort.Strings(want)
+
+			if !reflect.DeepEqual(keys, want) {
+				t.Errorf("errors = %v, want errors for %v", v.Errors, want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/dexciuq/yummy-express-backend/internal/validator"
@@ -17,6 +18,7 @@ type Order struct {
 	StatusID    int64     `json:"status_id"`
 	CreatedAt   time.Time `json:"created_at"`
 	DeliveredAt time.Time `json:"delivered_at"`
+	Version     int       `json:"version"`
 }
 
 type OrderDB struct {
@@ -32,25 +34,28 @@ type OrderDB struct {
 	DeliveredAt       time.Time `json:"delivered_at"`
 	StatusName        string    `json:"status_name"`
 	StatusDescription string    `json:"status_description"`
+	Version           int       `json:"version"`
 }
 
+// ErrOrderNotEditable is returned for changing the items of an order that
+// has been shipped, delivered or cancelled.
+var ErrOrderNotEditable = errors.New("order not editable")
+
 type OrderModel struct {
 	DB *sql.DB
 }
 
 func ValidateOrder(v *validator.Validator, order *Order) {
-	//v.Check(order.Name != "", "name", "must be provided")
-	//v.Check(len(order.Name) <= 20, "name", "must not be more than 20 bytes long")
-	//v.Check(order.Price >= 0, "price", "can not be negative")
-	//v.Check(order.Description != "", "description", "must be provided")
-	//v.Check(order.Amount >= 0, "quantity", "can not be negative")
+	v.Check(order.Address != "", "address", "must be provided")
+	v.Check(len(order.Address) <= 255, "address", "must not be more than 255 bytes long")
+	v.Check(order.Total >= 0, "total", "can not be negative")
 }
 
 func (o OrderModel) Insert(order *Order) error {
 	query := `
 	INSERT INTO orders (user_id, total, address, status_id, delivered_at)
 	VALUES ($1, $2, $3, $4, $5)
-	RETURNING id, created_at`
+	RETURNING id, created_at, version`
 
 	args := []any{
 		order.UserID,
@@ -64,7 +69,7 @@ func (o OrderModel) Insert(order *Order) error {
 	defer cancel()
 
 	err := o.DB.QueryRowContext(ctx, query, args...).Scan(&order.ID,
-		&order.CreatedAt)
+		&order.CreatedAt, &order.Version)
 
 	if err != nil {
 		return err
@@ -73,72 +78,291 @@ func (o OrderModel) Insert(order *Order) error {
 	return nil
 }
 
-func (o OrderModel) GetAll() ([]*OrderDB, error) {
+// Place creates the order together with its items in a single transaction.
+// The stock of every variant is locked, checked and decremented, so either the
+// whole order is placed or nothing is changed at all. ErrRecordNotFound means
+// that a variant or its product has been archived in the meantime. The order
+// starts out in the Ordered status. A non-zero cartID names the cart the
+// order is checked out from, which is emptied in the same transaction.
+func (o OrderModel) Place(order *Order, items []*OrderItem, cartID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	changes := make([]stockChange, len(items))
+	for i, item := range items {
+		changes[i] = stockChange{VariantID: item.VariantID, Amount: item.Quantity, Delta: item.Quantity}
+	}
+
+	err = reserveStock(ctx, tx, changes, true)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT id FROM statuses WHERE code = $1`, StatusOrdered).Scan(&order.StatusID)
+	if err != nil {
+		return err
+	}
+
 	query := `
-		SELECT 
-			count(*) OVER(), 
-			o.id, 
-			o.user_id, 
-			u.firstname,
-			u.lastname,
-			u.email,
-			o.total, 
-			o.address, 
-			o.status_id, 
-			o.created_at, 
-			o.delivered_at,
-			s.name AS status_name,
-			s.description AS status_description
-		FROM orders o
-		INNER JOIN users u ON o.user_id = u.id
-		INNER JOIN statuses s ON o.status_id = s.id
-	`
+	INSERT INTO orders (user_id, total, address, status_id, delivered_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, version`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []any{
+		order.UserID,
+		order.Total,
+		order.Address,
+		order.StatusID,
+		order.DeliveredAt,
+	}
 
-	rows, err := o.DB.QueryContext(ctx, query)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&order.ID, &order.CreatedAt, &order.Version)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	totalRecords := 0
-	orders := []*OrderDB{}
+	err = insertStatusChange(ctx, tx, &OrderStatusChange{
+		OrderID:    order.ID,
+		ToStatusID: order.StatusID,
+		ChangedBy:  order.UserID,
+	})
+	if err != nil {
+		return err
+	}
 
-	for rows.Next() {
-		var order OrderDB
-		err := rows.Scan(
-			&totalRecords,
-			&order.ID,
-			&order.UserID,
-			&order.FirstName,
-			&order.LastName,
-			&order.Email,
-			&order.Total,
-			&order.Address,
-			&order.StatusID,
-			&order.CreatedAt,
-			&order.DeliveredAt,
-			&order.StatusName,
-			&order.StatusDescription,
-		)
+	query = `
+	INSERT INTO order_items (order_id, product_id, variant_id, quantity, price, discount_percent, total)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id`
+
+	for _, item := range items {
+		item.OrderID = order.ID
+
+		args := []any{
+			item.OrderID,
+			item.ProductID,
+			item.VariantID,
+			item.Quantity,
+			item.Price,
+			item.DiscountPercent,
+			item.Total,
+		}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&item.ID)
 		if err != nil {
-			return nil, err
+			return err
+		}
+	}
+
+	if cartID != 0 {
+		err = clearCart(ctx, tx, cartID)
+		if err != nil {
+			return err
 		}
-		orders = append(orders, &order)
 	}
 
+	return tx.Commit()
+}
+
+// changeStatus moves the order to another status within the transaction if
+// the transition graph allows it and records the change in the order history.
+// Delivering an order stamps delivered_at, cancelling it returns the items to
+// stock.
+func changeStatus(ctx context.Context, tx *sql.Tx, order *Order, change *OrderStatusChange) error {
+	var from string
+	err := tx.QueryRowContext(ctx, `
+		SELECT orders.status_id, COALESCE(statuses.code, '')
+		FROM orders
+		INNER JOIN statuses ON statuses.id = orders.status_id
+		WHERE orders.id = $1
+		FOR UPDATE OF orders`, order.ID).Scan(&change.FromStatusID, &from)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	var to string
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(code, '') FROM statuses WHERE id = $1`, change.ToStatusID).Scan(&to)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if !CanTransition(from, to) {
+		return ErrInvalidTransition
+	}
+
+	if to == StatusCancelled {
+		err = releaseOrderStock(ctx, tx, order.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE orders
+		SET status_id = $1, delivered_at = CASE WHEN $2 THEN NOW() ELSE delivered_at END, version = version + 1
+		WHERE id = $3
+		RETURNING status_id, delivered_at, version`
+
+	err = tx.QueryRowContext(ctx, query, change.ToStatusID, to == StatusDelivered, order.ID).Scan(&order.StatusID, &order.DeliveredAt, &order.Version)
+	if err != nil {
+		return err
+	}
+
+	change.OrderID = order.ID
+	return insertStatusChange(ctx, tx, change)
+}
+
+// releaseOrderStock returns the amounts of every item of the order to stock.
+func releaseOrderStock(ctx context.Context, tx *sql.Tx, orderID int64) error {
+	rows, err := tx.QueryContext(ctx, `SELECT variant_id, quantity FROM order_items WHERE order_id = $1 AND variant_id IS NOT NULL`, orderID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var changes []stockChange
+	for rows.Next() {
+		var change stockChange
+		err := rows.Scan(&change.VariantID, &change.Delta)
+		if err != nil {
+			return err
+		}
+		change.Delta = -change.Delta
+		changes = append(changes, change)
+	}
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return err
+	}
+
+	if len(changes) == 0 {
+		return nil
 	}
-	return orders, nil
+	return reserveStock(ctx, tx, changes, false)
 }
 
-func (o OrderModel) GetAllForUser(id int) ([]*OrderDB, error) {
+// UpdateItemQuantity changes the amount of an order item in a single
+// transaction. The difference is taken from (or returned to) the product
+// stock, a zero quantity removes the item, and the order total is recalculated
+// from its remaining items. Only the items of orders that are still ordered
+// or processing can be changed; others return ErrOrderNotEditable.
+func (o OrderModel) UpdateItemQuantity(item *OrderItem, quantity float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
+		SELECT order_id, product_id, COALESCE(variant_id, 0), quantity, price, discount_percent, total
+		FROM order_items
+		WHERE id = $1
+		FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, item.ID).Scan(
+		&item.OrderID,
+		&item.ProductID,
+		&item.VariantID,
+		&item.Quantity,
+		&item.Price,
+		&item.DiscountPercent,
+		&item.Total,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	var status string
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(statuses.code, '')
+		FROM orders
+		INNER JOIN statuses ON statuses.id = orders.status_id
+		WHERE orders.id = $1
+		FOR UPDATE OF orders`, item.OrderID).Scan(&status)
+	if err != nil {
+		return err
+	}
+
+	if status != StatusOrdered && status != StatusProcessing {
+		return ErrOrderNotEditable
+	}
+
+	change := stockChange{VariantID: item.VariantID, Amount: quantity, Delta: quantity - item.Quantity}
+	err = reserveStock(ctx, tx, []stockChange{change}, false)
+	if err != nil {
+		return err
+	}
+
+	if quantity == 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM order_items WHERE id = $1`, item.ID)
+	} else {
+		item.Quantity = quantity
+		item.Total = LineTotal(item.UnitPrice(), quantity)
+		_, err = tx.ExecContext(ctx, `UPDATE order_items SET quantity = $1, total = $2 WHERE id = $3`, item.Quantity, item.Total, item.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE orders
+		SET total = (SELECT COALESCE(SUM(total), 0) FROM order_items WHERE order_id = $1), version = version + 1
+		WHERE id = $1`
+
+	_, err = tx.ExecContext(ctx, query, item.OrderID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// orderSortColumns are the columns an order listing can be sorted by.
+var orderSortColumns = map[string]string{
+	"id":         "o.id",
+	"total":      "COALESCE(o.total, 0)",
+	"created_at": "o.created_at",
+}
+
+// GetAll returns a page of all orders.
+func (o OrderModel) GetAll(filters Filters) ([]*OrderDB, Metadata, error) {
+	return o.list("TRUE", sqlArgs{}, filters)
+}
+
+// GetAllForUser returns a page of the orders placed by the user.
+func (o OrderModel) GetAllForUser(id int, filters Filters) ([]*OrderDB, Metadata, error) {
+	args := sqlArgs{}
+	condition := "o.user_id = " + args.add(id)
+	return o.list(condition, args, filters)
+}
+
+func (o OrderModel) list(condition string, args sqlArgs, filters Filters) ([]*OrderDB, Metadata, error) {
+	filters.SortColumns = orderSortColumns
+
+	page, orderBy, limit := filters.pageSQL("o.id", &args)
+
+	query := fmt.Sprintf(`
 		SELECT 
-			count(*) OVER(), 
+			%s,
+			%s,
 			o.id, 
 			o.user_id, 
 			u.firstname,
@@ -150,29 +374,34 @@ func (o OrderModel) GetAllForUser(id int) ([]*OrderDB, error) {
 			o.created_at, 
 			o.delivered_at,
 			s.name AS status_name,
-			s.description AS status_description
+			s.description AS status_description,
+			o.version
 		FROM orders o
 		INNER JOIN users u ON o.user_id = u.id
 		INNER JOIN statuses s ON o.status_id = s.id
-		WHERE o.user_id = $1
-	`
+		WHERE %s AND %s
+		ORDER BY %s
+		%s`, filters.countSQL(), filters.sortKeySQL(), condition, page, orderBy, limit)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := o.DB.QueryContext(ctx, query, id)
+	rows, err := o.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
 	totalRecords := 0
 	orders := []*OrderDB{}
+	var keys []pageKey
 
 	for rows.Next() {
 		var order OrderDB
+		var key pageKey
 		err := rows.Scan(
 			&totalRecords,
+			&key.Key,
 			&order.ID,
 			&order.UserID,
 			&order.FirstName,
@@ -185,25 +414,30 @@ func (o OrderModel) GetAllForUser(id int) ([]*OrderDB, error) {
 			&order.DeliveredAt,
 			&order.StatusName,
 			&order.StatusDescription,
+			&order.Version,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
+		key.ID = order.ID
 		orders = append(orders, &order)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
-	return orders, nil
+	orders, metadata := paginate(filters, totalRecords, orders, keys)
+	return orders, metadata, nil
 }
+
 func (o OrderModel) Get(id int64) (*Order, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 	// Define the SQL query for retrieving the movie data.
 	query := `
-		SELECT id, user_id, total, address, status_id, created_at, delivered_at 
+		SELECT id, user_id, total, address, status_id, created_at, delivered_at, version
 		FROM orders
 		WHERE id = $1`
 	// Declare a Movie struct to hold the data returned by the query.
@@ -216,6 +450,7 @@ func (o OrderModel) Get(id int64) (*Order, error) {
 		&order.StatusID,
 		&order.CreatedAt,
 		&order.DeliveredAt,
+		&order.Version,
 	)
 	if err != nil {
 		switch {
@@ -242,7 +477,8 @@ func (o OrderModel) GetDB(id int64) (*OrderDB, error) {
 			o.created_at, 
 			o.delivered_at,
 			s.name AS status_name,
-			s.description AS status_description
+			s.description AS status_description,
+			o.version
 		FROM orders o
 		INNER JOIN statuses s ON o.status_id = s.id
 		INNER JOIN users u ON o.user_id = u.id
@@ -263,6 +499,7 @@ func (o OrderModel) GetDB(id int64) (*OrderDB, error) {
 		&order.DeliveredAt,
 		&order.StatusName,
 		&order.StatusDescription,
+		&order.Version,
 	)
 	if err != nil {
 		switch {
@@ -275,22 +512,51 @@ func (o OrderModel) GetDB(id int64) (*OrderDB, error) {
 	return &order, nil
 }
 
-func (o OrderModel) Update(order *Order) error {
+// Update saves the editable fields of the order and, if change is not nil,
+// moves it to change.ToStatusID, in a single transaction. Every status change
+// is checked against the transition graph. The order must still be at the
+// version it was read at, or ErrEditConflict is returned.
+func (o OrderModel) Update(order *Order, change *OrderStatusChange) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `UPDATE orders
-	SET user_id = $1, total = $2, address = $3, status_id = $4, delivered_at = $5
-	WHERE id = $6
-	RETURNING id`
+	SET user_id = $1, total = $2, address = $3, version = version + 1
+	WHERE id = $4 AND version = $5
+	RETURNING version`
 
 	args := []any{
 		order.UserID,
 		order.Total,
 		order.Address,
-		order.StatusID,
-		order.DeliveredAt,
 		order.ID,
+		order.Version,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&order.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if change != nil {
+		err = changeStatus(ctx, tx, order, change)
+		if err != nil {
+			return err
+		}
 	}
 
-	return o.DB.QueryRow(query, args...).Scan(&order.ID)
+	return tx.Commit()
 }
 
 func (o OrderModel) Delete(id int64) error {
@@ -5,40 +5,42 @@ import (
 	"database/sql"
 	"errors"
 	"time"
-
-	"github.com/dexciuq/yummy-express-backend/internal/validator"
 )
 
 type OrderItem struct {
-	ID        int64   `json:"id"`
-	OrderID   int64   `json:"order_id"`
-	ProductID int64   `json:"product_id"`
-	Quantity  float64 `json:"quantity"`
-	Total     int64   `json:"total"`
+	ID              int64   `json:"id"`
+	OrderID         int64   `json:"order_id"`
+	ProductID       int64   `json:"product_id"`
+	VariantID       int64   `json:"variant_id"`
+	Quantity        float64 `json:"quantity"`
+	Price           int64   `json:"price"`
+	DiscountPercent int     `json:"discount_percent"`
+	Total           int64   `json:"total"`
 }
 
 type OrderItemModel struct {
 	DB *sql.DB
 }
 
-func ValidateOrderItem(v *validator.Validator, order *OrderItem) {
-	//v.Check(order.Name != "", "name", "must be provided")
-	//v.Check(len(order.Name) <= 20, "name", "must not be more than 20 bytes long")
-	//v.Check(order.Price >= 0, "price", "can not be negative")
-	//v.Check(order.Description != "", "description", "must be provided")
-	//v.Check(order.Amount >= 0, "quantity", "can not be negative")
+// UnitPrice returns the price of a single unit of the item with the discount
+// that was applied when the order was placed.
+func (i *OrderItem) UnitPrice() int64 {
+	return DiscountedPrice(i.Price, i.DiscountPercent)
 }
 
 func (o OrderItemModel) Insert(item *OrderItem) error {
 	query := `
-	INSERT INTO order_items (order_id, product_id, quantity, total)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO order_items (order_id, product_id, variant_id, quantity, price, discount_percent, total)
+	VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, $7)
 	RETURNING id`
 
 	args := []any{
 		item.OrderID,
 		item.ProductID,
+		item.VariantID,
 		item.Quantity,
+		item.Price,
+		item.DiscountPercent,
 		item.Total,
 	}
 
@@ -57,7 +59,7 @@ func (o OrderItemModel) Insert(item *OrderItem) error {
 func (o OrderItemModel) GetAll() ([]*OrderItem, error) {
 	// Update the SQL query to include the window function which counts the total
 	// (filtered) records.
-	query := `SELECT count(*) OVER(), id, order_id, product_id, quantity, total FROM order_items`
+	query := `SELECT count(*) OVER(), id, order_id, product_id, COALESCE(variant_id, 0), quantity, price, discount_percent, total FROM order_items`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
@@ -81,7 +83,10 @@ func (o OrderItemModel) GetAll() ([]*OrderItem, error) {
 			&item.ID,
 			&item.OrderID,
 			&item.ProductID,
+			&item.VariantID,
 			&item.Quantity,
+			&item.Price,
+			&item.DiscountPercent,
 			&item.Total,
 		)
 		if err != nil {
@@ -99,7 +104,7 @@ func (o OrderItemModel) GetAll() ([]*OrderItem, error) {
 func (o OrderItemModel) GetAllByOrder(order_id int64) ([]*OrderItem, error) {
 	// Update the SQL query to include the window function which counts the total
 	// (filtered) records.
-	query := `SELECT count(*) OVER(), id, order_id, product_id, quantity, total FROM order_items where order_id=$1`
+	query := `SELECT count(*) OVER(), id, order_id, product_id, COALESCE(variant_id, 0), quantity, price, discount_percent, total FROM order_items where order_id=$1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
@@ -123,7 +128,10 @@ func (o OrderItemModel) GetAllByOrder(order_id int64) ([]*OrderItem, error) {
 			&item.ID,
 			&item.OrderID,
 			&item.ProductID,
+			&item.VariantID,
 			&item.Quantity,
+			&item.Price,
+			&item.DiscountPercent,
 			&item.Total,
 		)
 		if err != nil {
@@ -144,7 +152,7 @@ func (o OrderItemModel) Get(id int64) (*OrderItem, error) {
 	}
 	// Define the SQL query for retrieving the movie data.
 	query := `
-		SELECT id, order_id, product_id, quantity, total 
+		SELECT id, order_id, product_id, COALESCE(variant_id, 0), quantity, price, discount_percent, total
 		FROM order_items
 		WHERE id = $1`
 	// Declare a Movie struct to hold the data returned by the query.
@@ -153,7 +161,10 @@ func (o OrderItemModel) Get(id int64) (*OrderItem, error) {
 		&item.ID,
 		&item.OrderID,
 		&item.ProductID,
+		&item.VariantID,
 		&item.Quantity,
+		&item.Price,
+		&item.DiscountPercent,
 		&item.Total,
 	)
 	if err != nil {
@@ -169,14 +180,17 @@ func (o OrderItemModel) Get(id int64) (*OrderItem, error) {
 
 func (o OrderItemModel) Update(item *OrderItem) error {
 	query := `UPDATE order_items
-	SET order_id = $1, product_id = $2, quantity = $3, total = $4
-	WHERE id = $5
+	SET order_id = $1, product_id = $2, variant_id = NULLIF($3, 0), quantity = $4, price = $5, discount_percent = $6, total = $7
+	WHERE id = $8
 	RETURNING id`
 
 	args := []any{
 		item.OrderID,
 		item.ProductID,
+		item.VariantID,
 		item.Quantity,
+		item.Price,
+		item.DiscountPercent,
 		item.Total,
 		item.ID,
 	}
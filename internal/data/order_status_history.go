@@ -0,0 +1,92 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type OrderStatusChange struct {
+	ID           int64     `json:"id"`
+	OrderID      int64     `json:"order_id"`
+	FromStatusID int64     `json:"from_status_id,omitempty"`
+	FromStatus   string    `json:"from_status,omitempty"`
+	ToStatusID   int64     `json:"to_status_id"`
+	ToStatus     string    `json:"to_status"`
+	ChangedBy    int64     `json:"changed_by,omitempty"`
+	Note         string    `json:"note"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type OrderStatusHistoryModel struct {
+	DB *sql.DB
+}
+
+// insertStatusChange records a status change as part of the given transaction.
+func insertStatusChange(ctx context.Context, tx *sql.Tx, change *OrderStatusChange) error {
+	query := `
+	INSERT INTO order_status_history (order_id, from_status_id, to_status_id, changed_by, note)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	args := []any{
+		change.OrderID,
+		sql.NullInt64{Int64: change.FromStatusID, Valid: change.FromStatusID != 0},
+		change.ToStatusID,
+		sql.NullInt64{Int64: change.ChangedBy, Valid: change.ChangedBy != 0},
+		change.Note,
+	}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&change.ID, &change.CreatedAt)
+}
+
+func (h OrderStatusHistoryModel) GetAllForOrder(orderID int64) ([]*OrderStatusChange, error) {
+	query := `
+		SELECT h.id, h.order_id, h.from_status_id, fs.name, h.to_status_id, ts.name, h.changed_by, h.note, h.created_at
+		FROM order_status_history h
+		LEFT JOIN statuses fs ON h.from_status_id = fs.id
+		INNER JOIN statuses ts ON h.to_status_id = ts.id
+		WHERE h.order_id = $1
+		ORDER BY h.created_at, h.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := h.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*OrderStatusChange{}
+
+	for rows.Next() {
+		var change OrderStatusChange
+		var fromStatusID, changedBy sql.NullInt64
+		var fromStatus sql.NullString
+
+		err := rows.Scan(
+			&change.ID,
+			&change.OrderID,
+			&fromStatusID,
+			&fromStatus,
+			&change.ToStatusID,
+			&change.ToStatus,
+			&changedBy,
+			&change.Note,
+			&change.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		change.FromStatusID = fromStatusID.Int64
+		change.FromStatus = fromStatus.String
+		change.ChangedBy = changedBy.Int64
+		history = append(history, &change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
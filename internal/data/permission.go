@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+)
+
+const (
+	PermissionProductsWrite  = "products:write"
+	PermissionCatalogWrite   = "catalog:write"
+	PermissionDiscountsWrite = "discounts:write"
+	PermissionRolesManage    = "roles:manage"
+	PermissionStatusesManage = "statuses:manage"
+	PermissionOrdersManage   = "orders:manage"
+	PermissionUsersAdmin     = "users:admin"
+)
+
+type Permission struct {
+	ID          int64  `json:"id"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// Permissions holds the permission codes granted to a role.
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+// ValidatePermissionCodes checks that every code is known and listed once.
+func ValidatePermissionCodes(v *validator.Validator, codes []string, known []*Permission) {
+	knownCodes := make([]string, len(known))
+	for i, permission := range known {
+		knownCodes[i] = permission.Code
+	}
+
+	v.Check(validator.Unique(codes), "permissions", "must not contain duplicate values")
+	for _, code := range codes {
+		v.Check(validator.In(code, knownCodes...), "permissions", "contains an unknown permission "+code)
+	}
+}
+
+func (p PermissionModel) GetAll() ([]*Permission, error) {
+	query := `SELECT id, code, description FROM permissions ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []*Permission{}
+
+	for rows.Next() {
+		var permission Permission
+		err := rows.Scan(&permission.ID, &permission.Code, &permission.Description)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, &permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (p PermissionModel) GetAllForRole(roleID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN role_permissions ON role_permissions.permission_id = permissions.id
+		WHERE role_permissions.role_id = $1
+		ORDER BY permissions.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var code string
+		err := rows.Scan(&code)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, code)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// SetForRole replaces the permissions of the role with the given codes.
+func (p PermissionModel) SetForRole(roleID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_id = $1`, roleID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO role_permissions (role_id, permission_id)
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, roleID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Init makes sure every permission known to the application exists and that
+// the ADMIN role is granted all of them.
+func (p PermissionModel) Init() error {
+	permissions := []*Permission{
+		{Code: PermissionProductsWrite, Description: "Create, update and delete products"},
+		{Code: PermissionCatalogWrite, Description: "Manage categories, brands, units and countries"},
+		{Code: PermissionDiscountsWrite, Description: "Manage discounts"},
+		{Code: PermissionRolesManage, Description: "Manage roles and their permissions"},
+		{Code: PermissionStatusesManage, Description: "Manage order statuses"},
+		{Code: PermissionOrdersManage, Description: "View and manage the orders of all users"},
+		{Code: PermissionUsersAdmin, Description: "View and manage all user accounts"},
+	}
+
+	for _, permission := range permissions {
+		_, err := p.DB.Exec(`INSERT INTO permissions (code, description) VALUES ($1, $2) ON CONFLICT (code) DO NOTHING`,
+			permission.Code, permission.Description)
+		if err != nil {
+			return err
+		}
+	}
+
+	roleModel := RoleModel{DB: p.DB}
+	adminRoleID := roleModel.GetAdminRoleID()
+	if adminRoleID == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO role_permissions (role_id, permission_id)
+		SELECT $1, id FROM permissions
+		ON CONFLICT DO NOTHING`
+
+	_, err := p.DB.Exec(query, adminRoleID)
+	return err
+}
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"math"
+	"time"
+)
+
+// PricedItem is the authoritative price breakdown of a single order line,
+// calculated on the server from the variant's list price and its discount.
+type PricedItem struct {
+	ProductID       int64   `json:"product_id"`
+	VariantID       int64   `json:"variant_id"`
+	Name            string  `json:"name"`
+	VariantName     string  `json:"variant_name,omitempty"`
+	Amount          float64 `json:"amount"`
+	Price           int64   `json:"price"`
+	DiscountPercent int     `json:"discount_percent"`
+	UnitPrice       int64   `json:"unit_price"`
+	Subtotal        int64   `json:"subtotal"`
+}
+
+// DiscountedPrice returns the price after taking off the given percent. The
+// discount itself is rounded down, so the customer never pays a fraction of
+// a tiyn less than the list price allows.
+func DiscountedPrice(price int64, percent int) int64 {
+	if percent <= 0 {
+		return price
+	}
+	if percent >= 100 {
+		return 0
+	}
+	return price - price*int64(percent)/100
+}
+
+// LineTotal returns the total for the given unit price and amount, rounded
+// down to a whole tiyn.
+func LineTotal(unitPrice int64, amount float64) int64 {
+	return int64(math.Floor(float64(unitPrice) * amount))
+}
+
+// PriceItem calculates the price breakdown of the product variant for the
+// given amount. The discount is applied only if it is active at the moment
+// now.
+func PriceItem(product *Product, variant *ProductVariant, discount *Discount, amount float64, now time.Time) *PricedItem {
+	percent := 0
+	if discount != nil && discount.IsActive(now) {
+		percent = discount.DiscountPercent
+	}
+
+	unitPrice := DiscountedPrice(variant.Price, percent)
+
+	return &PricedItem{
+		ProductID:       product.ID,
+		VariantID:       variant.ID,
+		Name:            product.Name,
+		VariantName:     variant.Name,
+		Amount:          amount,
+		Price:           variant.Price,
+		DiscountPercent: percent,
+		UnitPrice:       unitPrice,
+		Subtotal:        LineTotal(unitPrice, amount),
+	}
+}
@@ -10,55 +10,66 @@ import (
 	"time"
 )
 
+// Product is the parent of one or more variants, which carry the UPC, price
+// and stock. DiscountID applies to every variant without a discount of its
+// own.
 type Product struct {
-	ID          int64     `json:"id"`
-	Name        string    `json:"name"`
-	Price       int64     `json:"price"`
-	Description string    `json:"description"`
-	CategoryID  int64     `json:"category_id"`
-	UPC         string    `json:"upc"`
-	DiscountID  int64     `json:"discount_id"`
-	Quantity    int64     `json:"quantity"`
-	UnitID      int64     `json:"unit_id"`
-	Image       string    `json:"image"`
-	BrandID     int64     `json:"brand_id"`
-	CountryID   int64     `json:"country_id"`
-	Step        float64   `json:"step"`
-	CreatedAt   time.Time `json:"created_at"`
-	Version     int       `json:"-"`
+	ID          int64             `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	CategoryID  int64             `json:"category_id"`
+	DiscountID  int64             `json:"discount_id"`
+	Image       string            `json:"image"`
+	BrandID     int64             `json:"brand_id"`
+	CountryID   int64             `json:"country_id"`
+	CreatedAt   time.Time         `json:"created_at"`
+	Version     int               `json:"version"`
+	DeletedAt   *time.Time        `json:"deleted_at,omitempty"`
+	Breadcrumbs Breadcrumbs       `json:"breadcrumbs,omitempty"`
+	Variants    []*ProductVariant `json:"variants,omitempty"`
 }
 
+// productDB is a product with its references resolved. PriceMin and
+// PriceMax are the range of its variants' list prices and Quantity is their
+// total stock. Breadcrumbs lead from the root category down to its category.
+// Allergens are null until they have been declared.
 type productDB struct {
-	ID                  int64     `json:"id"`
-	Name                string    `json:"name"`
-	Price               int64     `json:"price"`
-	Description         string    `json:"description"`
-	UPC                 string    `json:"upc"`
-	Quantity            int64     `json:"quantity"`
-	Image               string    `json:"image"`
-	Step                float64   `json:"step"`
-	CategoryID          int64     `json:"category_id"`
-	CategoryName        string    `json:"category_name"`
-	CategoryDescription string    `json:"category_description"`
-	CategoryImage       string    `json:"category_image"`
-	DiscountID          int64     `json:"discount_id"`
-	DiscountName        string    `json:"discount_name"`
-	DiscountDescription string    `json:"discount_description"`
-	DiscountPercent     int       `json:"discount_percent"`
-	DiscountCreatedAt   time.Time `json:"discount_created_at"`
-	DiscountStartedAt   time.Time `json:"discount_started_at"`
-	DiscountEndedAt     time.Time `json:"discount_ended_at"`
-	UnitID              int64     `json:"unit_id"`
-	UnitName            string    `json:"unit_name"`
-	UnitDescription     string    `json:"unit_description"`
-	BrandID             int64     `json:"brand_id"`
-	BrandName           string    `json:"brand_name"`
-	BrandDescription    string    `json:"brand_description"`
-	CountryID           int64     `json:"country_id"`
-	CountryName         string    `json:"country_name"`
-	CountryDescription  string    `json:"country_description"`
-	Alpha2              string    `json:"alpha2"`
-	Alpha3              string    `json:"alpha3"`
+	ID                  int64       `json:"id"`
+	Name                string      `json:"name"`
+	PriceMin            int64       `json:"price_min"`
+	PriceMax            int64       `json:"price_max"`
+	Description         string      `json:"description"`
+	Quantity            float64     `json:"quantity"`
+	Image               string      `json:"image"`
+	CategoryID          int64       `json:"category_id"`
+	CategoryName        string      `json:"category_name"`
+	CategoryDescription string      `json:"category_description"`
+	CategoryImage       string      `json:"category_image"`
+	DiscountID          int64       `json:"discount_id"`
+	DiscountName        string      `json:"discount_name"`
+	DiscountDescription string      `json:"discount_description"`
+	DiscountPercent     int         `json:"discount_percent"`
+	DiscountCreatedAt   time.Time   `json:"discount_created_at"`
+	DiscountStartedAt   time.Time   `json:"discount_started_at"`
+	DiscountEndedAt     time.Time   `json:"discount_ended_at"`
+	BrandID             int64       `json:"brand_id"`
+	BrandName           string      `json:"brand_name"`
+	BrandDescription    string      `json:"brand_description"`
+	CountryID           int64       `json:"country_id"`
+	CountryName         string      `json:"country_name"`
+	CountryDescription  string      `json:"country_description"`
+	Alpha2              string      `json:"alpha2"`
+	Alpha3              string      `json:"alpha3"`
+	Version             int         `json:"version"`
+	DeletedAt           *time.Time  `json:"deleted_at,omitempty"`
+	Breadcrumbs         Breadcrumbs `json:"breadcrumbs"`
+	Allergens           []string    `json:"allergens"`
+	// Variants, Images, Attributes and Nutrition are only loaded for a
+	// single product.
+	Variants   []*ProductVariant   `json:"variants,omitempty"`
+	Images     []*ProductImage     `json:"images,omitempty"`
+	Attributes []*ProductAttribute `json:"attributes,omitempty"`
+	Nutrition  *Nutrition          `json:"nutrition,omitempty"`
 }
 
 type ProductModel struct {
@@ -68,167 +79,83 @@ type ProductModel struct {
 func ValidateProduct(v *validator.Validator, product *Product) {
 	v.Check(product.Name != "", "name", "must be provided")
 	v.Check(len(product.Name) <= 100, "name", "must not be more than 100 bytes long")
-	v.Check(product.Price >= 0, "price", "can not be negative")
 	v.Check(product.Description != "", "description", "must be provided")
-	v.Check(product.Quantity >= 0, "quantity", "can not be negative")
-	v.Check(product.Step >= 0, "step", "can not be negative")
-}
 
-func (p ProductModel) Insert(product *Product) error {
-	query := `
-	INSERT INTO products (name, price, description, category_id, upc, discount_id, quantity, unit_id, image, brand_id, country_id, step)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-	RETURNING id, created_at`
-
-	args := []any{
-		product.Name,
-		product.Price,
-		product.Description,
-		product.CategoryID,
-		product.UPC,
-		product.DiscountID,
-		product.Quantity,
-		product.UnitID,
-		product.Image,
-		product.BrandID,
-		product.CountryID,
-		product.Step,
+	for i, variant := range product.Variants {
+		validateVariant(v, fmt.Sprintf("variants[%d].", i), variant)
 	}
+}
 
+// Insert adds the product together with its variants in one transaction.
+func (p ProductModel) Insert(product *Product) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := p.DB.QueryRowContext(ctx, query, args...).Scan(&product.ID,
-		&product.CreatedAt)
-
+	tx, err := p.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
-}
-
-func (p ProductModel) GetAll(category int, brand []int, country int, name string, filters Filters) ([]*productDB, Metadata, error) {
-	// Update the SQL query to include the window function which counts the total
-	// (filtered) records.
-	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), 
-			products.id, products.name, products.price, products.description, products.upc, products.quantity, products.image, products.step,
-			categories.id, categories.name, categories.description, categories.image, 
-			discounts.id, discounts.name, discounts.description, discounts.discount_percent, discounts.created_at, discounts.started_at, discounts.ended_at,
-			units.id, units.name, units.description,
-			brands.id, brands.name, brands.description,
-			countries.id, countries.name, countries.description, countries.alpha2, countries.alpha3
-		FROM products
-	    LEFT JOIN categories ON products.category_id = categories.id
-		LEFT JOIN discounts ON products.discount_id = discounts.id
-		LEFT JOIN units ON products.unit_id = units.id
-		LEFT JOIN brands ON products.brand_id = brands.id
-		LEFT JOIN countries ON products.country_id = countries.id
-		WHERE LOWER(products.name) LIKE LOWER($1)/*(to_tsvector('simple', products.name) @@ plainto_tsquery('simple', $1) OR $1 = '')*/
-		AND (products.category_id = $2 OR $2 = 0)
-  		AND (brand_id = ANY($3) OR COALESCE(array_length($3, 1), 0) = 0)
-		AND (products.country_id = $4 OR $4 = 0)
-		ORDER BY %s %s, products.id ASC
-		LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-
-	defer cancel()
-
-	args := []any{"%" + name + "%", category, pq.Array(brand), country, filters.limit(), filters.offset()}
-
-	rows, err := p.DB.QueryContext(ctx, query, args...)
+	err = insertProduct(ctx, tx, product)
 	if err != nil {
-		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
+		return err
 	}
 
-	defer rows.Close()
-
-	totalRecords := 0
-
-	var products []*productDB
-
-	for rows.Next() {
-		var product productDB
-		err := rows.Scan(
-			&totalRecords, // Scan the count from the window function into totalRecords.
-			&product.ID,
-			&product.Name,
-			&product.Price,
-			&product.Description,
-			&product.UPC,
-			&product.Quantity,
-			&product.Image,
-			&product.Step,
-			&product.CategoryID,
-			&product.CategoryName,
-			&product.CategoryDescription,
-			&product.CategoryImage,
-			&product.DiscountID,
-			&product.DiscountName,
-			&product.DiscountDescription,
-			&product.DiscountPercent,
-			&product.DiscountCreatedAt,
-			&product.DiscountStartedAt,
-			&product.DiscountEndedAt,
-			&product.UnitID,
-			&product.UnitName,
-			&product.UnitDescription,
-			&product.BrandID,
-			&product.BrandName,
-			&product.BrandDescription,
-			&product.CountryID,
-			&product.CountryName,
-			&product.CountryDescription,
-			&product.Alpha2,
-			&product.Alpha3,
-		)
+	for _, variant := range product.Variants {
+		variant.ProductID = product.ID
+		err = insertVariant(ctx, tx, variant)
 		if err != nil {
-			return nil, Metadata{}, err // Update this to return an empty Metadata struct.
+			return err
 		}
-		products = append(products, &product)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
+	return tx.Commit()
+}
+
+// insertProduct adds the product row only, leaving its variants to the
+// caller.
+func insertProduct(ctx context.Context, db queryRower, product *Product) error {
+	query := `
+	INSERT INTO products (name, description, category_id, discount_id, image, brand_id, country_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at, version`
+
+	args := []any{
+		product.Name,
+		product.Description,
+		product.CategoryID,
+		product.DiscountID,
+		product.Image,
+		product.BrandID,
+		product.CountryID,
 	}
-	// Generate a Metadata struct, passing in the total record count and pagination
-	// parameters from the client.
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-	// Include the metadata struct when returning.
-	return products, metadata, nil
+
+	return db.QueryRowContext(ctx, query, args...).Scan(&product.ID, &product.CreatedAt, &product.Version)
 }
 
-func (p ProductModel) GetAllWithDiscounts(category int, brands, discounts []int, country int, name string, filters Filters) ([]*productDB, Metadata, error) {
+// GetAll returns a page of the products matching the filter.
+func (p ProductModel) GetAll(filter ProductFilter, filters Filters) ([]*productDB, Metadata, error) {
+	args := sqlArgs{}
+	from := filter.from(&args)
+	where := filter.where("", &args)
+	filters.SortColumns = productSortColumns
+
+	page, orderBy, limit := filters.pageSQL("products.id", &args)
+
+	// Update the SQL query to include the window function which counts the total
+	// (filtered) records.
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), 
-			products.id, products.name, products.price, products.description, products.upc, products.quantity, products.image, products.step,
-			categories.id, categories.name, categories.description, categories.image, 
-			discounts.id, discounts.name, discounts.description, discounts.discount_percent, discounts.created_at, discounts.started_at, discounts.ended_at,
-			units.id, units.name, units.description,
-			brands.id, brands.name, brands.description,
-			countries.id, countries.name, countries.description, countries.alpha2, countries.alpha3
-		FROM products
-	    LEFT JOIN categories ON products.category_id = categories.id
-		LEFT JOIN discounts ON products.discount_id = discounts.id
-		LEFT JOIN units ON products.unit_id = units.id
-		LEFT JOIN brands ON products.brand_id = brands.id
-		LEFT JOIN countries ON products.country_id = countries.id
-		WHERE LOWER(products.name) LIKE LOWER($1)/*(to_tsvector('simple', products.name) @@ plainto_tsquery('simple', $1) OR $1 = '')*/
-		AND (products.category_id = $2 OR $2 = 0)
-  		AND (brand_id = ANY($3) OR COALESCE(array_length($3, 1), 0) = 0)
-  		AND (discount_id = ANY($4) OR COALESCE(array_length($4, 1), 0) = 0)
-		AND (products.country_id = $5 OR $5 = 0)
-		ORDER BY %s %s, products.id ASC
-		LIMIT $6 OFFSET $7`, filters.sortColumn(), filters.sortDirection())
+		SELECT %s, %s, %s
+		FROM %s
+		WHERE %s AND %s
+		ORDER BY %s
+		%s`, filters.countSQL(), filters.sortKeySQL(), productColumnsSQL, from, where, page, orderBy, limit)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
-	args := []any{"%" + name + "%", category, pq.Array(brands), pq.Array(discounts), country, filters.limit(), filters.offset()}
-
 	rows, err := p.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
@@ -239,19 +166,21 @@ func (p ProductModel) GetAllWithDiscounts(category int, brands, discounts []int,
 	totalRecords := 0
 
 	var products []*productDB
+	var keys []pageKey
 
 	for rows.Next() {
 		var product productDB
+		var key pageKey
 		err := rows.Scan(
 			&totalRecords, // Scan the count from the window function into totalRecords.
+			&key.Key,
 			&product.ID,
 			&product.Name,
-			&product.Price,
+			&product.PriceMin,
+			&product.PriceMax,
 			&product.Description,
-			&product.UPC,
 			&product.Quantity,
 			&product.Image,
-			&product.Step,
 			&product.CategoryID,
 			&product.CategoryName,
 			&product.CategoryDescription,
@@ -263,9 +192,6 @@ func (p ProductModel) GetAllWithDiscounts(category int, brands, discounts []int,
 			&product.DiscountCreatedAt,
 			&product.DiscountStartedAt,
 			&product.DiscountEndedAt,
-			&product.UnitID,
-			&product.UnitName,
-			&product.UnitDescription,
 			&product.BrandID,
 			&product.BrandName,
 			&product.BrandDescription,
@@ -274,11 +200,17 @@ func (p ProductModel) GetAllWithDiscounts(category int, brands, discounts []int,
 			&product.CountryDescription,
 			&product.Alpha2,
 			&product.Alpha3,
+			&product.Version,
+			&product.DeletedAt,
+			&product.Breadcrumbs,
+			pq.Array(&product.Allergens),
 		)
 		if err != nil {
 			return nil, Metadata{}, err // Update this to return an empty Metadata struct.
 		}
+		key.ID = product.ID
 		products = append(products, &product)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -286,7 +218,7 @@ func (p ProductModel) GetAllWithDiscounts(category int, brands, discounts []int,
 	}
 	// Generate a Metadata struct, passing in the total record count and pagination
 	// parameters from the client.
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	products, metadata := paginate(filters, totalRecords, products, keys)
 	// Include the metadata struct when returning.
 	return products, metadata, nil
 }
@@ -297,7 +229,7 @@ func (p ProductModel) Get(id int64) (*Product, error) {
 	}
 	// Define the SQL query for retrieving the movie data.
 	query := `
-		SELECT id, name, price, description, category_id, upc, discount_id, quantity, unit_id, image, brand_id, country_id, step
+		SELECT id, name, description, category_id, discount_id, image, brand_id, country_id, version, deleted_at
 		FROM products
 		WHERE id = $1`
 	// Declare a Movie struct to hold the data returned by the query.
@@ -305,17 +237,14 @@ func (p ProductModel) Get(id int64) (*Product, error) {
 	err := p.DB.QueryRow(query, id).Scan(
 		&product.ID,
 		&product.Name,
-		&product.Price,
 		&product.Description,
 		&product.CategoryID,
-		&product.UPC,
 		&product.DiscountID,
-		&product.Quantity,
-		&product.UnitID,
 		&product.Image,
 		&product.BrandID,
 		&product.CountryID,
-		&product.Step,
+		&product.Version,
+		&product.DeletedAt,
 	)
 	if err != nil {
 		switch {
@@ -328,36 +257,30 @@ func (p ProductModel) Get(id int64) (*Product, error) {
 	return &product, nil
 }
 
-func (p ProductModel) GetByUPC(upc string) (*Product, error) {
-	// Define the SQL query for retrieving the movie data.
+// lockProduct reads the product whose column equals value and locks it for
+// the rest of the transaction. It returns sql.ErrNoRows if there is none.
+func lockProduct(ctx context.Context, db queryRower, column string, value any) (*Product, error) {
 	query := `
-		SELECT id, name, price, description, category_id, upc, discount_id, quantity, unit_id, image, brand_id, country_id, step
+		SELECT id, name, description, category_id, discount_id, image, brand_id, country_id, version, deleted_at
 		FROM products
-		WHERE upc = $1`
-	// Declare a Movie struct to hold the data returned by the query.
+		WHERE ` + column + ` = $1
+		FOR UPDATE`
+
 	var product Product
-	err := p.DB.QueryRow(query, upc).Scan(
+	err := db.QueryRowContext(ctx, query, value).Scan(
 		&product.ID,
 		&product.Name,
-		&product.Price,
 		&product.Description,
 		&product.CategoryID,
-		&product.UPC,
 		&product.DiscountID,
-		&product.Quantity,
-		&product.UnitID,
 		&product.Image,
 		&product.BrandID,
 		&product.CountryID,
-		&product.Step,
+		&product.Version,
+		&product.DeletedAt,
 	)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
-		default:
-			return nil, err
-		}
+		return nil, err
 	}
 	return &product, nil
 }
@@ -367,31 +290,20 @@ func (p ProductModel) GetDB(id int64) (*productDB, error) {
 		return nil, ErrRecordNotFound
 	}
 	// Define the SQL query for retrieving the movie data.
-	query := `
-		SELECT products.id, products.name, products.price, products.description, products.upc, products.quantity, products.image, products.step,
-			categories.id, categories.name, categories.description, categories.image, 
-			discounts.id, discounts.name, discounts.description, discounts.discount_percent, discounts.created_at, discounts.started_at, discounts.ended_at,
-			units.id, units.name, units.description,
-			brands.id, brands.name, brands.description,
-			countries.id, countries.name, countries.description, countries.alpha2, countries.alpha3
-		FROM products
-	    LEFT JOIN categories ON products.category_id = categories.id
-		LEFT JOIN discounts ON products.discount_id = discounts.id
-		LEFT JOIN units ON products.unit_id = units.id
-		LEFT JOIN brands ON products.brand_id = brands.id
-		LEFT JOIN countries ON products.country_id = countries.id
-		WHERE products.id = $1`
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE products.id = $1`, productColumnsSQL, productFromSQL)
 	// Declare a Movie struct to hold the data returned by the query.
 	var product productDB
 	err := p.DB.QueryRow(query, id).Scan(
 		&product.ID,
 		&product.Name,
-		&product.Price,
+		&product.PriceMin,
+		&product.PriceMax,
 		&product.Description,
-		&product.UPC,
 		&product.Quantity,
 		&product.Image,
-		&product.Step,
 		&product.CategoryID,
 		&product.CategoryName,
 		&product.CategoryDescription,
@@ -403,9 +315,6 @@ func (p ProductModel) GetDB(id int64) (*productDB, error) {
 		&product.DiscountCreatedAt,
 		&product.DiscountStartedAt,
 		&product.DiscountEndedAt,
-		&product.UnitID,
-		&product.UnitName,
-		&product.UnitDescription,
 		&product.BrandID,
 		&product.BrandName,
 		&product.BrandDescription,
@@ -414,6 +323,10 @@ func (p ProductModel) GetDB(id int64) (*productDB, error) {
 		&product.CountryDescription,
 		&product.Alpha2,
 		&product.Alpha3,
+		&product.Version,
+		&product.DeletedAt,
+		&product.Breadcrumbs,
+		pq.Array(&product.Allergens),
 	)
 	if err != nil {
 		switch {
@@ -423,54 +336,75 @@ func (p ProductModel) GetDB(id int64) (*productDB, error) {
 			return nil, err
 		}
 	}
+
+	product.Variants, err = ProductVariantModel{DB: p.DB}.GetAllForProduct(product.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	product.Images, err = ProductImageModel{DB: p.DB}.GetAllForProduct(product.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	product.Attributes, err = AttributeModel{DB: p.DB}.GetAllForProduct(product.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	product.Nutrition, err = p.GetNutrition(product.ID)
+	if err != nil {
+		return nil, err
+	}
 	return &product, nil
 }
 
 func (p ProductModel) Update(product *Product) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return updateProduct(ctx, p.DB, product)
+}
+
+// updateProduct saves the product if it is still at the version it was read
+// at, and returns ErrEditConflict otherwise.
+func updateProduct(ctx context.Context, db queryRower, product *Product) error {
 	query := `UPDATE products
-	SET name = $1, price = $2, description = $3, category_id = $4, upc = $5, discount_id = $6, quantity = $7, unit_id = $8, image = $9, brand_id = $10, country_id = $11, step = $12
-	WHERE id = $13
-	RETURNING id`
+	SET name = $1, description = $2, category_id = $3, discount_id = $4, image = $5, brand_id = $6, country_id = $7, version = version + 1
+	WHERE id = $8 AND version = $9
+	RETURNING version`
 
 	args := []any{
 		product.Name,
-		product.Price,
 		product.Description,
 		product.CategoryID,
-		product.UPC,
 		product.DiscountID,
-		product.Quantity,
-		product.UnitID,
 		product.Image,
 		product.BrandID,
 		product.CountryID,
-		product.Step,
 		product.ID,
+		product.Version,
 	}
 
-	return p.DB.QueryRow(query, args...).Scan(&product.ID)
-}
-
-func (p ProductModel) Delete(id int64) error {
-	query := `
-		DELETE FROM products
-		WHERE id = $1`
-	result, err := p.DB.Exec(query, id)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&product.Version)
 	if err != nil {
-		return nil
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
 	}
+	return nil
+}
 
-	// Checking how many rows were affected
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
+// Delete archives the product. It disappears from the storefront, but past
+// orders keep resolving it.
+func (p ProductModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	// Check if the row was in the database before the query
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-	return nil
+	return archive(ctx, p.DB, "products", id)
 }
 
 func (p ProductModel) Init() error {
@@ -484,45 +418,39 @@ func (p ProductModel) Init() error {
 		products := []*Product{
 			{
 				Name:        "Fresh Peach",
-				Price:       65000,
 				Description: "Sweet and juicy peaches, perfect for a refreshing and healthy snack. Enjoy the natural goodness of ripe peaches, known for their vibrant flavor and nutritional benefits. Add them to your fruit salads, desserts, or enjoy them on their own for a delightful taste of summer.",
 				CategoryID:  2,
-				UPC:         "123123",
 				DiscountID:  1,
-				Quantity:    10,
-				UnitID:      1,
 				Image:       "https://pngfre.com/wp-content/uploads/peach-png-image-from-pngfre-33-1024x815.png", // url
 				BrandID:     1,
 				CountryID:   1,
-				Step:        1.0,
+				Variants: []*ProductVariant{
+					{UPC: "123123", Price: 65000, Quantity: 10, UnitID: 1, Step: 1.0},
+				},
 			},
 			{
 				Name:        "Lemon",
-				Price:       23000,
 				Description: "Bright and zesty lemons, known for their tangy flavor and versatility. Fresh lemons are a kitchen essential, perfect for adding a burst of citrusy goodness to both sweet and savory dishes. Whether you're making lemonade, salad dressings, desserts, or savory meals, fresh lemons bring a refreshing twist to your culinary creations.",
 				CategoryID:  2,
-				UPC:         "1231231",
 				DiscountID:  1,
-				Quantity:    8,
-				UnitID:      1,
 				Image:       "https://pngimg.com/d/lemon_PNG25198.png",
 				BrandID:     1,
 				CountryID:   1,
-				Step:        1.0,
+				Variants: []*ProductVariant{
+					{UPC: "1231231", Price: 23000, Quantity: 8, UnitID: 1, Step: 1.0},
+				},
 			},
 			{
 				Name:        "Cucumber",
-				Price:       50000,
 				Description: "Crunchy and hydrating cucumbers, prized for their refreshing taste and versatility. Fresh cucumbers are a low-calorie, nutrient-packed addition to your meals. Enjoy them sliced in salads, pickled for a tangy snack, or add a crisp touch to your water. With their high water content, cucumbers are perfect for staying hydrated while savoring a delightful, cool crunch.",
 				CategoryID:  3,
-				UPC:         "12312312",
 				DiscountID:  1,
-				Quantity:    12,
-				UnitID:      1,
 				Image:       "https://pngimg.com/d/cucumber_PNG12602.png",
 				BrandID:     1,
 				CountryID:   1,
-				Step:        1.0,
+				Variants: []*ProductVariant{
+					{UPC: "12312312", Price: 50000, Quantity: 12, UnitID: 1, Step: 1.0},
+				},
 			},
 		}
 
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ProductExportRow is one variant with its product and the product's
+// references resolved, a line of a catalog export. DiscountID is the
+// product's discount; VariantDiscountID is the variant's own, or 0.
+type ProductExportRow struct {
+	ProductID         int64     `json:"product_id"`
+	Name              string    `json:"name"`
+	Description       string    `json:"description"`
+	Image             string    `json:"image"`
+	CategoryID        int64     `json:"category_id"`
+	CategoryName      string    `json:"category_name"`
+	BrandID           int64     `json:"brand_id"`
+	BrandName         string    `json:"brand_name"`
+	CountryID         int64     `json:"country_id"`
+	CountryName       string    `json:"country_name"`
+	Alpha2            string    `json:"alpha2"`
+	DiscountID        int64     `json:"discount_id"`
+	DiscountName      string    `json:"discount_name"`
+	DiscountPercent   int       `json:"discount_percent"`
+	VariantID         int64     `json:"variant_id"`
+	VariantName       string    `json:"variant_name"`
+	UPC               string    `json:"upc"`
+	Price             int64     `json:"price"`
+	Quantity          float64   `json:"quantity"`
+	UnitID            int64     `json:"unit_id"`
+	UnitName          string    `json:"unit_name"`
+	Step              float64   `json:"step"`
+	VariantDiscountID int64     `json:"variant_discount_id"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// Export calls each for every variant of the products matching the filter,
+// in product order, as the rows arrive from the database. It stops at the
+// first error each returns. The query runs until ctx is done, however long
+// that takes.
+func (p ProductModel) Export(ctx context.Context, filter ProductFilter, each func(*ProductExportRow) error) error {
+	args := sqlArgs{}
+	from := filter.from(&args)
+	where := filter.where("", &args)
+
+	query := fmt.Sprintf(`
+		SELECT products.id, products.name, COALESCE(products.description, ''), COALESCE(products.image, ''),
+			COALESCE(categories.id, 0), COALESCE(categories.name, ''),
+			COALESCE(brands.id, 0), COALESCE(brands.name, ''),
+			COALESCE(countries.id, 0), COALESCE(countries.name, ''), COALESCE(countries.alpha2, ''),
+			COALESCE(discounts.id, 0), COALESCE(discounts.name, ''), COALESCE(discounts.discount_percent, 0),
+			export_variants.id, export_variants.name, COALESCE(export_variants.upc, ''), export_variants.price,
+			export_variants.quantity, COALESCE(units.id, 0), COALESCE(units.name, ''), export_variants.step,
+			COALESCE(export_variants.discount_id, 0), products.created_at
+		FROM %s
+		JOIN product_variants export_variants ON export_variants.product_id = products.id AND export_variants.deleted_at IS NULL
+		LEFT JOIN units ON units.id = export_variants.unit_id
+		WHERE %s
+		ORDER BY products.id, export_variants.id`, from, where)
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row ProductExportRow
+		err := rows.Scan(
+			&row.ProductID,
+			&row.Name,
+			&row.Description,
+			&row.Image,
+			&row.CategoryID,
+			&row.CategoryName,
+			&row.BrandID,
+			&row.BrandName,
+			&row.CountryID,
+			&row.CountryName,
+			&row.Alpha2,
+			&row.DiscountID,
+			&row.DiscountName,
+			&row.DiscountPercent,
+			&row.VariantID,
+			&row.VariantName,
+			&row.UPC,
+			&row.Price,
+			&row.Quantity,
+			&row.UnitID,
+			&row.UnitName,
+			&row.Step,
+			&row.VariantDiscountID,
+			&row.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = each(&row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
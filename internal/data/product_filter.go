@@ -0,0 +1,202 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Facet dimensions. Each facet is counted under every filter except its own,
+// so selecting one brand still shows how many products the others have.
+// Every filterable attribute is a dimension of its own.
+const (
+	FacetCategory = "category"
+	FacetBrand    = "brand"
+	FacetCountry  = "country"
+	FacetDiscount = "discount"
+	FacetPrice    = "price"
+)
+
+// activeDiscountSQL is true when the product's discount applies right now.
+// Archived discounts no longer apply.
+const activeDiscountSQL = `(NOW() BETWEEN discounts.started_at AND discounts.ended_at AND discounts.discount_percent > 0 AND discounts.deleted_at IS NULL)`
+
+// activeVariantDiscountSQL is true when the discount of a variant, its own or
+// its product's, applies right now.
+const activeVariantDiscountSQL = `(NOW() BETWEEN variant_discounts.started_at AND variant_discounts.ended_at AND variant_discounts.discount_percent > 0 AND variant_discounts.deleted_at IS NULL)`
+
+// variantDiscountPercentSQL is the percent of the variant's active discount,
+// or 0.
+const variantDiscountPercentSQL = `(CASE WHEN ` + activeVariantDiscountSQL + ` THEN variant_discounts.discount_percent ELSE 0 END)`
+
+// variantEffectivePriceSQL is the price of a variant after its active
+// discount, computed the same way as DiscountedPrice.
+const variantEffectivePriceSQL = `(product_variants.price - CASE
+	WHEN ` + activeVariantDiscountSQL + `
+	THEN LEAST(product_variants.price, product_variants.price * variant_discounts.discount_percent / 100)
+	ELSE 0
+END)`
+
+// productVariantsSQL sums up the listed variants of every product as
+// variants. A product without variants gets NULL prices and a zero quantity.
+const productVariantsSQL = `LEFT JOIN LATERAL (
+		SELECT min(product_variants.price) AS min_price,
+			max(product_variants.price) AS max_price,
+			min(` + variantEffectivePriceSQL + `) AS min_effective_price,
+			max(` + variantEffectivePriceSQL + `) AS max_effective_price,
+			max(` + variantDiscountPercentSQL + `) AS discount_percent,
+			COALESCE(sum(product_variants.quantity), 0) AS quantity
+		FROM product_variants
+		LEFT JOIN discounts variant_discounts ON variant_discounts.id = COALESCE(product_variants.discount_id, products.discount_id)
+		WHERE product_variants.product_id = products.id AND product_variants.deleted_at IS NULL
+	) variants ON TRUE`
+
+// discountPercentSQL is the largest active discount percent among the
+// product's variants, or 0.
+const discountPercentSQL = `COALESCE(variants.discount_percent, 0)`
+
+// effectivePriceSQL is the lowest price of the product's variants after
+// their active discounts, which is what a listing shows as "from".
+const effectivePriceSQL = `COALESCE(variants.min_effective_price, 0)`
+
+// popularitySQL is the number of orders that contain the product.
+const popularitySQL = `(SELECT count(DISTINCT order_items.order_id) FROM order_items WHERE order_items.product_id = products.id)`
+
+// Sort values of a product listing beyond its plain columns. Prefixing them
+// with "-" reverses the order, so "-created_at" lists the newest first.
+const (
+	SortEffectivePrice = "effective_price"
+	SortDiscount       = "discount_percent"
+	SortNewest         = "created_at"
+	SortPopularity     = "popularity"
+)
+
+// productSortColumns are the expressions a product listing can be sorted by.
+// None of them may be NULL, as keyset pagination compares them.
+var productSortColumns = map[string]string{
+	"id":               "products.id",
+	"name":             "products.name",
+	"price":            "COALESCE(variants.min_price, 0)",
+	SortEffectivePrice: effectivePriceSQL,
+	SortDiscount:       discountPercentSQL,
+	SortNewest:         "products.created_at",
+	SortPopularity:     popularitySQL,
+	SortRelevance:      "ts_rank_cd(products.search_vector, search.query)",
+}
+
+// productFromSQL joins everything a product listing shows or filters on.
+const productFromSQL = `products
+	LEFT JOIN categories ON products.category_id = categories.id
+	LEFT JOIN discounts ON products.discount_id = discounts.id
+	LEFT JOIN brands ON products.brand_id = brands.id
+	LEFT JOIN countries ON products.country_id = countries.id
+	` + productVariantsSQL
+
+// productColumnsSQL are the columns of a productDB, without its variants.
+var productColumnsSQL = `products.id, products.name, COALESCE(variants.min_price, 0), COALESCE(variants.max_price, 0), products.description, variants.quantity, products.image,
+			categories.id, categories.name, categories.description, categories.image,
+			discounts.id, discounts.name, discounts.description, discounts.discount_percent, discounts.created_at, discounts.started_at, discounts.ended_at,
+			brands.id, brands.name, brands.description,
+			countries.id, countries.name, countries.description, countries.alpha2, countries.alpha3,
+			products.version, products.deleted_at, ` + categoryPathSQL("products.category_id") + `, products.allergens`
+
+// ProductFilter holds the conditions of a product listing. Zero values
+// don't filter. A category matches the products of its subcategories too.
+// Prices are in tiyn and compared to the prices of the variants after their
+// active discounts; a product matches a price range if any of its variants
+// could.
+type ProductFilter struct {
+	Search      string
+	CategoryIDs []int
+	BrandIDs    []int
+	CountryIDs  []int
+	DiscountIDs []int
+	UnitID      int
+	MinPrice    int64
+	MaxPrice    int64
+	InStock     bool
+	OnSale      bool
+	Attributes  []AttributeFilter
+	// ExcludeAllergens leaves out the products that contain any of the
+	// allergens, and those whose allergens have not been declared.
+	ExcludeAllergens []string
+}
+
+func ValidateProductFilter(v *validator.Validator, f ProductFilter) {
+	v.Check(f.MinPrice >= 0, "min_price", "must not be negative")
+	v.Check(f.MaxPrice >= 0, "max_price", "must not be negative")
+	if f.MinPrice > 0 && f.MaxPrice > 0 {
+		v.Check(f.MinPrice <= f.MaxPrice, "max_price", "must not be less than min_price")
+	}
+	for _, allergen := range f.ExcludeAllergens {
+		v.Check(validator.PermittedValue(allergen, Allergens...), "exclude_allergens", "must only contain the codes of the EU 14 allergens")
+	}
+}
+
+// sqlArgs collects query arguments and hands out their placeholders.
+type sqlArgs []any
+
+func (a *sqlArgs) add(value any) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// from returns the FROM clause of a product listing, including the search
+// query that where and the relevance sort refer to as search.query.
+func (f ProductFilter) from(args *sqlArgs) string {
+	search := newProductSearch(f.Search)
+	placeholder := len(*args) + 1
+	*args = append(*args, search.args()...)
+
+	return fmt.Sprintf("%s\n\tCROSS JOIN %s", productFromSQL, productSearchSQL(placeholder))
+}
+
+// where returns the WHERE condition for every filter except the one of the
+// excluded facet dimension.
+func (f ProductFilter) where(exclude string, args *sqlArgs) string {
+	conditions := []string{"products.deleted_at IS NULL"}
+
+	if len(searchTerms(f.Search)) > 0 {
+		conditions = append(conditions, "products.search_vector @@ search.query")
+	}
+	if len(f.CategoryIDs) > 0 && exclude != FacetCategory {
+		conditions = append(conditions, "products.category_id IN "+categorySubtreeSQL(args.add(pq.Array(f.CategoryIDs))))
+	}
+	if len(f.BrandIDs) > 0 && exclude != FacetBrand {
+		conditions = append(conditions, "products.brand_id = ANY("+args.add(pq.Array(f.BrandIDs))+")")
+	}
+	if len(f.CountryIDs) > 0 && exclude != FacetCountry {
+		conditions = append(conditions, "products.country_id = ANY("+args.add(pq.Array(f.CountryIDs))+")")
+	}
+	if len(f.DiscountIDs) > 0 && exclude != FacetDiscount {
+		conditions = append(conditions, "products.discount_id = ANY("+args.add(pq.Array(f.DiscountIDs))+")")
+	}
+	if f.UnitID != 0 {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM product_variants WHERE product_variants.product_id = products.id AND product_variants.deleted_at IS NULL AND product_variants.unit_id = "+args.add(f.UnitID)+")")
+	}
+	if f.MinPrice > 0 && exclude != FacetPrice {
+		conditions = append(conditions, "variants.max_effective_price >= "+args.add(f.MinPrice))
+	}
+	if f.MaxPrice > 0 && exclude != FacetPrice {
+		conditions = append(conditions, "variants.min_effective_price <= "+args.add(f.MaxPrice))
+	}
+	if f.InStock {
+		conditions = append(conditions, "variants.quantity > 0")
+	}
+	if f.OnSale {
+		conditions = append(conditions, discountPercentSQL+" > 0")
+	}
+	if len(f.ExcludeAllergens) > 0 {
+		conditions = append(conditions, "products.allergens IS NOT NULL AND NOT products.allergens && "+args.add(pq.Array(f.ExcludeAllergens)))
+	}
+	for _, attribute := range f.Attributes {
+		if exclude != attributeFacet(attribute.Code) {
+			conditions = append(conditions, attribute.condition(args))
+		}
+	}
+
+	return strings.Join(conditions, "\n\tAND ")
+}
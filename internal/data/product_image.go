@@ -0,0 +1,302 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrImageOrderMismatch = errors.New("image order does not match the product images")
+
+// ProductImage is one image of a product's gallery. URL points at the
+// original upload and Renditions at its resized and WebP versions by name.
+// The primary image is mirrored into products.image.
+type ProductImage struct {
+	ID          int64             `json:"id"`
+	ProductID   int64             `json:"product_id"`
+	Position    int               `json:"position"`
+	Primary     bool              `json:"primary"`
+	URL         string            `json:"url"`
+	Renditions  map[string]string `json:"renditions"`
+	StorageKeys []string          `json:"-"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+type ProductImageModel struct {
+	DB *sql.DB
+}
+
+const productImageColumns = `id, product_id, position, is_primary, url, renditions, storage_keys, created_at`
+
+func scanProductImage(row interface{ Scan(...any) error }, image *ProductImage) error {
+	var renditions []byte
+
+	err := row.Scan(
+		&image.ID,
+		&image.ProductID,
+		&image.Position,
+		&image.Primary,
+		&image.URL,
+		&renditions,
+		pq.Array(&image.StorageKeys),
+		&image.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(renditions, &image.Renditions)
+}
+
+// Insert appends the image to the end of the product's gallery. The first
+// image of a product becomes its primary image, as does any image inserted
+// with Primary set.
+func (m ProductImageModel) Insert(image *ProductImage) error {
+	renditions, err := json.Marshal(image.Renditions)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the product so that concurrent uploads get distinct positions.
+	var hasPrimary bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM product_images WHERE product_id = products.id AND is_primary)
+		FROM products
+		WHERE id = $1
+		FOR UPDATE`, image.ProductID).Scan(&hasPrimary)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	image.Primary = image.Primary || !hasPrimary
+	if image.Primary && hasPrimary {
+		_, err = tx.ExecContext(ctx, `UPDATE product_images SET is_primary = false WHERE product_id = $1`, image.ProductID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+	INSERT INTO product_images (product_id, position, is_primary, url, renditions, storage_keys)
+	SELECT $1, COALESCE(MAX(position) + 1, 0), $2, $3, $4, $5
+	FROM product_images
+	WHERE product_id = $1
+	RETURNING id, position, created_at`
+
+	args := []any{
+		image.ProductID,
+		image.Primary,
+		image.URL,
+		renditions,
+		pq.Array(image.StorageKeys),
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&image.ID, &image.Position, &image.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if image.Primary {
+		err = setProductImage(ctx, tx, image.ProductID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m ProductImageModel) Get(productID, id int64) (*ProductImage, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + productImageColumns + ` FROM product_images WHERE id = $1 AND product_id = $2`
+
+	var image ProductImage
+	err := scanProductImage(m.DB.QueryRow(query, id, productID), &image)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &image, nil
+}
+
+// GetAllForProduct returns the gallery of the product in display order.
+func (m ProductImageModel) GetAllForProduct(productID int64) ([]*ProductImage, error) {
+	query := `SELECT ` + productImageColumns + ` FROM product_images WHERE product_id = $1 ORDER BY position, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []*ProductImage{}
+
+	for rows.Next() {
+		var image ProductImage
+		err := scanProductImage(rows, &image)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, &image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// SetPrimary makes the image the primary image of its product.
+func (m ProductImageModel) SetPrimary(productID, id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE product_images
+		SET is_primary = (id = $1)
+		WHERE product_id = $2 AND EXISTS (SELECT 1 FROM product_images WHERE id = $1 AND product_id = $2)`, id, productID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = setProductImage(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Reorder puts the product's images in the order of ids, which must list
+// every image of the product exactly once.
+func (m ProductImageModel) Reorder(productID int64, ids []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var matches bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(array_agg(id ORDER BY id), '{}') = (SELECT COALESCE(array_agg(DISTINCT x ORDER BY x), '{}') FROM unnest($2::bigint[]) AS x)
+			AND cardinality($2::bigint[]) = COUNT(*)
+		FROM product_images
+		WHERE product_id = $1`, productID, pq.Array(ids)).Scan(&matches)
+	if err != nil {
+		return err
+	}
+
+	if !matches {
+		return ErrImageOrderMismatch
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE product_images
+		SET position = ordered.position - 1
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS ordered(id, position)
+		WHERE product_images.id = ordered.id AND product_images.product_id = $1`, productID, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes the image from the gallery and returns it, so that its files
+// can be removed from storage. If it was the primary image, the next image in
+// the gallery takes its place.
+func (m ProductImageModel) Delete(productID, id int64) (*ProductImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM product_images WHERE id = $1 AND product_id = $2 RETURNING ` + productImageColumns
+
+	var image ProductImage
+	err = scanProductImage(tx.QueryRowContext(ctx, query, id, productID), &image)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if image.Primary {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE product_images
+			SET is_primary = true
+			WHERE id = (SELECT id FROM product_images WHERE product_id = $1 ORDER BY position, id LIMIT 1)`, productID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = setProductImage(ctx, tx, productID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &image, tx.Commit()
+}
+
+// setProductImage copies the URL of the product's primary image into
+// products.image, which the listings and older clients read.
+func setProductImage(ctx context.Context, tx *sql.Tx, productID int64) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE products
+		SET image = COALESCE((SELECT url FROM product_images WHERE product_id = $1 AND is_primary), ''), version = version + 1
+		WHERE id = $1`, productID)
+	return err
+}
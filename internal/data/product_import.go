@@ -0,0 +1,292 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+)
+
+// ImportRow is one line of a catalog import: a variant together with the
+// product it belongs to. References are given by name and matched without
+// regard to case. Blank fields keep the current value of an existing variant
+// or product; nil numbers are blank.
+type ImportRow struct {
+	Line        int
+	Name        string
+	Description string
+	Category    string
+	Brand       string
+	Country     string
+	Discount    string
+	Image       string
+	Variant     string
+	UPC         string
+	Price       *int64
+	Quantity    *float64
+	Unit        string
+	Step        *float64
+	// Errors holds the problems found while reading the line, such as a
+	// price that is not a number.
+	Errors map[string]string
+}
+
+// ImportRowError lists the problems of one line of an import.
+type ImportRowError struct {
+	Line   int               `json:"line"`
+	UPC    string            `json:"upc,omitempty"`
+	Errors map[string]string `json:"errors"`
+}
+
+// ImportReport is the outcome of an import. Nothing is saved if it has
+// errors or was a dry run.
+type ImportReport struct {
+	DryRun          bool             `json:"dry_run"`
+	Rows            int              `json:"rows"`
+	ProductsCreated int              `json:"products_created"`
+	VariantsCreated int              `json:"variants_created"`
+	VariantsUpdated int              `json:"variants_updated"`
+	Errors          []ImportRowError `json:"errors"`
+}
+
+func (r *ImportReport) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// importRefs maps the lower-cased names of the reference tables to their ids.
+type importRefs struct {
+	categories map[string]int64
+	brands     map[string]int64
+	countries  map[string]int64
+	discounts  map[string]int64
+	units      map[string]int64
+}
+
+func loadImportRefs(ctx context.Context, tx *sql.Tx) (*importRefs, error) {
+	load := func(table string) (map[string]int64, error) {
+		rows, err := tx.QueryContext(ctx, `SELECT id, lower(name) FROM `+table+` WHERE deleted_at IS NULL ORDER BY id`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		ids := map[string]int64{}
+		for rows.Next() {
+			var id int64
+			var name string
+			err := rows.Scan(&id, &name)
+			if err != nil {
+				return nil, err
+			}
+			// Discount names are not unique; the oldest one wins.
+			if _, ok := ids[name]; !ok {
+				ids[name] = id
+			}
+		}
+		return ids, rows.Err()
+	}
+
+	var refs importRefs
+	var err error
+
+	for _, ref := range []struct {
+		table string
+		ids   *map[string]int64
+	}{
+		{"categories", &refs.categories},
+		{"brands", &refs.brands},
+		{"countries", &refs.countries},
+		{"discounts", &refs.discounts},
+		{"units", &refs.units},
+	} {
+		*ref.ids, err = load(ref.table)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &refs, nil
+}
+
+// resolve sets *id to the id of the named reference. A blank name keeps the
+// current id, which must then be set unless the reference is optional.
+func (refs *importRefs) resolve(v *validator.Validator, key, name string, ids map[string]int64, id *int64, optional bool) {
+	if name == "" {
+		v.Check(optional || *id != 0, key, "must be provided")
+		return
+	}
+
+	found, ok := ids[strings.ToLower(name)]
+	if !ok {
+		v.AddError(key, "no "+key+" named \""+name+"\"")
+		return
+	}
+	*id = found
+}
+
+// Import upserts the rows in a single transaction. A row whose UPC matches a
+// variant updates it and its product; any other row adds a variant, to the
+// product created for an earlier row with the same name and brand or else
+// to a new product. Every row is checked with ValidateProduct; if any fails,
+// or dryRun is set, the transaction is rolled back and only the report is
+// returned.
+func (p ProductModel) Import(rows []*ImportRow, dryRun bool) (*ImportReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	refs, err := loadImportRefs(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: dryRun, Rows: len(rows), Errors: []ImportRowError{}}
+	lines := map[string]int{}
+	products := map[string]*Product{}
+
+	for _, row := range rows {
+		v := validator.New()
+		for key, message := range row.Errors {
+			v.AddError(key, message)
+		}
+
+		v.Check(row.UPC != "", "upc", "must be provided")
+		if line, ok := lines[row.UPC]; ok && row.UPC != "" {
+			v.AddError("upc", fmt.Sprintf("already used on line %d", line))
+		}
+		lines[row.UPC] = row.Line
+
+		variant, product, err := importTarget(ctx, tx, refs, row, products)
+		if err != nil {
+			return nil, err
+		}
+		v.Check(product.DeletedAt == nil, "upc", "belongs to an archived product")
+
+		applyImportRow(v, refs, row, product, variant)
+
+		check := *product
+		check.Variants = []*ProductVariant{variant}
+		ValidateProduct(v, &check)
+
+		if !v.Valid() {
+			errs := map[string]string{}
+			for key, message := range v.Errors {
+				errs[strings.TrimPrefix(key, "variants[0].")] = message
+			}
+			report.Errors = append(report.Errors, ImportRowError{Line: row.Line, UPC: row.UPC, Errors: errs})
+			continue
+		}
+
+		if product.ID == 0 {
+			err = insertProduct(ctx, tx, product)
+			report.ProductsCreated++
+		} else {
+			err = updateProduct(ctx, tx, product)
+		}
+		if err != nil {
+			return nil, err
+		}
+		products[importKey(product.Name, product.BrandID)] = product
+
+		variant.ProductID = product.ID
+		if variant.ID == 0 {
+			err = insertVariant(ctx, tx, variant)
+			report.VariantsCreated++
+		} else {
+			err = updateVariant(ctx, tx, variant)
+			report.VariantsUpdated++
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun || !report.Valid() {
+		return report, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// importTarget finds the variant and product a row writes to: the variant
+// with the row's UPC, or a new variant of a product made earlier in the
+// import, or a new variant of a new product.
+func importTarget(ctx context.Context, tx *sql.Tx, refs *importRefs, row *ImportRow, products map[string]*Product) (*ProductVariant, *Product, error) {
+	var variant ProductVariant
+
+	err := scanVariant(tx.QueryRowContext(ctx, `SELECT `+variantColumns+` FROM product_variants WHERE upc = $1 AND deleted_at IS NULL FOR UPDATE`, row.UPC), &variant)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if product, ok := products[importKey(row.Name, refs.brands[strings.ToLower(row.Brand)])]; ok {
+			return &ProductVariant{Step: 1}, product, nil
+		}
+		return &ProductVariant{Step: 1}, &Product{}, nil
+	case err != nil:
+		return nil, nil, err
+	}
+
+	for _, product := range products {
+		if product.ID == variant.ProductID {
+			return &variant, product, nil
+		}
+	}
+
+	product, err := lockProduct(ctx, tx, "id", variant.ProductID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &variant, product, nil
+}
+
+// applyImportRow copies the non-blank fields of the row onto the product and
+// variant, resolving references by name. A new variant needs a price.
+func applyImportRow(v *validator.Validator, refs *importRefs, row *ImportRow, product *Product, variant *ProductVariant) {
+	if row.Name != "" {
+		product.Name = row.Name
+	}
+	if row.Description != "" {
+		product.Description = row.Description
+	}
+	if row.Image != "" {
+		product.Image = row.Image
+	}
+
+	refs.resolve(v, "category", row.Category, refs.categories, &product.CategoryID, false)
+	refs.resolve(v, "brand", row.Brand, refs.brands, &product.BrandID, false)
+	refs.resolve(v, "country", row.Country, refs.countries, &product.CountryID, false)
+	refs.resolve(v, "discount", row.Discount, refs.discounts, &product.DiscountID, false)
+	refs.resolve(v, "unit", row.Unit, refs.units, &variant.UnitID, true)
+
+	variant.UPC = row.UPC
+	if row.Variant != "" {
+		variant.Name = row.Variant
+	}
+	if row.Price != nil {
+		variant.Price = *row.Price
+	} else {
+		v.Check(variant.ID != 0, "price", "must be provided")
+	}
+	if row.Quantity != nil {
+		variant.Quantity = *row.Quantity
+	}
+	if row.Step != nil {
+		variant.Step = *row.Step
+	}
+}
+
+// importKey identifies a product within an import by its name and brand.
+func importKey(name string, brandID int64) string {
+	return fmt.Sprintf("%s\x00%d", strings.ToLower(name), brandID)
+}
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestProductInsert(t *testing.T) {
+	models := newTestModels(t)
+
+	product := &Product{
+		Name:        "Test Apple",
+		Description: "An apple inserted by the tests.",
+		Image:       "apple.png",
+	}
+	for table, id := range map[string]*int64{
+		"categories": &product.CategoryID,
+		"discounts":  &product.DiscountID,
+		"brands":     &product.BrandID,
+		"countries":  &product.CountryID,
+	} {
+		err := models.Products.DB.QueryRow(`SELECT min(id) FROM ` + table).Scan(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	upc := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	product.Variants = []*ProductVariant{
+		{Name: "1 kg", UPC: upc, Price: 50000, Quantity: 10, Step: 1},
+		{Name: "2 kg", Price: 90000, Quantity: 5, Step: 1},
+	}
+
+	err := models.Products.Insert(product)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		models.Products.DB.Exec(`DELETE FROM products WHERE id = $1`, product.ID)
+	})
+
+	for _, variant := range product.Variants {
+		if variant.ID == 0 || variant.ProductID != product.ID || variant.Version != 1 {
+			t.Errorf("variant %q was not filled in: %+v", variant.Name, variant)
+		}
+	}
+
+	variants, err := models.Variants.GetAllForProduct(product.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != 2 {
+		t.Fatalf("got %d variants, want 2", len(variants))
+	}
+
+	variant, err := models.Variants.GetByUPC(upc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variant.ID != product.Variants[0].ID || variant.DeletedAt != nil {
+		t.Errorf("GetByUPC = %+v, want variant %d", variant, product.Variants[0].ID)
+	}
+
+	err = models.Variants.Insert(&ProductVariant{ProductID: product.ID, Name: "copy", UPC: upc, Step: 1})
+	if err != ErrDuplicateUPC {
+		t.Errorf("inserting a duplicate UPC returned %v, want ErrDuplicateUPC", err)
+	}
+}
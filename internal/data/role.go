@@ -136,6 +136,21 @@ func (r RoleModel) GetAdminRoleID() (id int64) {
 	return role.ID
 }
 
+// GetDefaultRoleID returns the id of the role assigned to self-registered
+// users, or 0 if it has not been seeded yet.
+func (r RoleModel) GetDefaultRoleID() (id int64) {
+	query := `
+		SELECT id
+		FROM roles
+		WHERE name = 'USER';`
+
+	err := r.DB.QueryRow(query).Scan(&id)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
 func (r RoleModel) Update(role *Role) error {
 	query := `UPDATE roles
 	SET name = $1, description = $2
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// toLatin mirrors the translit_latin SQL function, which builds the Latin
+// half of products.search_vector.
+var toLatin = strings.NewReplacer(
+	"а", "a", "б", "b", "в", "v", "г", "g", "д", "d", "е", "e", "ё", "e",
+	"ж", "zh", "з", "z", "и", "i", "й", "y", "к", "k", "л", "l", "м", "m",
+	"н", "n", "о", "o", "п", "p", "р", "r", "с", "s", "т", "t", "у", "u",
+	"ф", "f", "х", "h", "ц", "ts", "ч", "ch", "ш", "sh", "щ", "sch", "ъ", "",
+	"ы", "y", "ь", "", "э", "e", "ю", "yu", "я", "ya",
+	"ә", "a", "ғ", "g", "қ", "q", "ң", "n", "ө", "o", "ұ", "u", "ү", "u",
+	"һ", "h", "і", "i",
+)
+
+// toCyrillic guesses the Russian spelling of a word typed in Latin letters.
+// Longer combinations come first so that "sh" wins over "s".
+var toCyrillic = strings.NewReplacer(
+	"shch", "щ", "sch", "щ", "zh", "ж", "ch", "ч", "sh", "ш", "kh", "х",
+	"ts", "ц", "yu", "ю", "ya", "я", "yo", "ё",
+	"a", "а", "b", "б", "c", "к", "d", "д", "e", "е", "f", "ф", "g", "г",
+	"h", "х", "i", "и", "j", "й", "k", "к", "l", "л", "m", "м", "n", "н",
+	"o", "о", "p", "п", "q", "к", "r", "р", "s", "с", "t", "т", "u", "у",
+	"v", "в", "w", "в", "x", "кс", "y", "ы", "z", "з",
+)
+
+func ToLatin(s string) string {
+	return toLatin.Replace(strings.ToLower(s))
+}
+
+func ToCyrillic(s string) string {
+	return toCyrillic.Replace(strings.ToLower(s))
+}
+
+// searchTerms splits a search string into words, dropping everything that
+// has a meaning in the tsquery syntax.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// prefixQuery joins the terms into a to_tsquery expression that matches
+// every term as a prefix, so results show up while the customer is typing.
+func prefixQuery(terms []string, transform func(string) string) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if term = transform(term); term != "" {
+			parts = append(parts, term+":*")
+		}
+	}
+	return strings.Join(parts, " & ")
+}
+
+// productSearch holds the three spellings of a search string that are
+// matched against products.search_vector: as typed with Russian morphology,
+// transliterated to Cyrillic, and transliterated to Latin.
+type productSearch struct {
+	Russian  string
+	Cyrillic string
+	Latin    string
+}
+
+func newProductSearch(q string) productSearch {
+	terms := searchTerms(q)
+	identity := func(s string) string { return s }
+
+	return productSearch{
+		Russian:  prefixQuery(terms, identity),
+		Cyrillic: prefixQuery(terms, ToCyrillic),
+		Latin:    prefixQuery(terms, ToLatin),
+	}
+}
+
+// productSearchSQL is the FROM item that turns the three spellings, passed
+// as consecutive placeholders starting at the given one, into a tsquery
+// named search.query.
+func productSearchSQL(placeholder int) string {
+	return fmt.Sprintf(
+		"(SELECT to_tsquery('russian', $%d) || to_tsquery('russian', $%d) || to_tsquery('simple', $%d) AS query) search",
+		placeholder, placeholder+1, placeholder+2)
+}
+
+// args returns the three spellings in the order productSearchSQL expects.
+func (s productSearch) args() []any {
+	return []any{s.Russian, s.Cyrillic, s.Latin}
+}
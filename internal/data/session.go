@@ -0,0 +1,264 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrSessionRevoked = errors.New("session was revoked")
+	ErrTokenReused    = errors.New("refresh token was reused")
+)
+
+// Session is a single signed-in device. Only the hash of the latest refresh
+// token is stored; every refresh replaces it.
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+type SessionModel struct {
+	DB   *sql.DB
+	Keys *KeyManager
+}
+
+func hashRefreshToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// Create opens a new session for the user and returns its first token pair.
+func (s SessionModel) Create(userID, roleID int64, userAgent, ip string) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO sessions (user_id, token_hash, user_agent, ip, expires_at)
+	VALUES ($1, '', $2, $3, $4)
+	RETURNING id`
+
+	var sessionID int64
+	err = tx.QueryRowContext(ctx, query, userID, userAgent, ip, time.Now().Add(RefreshTokenExpire)).Scan(&sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.Keys.GenerateTokens(userID, roleID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE sessions SET token_hash = $1 WHERE id = $2`, hashRefreshToken(token.RefreshToken), sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Rotate exchanges a refresh token for a new token pair. A token that was
+// already exchanged means it has leaked, so the whole session is revoked and
+// ErrTokenReused is returned.
+func (s SessionModel) Rotate(refreshToken, userAgent, ip string) (*Token, error) {
+	claims, err := s.Keys.DecodeRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, okUser := claims["user_id"].(float64)
+	roleID, okRole := claims["role_id"].(float64)
+	sessionID, okSession := claims["sid"].(float64)
+	if !okUser || !okRole || !okSession {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT token_hash, revoked_at IS NOT NULL OR expires_at < NOW()
+		FROM sessions
+		WHERE id = $1 AND user_id = $2
+		FOR UPDATE`
+
+	var (
+		tokenHash []byte
+		inactive  bool
+	)
+	err = tx.QueryRowContext(ctx, query, int64(sessionID), int64(userID)).Scan(&tokenHash, &inactive)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if inactive {
+		return nil, ErrSessionRevoked
+	}
+
+	if subtle.ConstantTimeCompare(tokenHash, hashRefreshToken(refreshToken)) != 1 {
+		_, err = tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE id = $1`, int64(sessionID))
+		if err != nil {
+			return nil, err
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrTokenReused
+	}
+
+	token, err := s.Keys.GenerateTokens(int64(userID), int64(roleID), int64(sessionID))
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+	UPDATE sessions
+	SET token_hash = $1, user_agent = $2, ip = $3, last_used_at = NOW(), expires_at = $4
+	WHERE id = $5`
+
+	args := []any{
+		hashRefreshToken(token.RefreshToken),
+		userAgent,
+		ip,
+		time.Now().Add(RefreshTokenExpire),
+		int64(sessionID),
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// IsActive reports whether access tokens issued for the session may still be
+// used.
+func (s SessionModel) IsActive(sessionID, userID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM sessions
+			WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var active bool
+	err := s.DB.QueryRowContext(ctx, query, sessionID, userID).Scan(&active)
+	return active, err
+}
+
+func (s SessionModel) GetAllForUser(userID int64) ([]*Session, error) {
+	query := `
+		SELECT id, user_id, user_agent, ip, created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Revoke ends a single session of the user.
+func (s SessionModel) Revoke(sessionID, userID int64) error {
+	query := `
+	UPDATE sessions
+	SET revoked_at = NOW()
+	WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, query, sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeAllForUser ends every session of the user, signing them out on all
+// devices.
+func (s SessionModel) RevokeAllForUser(userID int64) error {
+	query := `
+	UPDATE sessions
+	SET revoked_at = NOW()
+	WHERE user_id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, query, userID)
+	return err
+}
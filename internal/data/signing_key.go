@@ -0,0 +1,178 @@
+package data
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown signing algorithm")
+
+// SigningKey is a key pair used to sign and verify tokens. Keys are never
+// deleted: retiring a key stops it from being used for verification, so
+// every token signed with it is rejected from then on.
+type SigningKey struct {
+	ID         int64            `json:"id"`
+	KID        string           `json:"kid"`
+	Algorithm  string           `json:"algorithm"`
+	PrivateKey crypto.Signer    `json:"-"`
+	PublicKey  crypto.PublicKey `json:"-"`
+	CreatedAt  time.Time        `json:"created_at"`
+	RetiredAt  *time.Time       `json:"retired_at,omitempty"`
+}
+
+type SigningKeyModel struct {
+	DB *sql.DB
+}
+
+// GenerateSigningKey creates a new key pair for the given algorithm with a
+// random kid.
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	key := &SigningKey{Algorithm: algorithm}
+
+	switch algorithm {
+	case AlgorithmEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key.PrivateKey, key.PublicKey = private, public
+	case AlgorithmRS256:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		key.PrivateKey, key.PublicKey = private, &private.PublicKey
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+
+	kid := make([]byte, 12)
+	_, err := rand.Read(kid)
+	if err != nil {
+		return nil, err
+	}
+	key.KID = base64.RawURLEncoding.EncodeToString(kid)
+
+	return key, nil
+}
+
+func (s SigningKeyModel) Insert(key *SigningKey) error {
+	privateDER, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO signing_keys (kid, algorithm, private_key)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return s.DB.QueryRowContext(ctx, query, key.KID, key.Algorithm, privateDER).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetActive returns the keys that have not been retired, newest first. The
+// first one is used for signing, all of them for verification.
+func (s SigningKeyModel) GetActive() ([]*SigningKey, error) {
+	return s.getAll(`WHERE retired_at IS NULL`)
+}
+
+func (s SigningKeyModel) GetAll() ([]*SigningKey, error) {
+	return s.getAll(``)
+}
+
+func (s SigningKeyModel) getAll(where string) ([]*SigningKey, error) {
+	query := fmt.Sprintf(`
+		SELECT id, kid, algorithm, private_key, created_at, retired_at
+		FROM signing_keys
+		%s
+		ORDER BY created_at DESC, id DESC`, where)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*SigningKey{}
+
+	for rows.Next() {
+		var (
+			key        SigningKey
+			privateDER []byte
+		)
+		err := rows.Scan(
+			&key.ID,
+			&key.KID,
+			&key.Algorithm,
+			&privateDER,
+			&key.CreatedAt,
+			&key.RetiredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		private, err := x509.ParsePKCS8PrivateKey(privateDER)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", key.KID, err)
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s: %w", key.KID, ErrUnknownAlgorithm)
+		}
+		key.PrivateKey, key.PublicKey = signer, signer.Public()
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Retire stops the key from being used for signing and verification.
+func (s SigningKeyModel) Retire(kid string) error {
+	query := `
+	UPDATE signing_keys
+	SET retired_at = NOW()
+	WHERE kid = $1 AND retired_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, query, kid)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
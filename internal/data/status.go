@@ -4,13 +4,55 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/dexciuq/yummy-express-backend/internal/validator"
 )
 
+// Codes of the statuses the order workflow is built on. They are seeded by
+// StatusModel.Init and can be renamed but not deleted; statuses added later
+// have no code.
+const (
+	StatusOrdered    = "ordered"
+	StatusProcessing = "processing"
+	StatusShipped    = "shipped"
+	StatusDelivered  = "delivered"
+	StatusCancelled  = "cancelled"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid status transition")
+	// ErrWorkflowStatus is returned for deleting a status the order workflow
+	// is built on.
+	ErrWorkflowStatus = errors.New("workflow status")
+)
+
+// statusTransitions is the graph of allowed order status changes by status
+// code. Delivered and Cancelled orders are final and can not be moved
+// anywhere else, and neither can orders in a status without a code.
+var statusTransitions = map[string][]string{
+	StatusOrdered:    {StatusProcessing, StatusCancelled},
+	StatusProcessing: {StatusShipped, StatusCancelled},
+	StatusShipped:    {StatusDelivered},
+	StatusDelivered:  {},
+	StatusCancelled:  {},
+}
+
+// CanTransition reports whether an order may move from the status with one
+// code to the status with another.
+func CanTransition(from, to string) bool {
+	for _, next := range statusTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
 type Status struct {
 	ID          int64  `json:"id"`
+	Code        string `json:"code,omitempty"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
 }
@@ -27,11 +69,12 @@ func ValidateStatus(v *validator.Validator, status *Status) {
 
 func (s StatusModel) Insert(status *Status) error {
 	query := `
-	INSERT INTO statuses (name, description)
-	VALUES ($1, $2)
+	INSERT INTO statuses (code, name, description)
+	VALUES (NULLIF($1, ''), $2, $3)
 	RETURNING id`
 
 	args := []any{
+		status.Code,
 		status.Name,
 		status.Description,
 	}
@@ -48,16 +91,27 @@ func (s StatusModel) Insert(status *Status) error {
 	return nil
 }
 
-func (s StatusModel) GetAll() ([]*Status, error) {
-	query := `SELECT count(*) OVER(), id, name, description FROM statuses`
+// GetAll returns a page of the statuses.
+func (s StatusModel) GetAll(filters Filters) ([]*Status, Metadata, error) {
+	args := sqlArgs{}
+	filters.SortColumns = referenceSortColumns("statuses")
+
+	page, orderBy, limit := filters.pageSQL("statuses.id", &args)
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, id, COALESCE(code, ''), name, description
+		FROM statuses
+		WHERE %s
+		ORDER BY %s
+		%s`, filters.countSQL(), filters.sortKeySQL(), page, orderBy, limit)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
-	rows, err := s.DB.QueryContext(ctx, query)
+	rows, err := s.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
@@ -65,25 +119,32 @@ func (s StatusModel) GetAll() ([]*Status, error) {
 	totalRecords := 0
 
 	statuses := []*Status{}
+	var keys []pageKey
 
 	for rows.Next() {
 		var status Status
+		var key pageKey
 		err := rows.Scan(
 			&totalRecords,
+			&key.Key,
 			&status.ID,
+			&status.Code,
 			&status.Name,
 			&status.Description,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
+		key.ID = status.ID
 		statuses = append(statuses, &status)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
-	return statuses, nil
+	statuses, metadata := paginate(filters, totalRecords, statuses, keys)
+	return statuses, metadata, nil
 }
 
 func (s StatusModel) Get(id int64) (*Status, error) {
@@ -92,13 +153,14 @@ func (s StatusModel) Get(id int64) (*Status, error) {
 	}
 
 	query := `
-		SELECT id, name, description
+		SELECT id, COALESCE(code, ''), name, description
 		FROM statuses
 		WHERE id = $1`
 
 	var status Status
 	err := s.DB.QueryRow(query, id).Scan(
 		&status.ID,
+		&status.Code,
 		&status.Name,
 		&status.Description,
 	)
@@ -128,13 +190,15 @@ func (s StatusModel) Update(status *Status) error {
 	return s.DB.QueryRow(query, args...).Scan(&status.ID)
 }
 
+// Delete removes a status. It returns ErrWorkflowStatus for the statuses the
+// order workflow is built on.
 func (s StatusModel) Delete(id int64) error {
 	query := `
 		DELETE FROM statuses
-		WHERE id = $1`
+		WHERE id = $1 AND code IS NULL`
 	result, err := s.DB.Exec(query, id)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -143,6 +207,14 @@ func (s StatusModel) Delete(id int64) error {
 	}
 
 	if rowsAffected == 0 {
+		var exists bool
+		err = s.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM statuses WHERE id = $1)`, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrWorkflowStatus
+		}
 		return ErrRecordNotFound
 	}
 	return nil
@@ -158,22 +230,27 @@ func (s StatusModel) Init() error {
 	if count == 0 {
 		statuses := []*Status{
 			{
+				Code:        StatusOrdered,
 				Name:        "Ordered",
 				Description: "The order has been successfully placed by the customer.",
 			},
 			{
+				Code:        StatusProcessing,
 				Name:        "Processing",
 				Description: "The order is being prepared, which may include packaging and other necessary preparations.",
 			},
 			{
+				Code:        StatusShipped,
 				Name:        "Shipped",
 				Description: "The order has been dispatched from the warehouse and is on its way.",
 			},
 			{
+				Code:        StatusDelivered,
 				Name:        "Delivered",
 				Description: "The order has been successfully delivered to the customer.",
 			},
 			{
+				Code:        StatusCancelled,
 				Name:        "Cancelled",
 				Description: "The order has been cancelled by either the customer or the seller.",
 			},
@@ -0,0 +1,162 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// stockEpsilon absorbs floating point noise when comparing weighted amounts.
+const stockEpsilon = 1e-9
+
+var (
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrInvalidAmount     = errors.New("invalid amount")
+)
+
+// StockShortage describes a product variant that can not cover the requested
+// amount.
+type StockShortage struct {
+	ProductID int64   `json:"product_id"`
+	VariantID int64   `json:"variant_id"`
+	Name      string  `json:"name"`
+	Requested float64 `json:"requested"`
+	Available float64 `json:"available"`
+}
+
+// InsufficientStockError lists every variant that is short of stock, so the
+// client can fix the whole order at once.
+type InsufficientStockError struct {
+	Shortages []StockShortage
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for %d product variant(s)", len(e.Shortages))
+}
+
+func (e *InsufficientStockError) Is(target error) bool {
+	return target == ErrInsufficientStock
+}
+
+// ValidAmount reports whether the amount is positive and a whole number of
+// steps, e.g. 1.5 kg of a product sold by 0.5 kg.
+func ValidAmount(amount, step float64) bool {
+	if amount <= 0 {
+		return false
+	}
+	if step <= 0 {
+		return true
+	}
+	steps := amount / step
+	return math.Abs(steps-math.Round(steps)) < 1e-6
+}
+
+// stockChange is a requested change of a variant's stock. Amount is the new
+// amount of the order line and is checked against the variant step, Delta is
+// how much stock has to be taken (or returned, if negative).
+type stockChange struct {
+	VariantID int64
+	Amount    float64
+	Delta     float64
+}
+
+// reserveStock locks the affected variant rows, verifies that there is
+// enough stock for every change and decrements it. Rows are locked in id
+// order so that concurrent orders can not deadlock each other. With
+// listedOnly, archived variants and the variants of archived products count
+// as missing.
+func reserveStock(ctx context.Context, tx *sql.Tx, changes []stockChange, listedOnly bool) error {
+	deltas := make(map[int64]float64)
+	ids := []int64{}
+	for _, change := range changes {
+		if _, ok := deltas[change.VariantID]; !ok {
+			ids = append(ids, change.VariantID)
+		}
+		deltas[change.VariantID] += change.Delta
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	query := `
+		SELECT product_variants.id, product_variants.product_id, products.name, product_variants.name, product_variants.quantity, product_variants.step
+		FROM product_variants
+		INNER JOIN products ON products.id = product_variants.product_id
+		WHERE product_variants.id = ANY($1)
+			AND (NOT $2 OR (product_variants.deleted_at IS NULL AND products.deleted_at IS NULL))
+		ORDER BY product_variants.id
+		FOR UPDATE OF product_variants`
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(ids), listedOnly)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type stockRow struct {
+		productID int64
+		name      string
+		quantity  float64
+		step      float64
+	}
+	variants := make(map[int64]stockRow)
+
+	for rows.Next() {
+		var id int64
+		var row stockRow
+		var variantName string
+		err := rows.Scan(&id, &row.productID, &row.name, &variantName, &row.quantity, &row.step)
+		if err != nil {
+			return err
+		}
+		if variantName != "" {
+			row.name += " " + variantName
+		}
+		variants[id] = row
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		variant, ok := variants[change.VariantID]
+		if !ok {
+			return ErrRecordNotFound
+		}
+		if change.Amount > 0 && !ValidAmount(change.Amount, variant.step) {
+			return fmt.Errorf("%w: %s can only be ordered in steps of %g", ErrInvalidAmount, variant.name, variant.step)
+		}
+	}
+
+	var shortages []StockShortage
+	for _, id := range ids {
+		variant := variants[id]
+		if deltas[id] > variant.quantity+stockEpsilon {
+			shortages = append(shortages, StockShortage{
+				ProductID: variant.productID,
+				VariantID: id,
+				Name:      variant.name,
+				Requested: deltas[id],
+				Available: variant.quantity,
+			})
+		}
+	}
+	if len(shortages) > 0 {
+		return &InsufficientStockError{Shortages: shortages}
+	}
+
+	for _, id := range ids {
+		if deltas[id] == 0 {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE product_variants SET quantity = quantity - $1, version = version + 1 WHERE id = $2`, deltas[id], id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// suggestThreshold is the pg_trgm word similarity a name needs to be
+	// suggested. It is lower than the default of 0.6 so that a single typo
+	// in a short word still matches.
+	suggestThreshold = 0.3
+	// didYouMeanThreshold is the similarity a correction needs to be
+	// offered.
+	didYouMeanThreshold = 0.3
+)
+
+type Suggestion struct {
+	ID    int64   `json:"id"`
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+type Suggestions struct {
+	Products   []*Suggestion `json:"products"`
+	Categories []*Suggestion `json:"categories"`
+	Brands     []*Suggestion `json:"brands"`
+}
+
+// Suggest returns the product, category and brand names closest to what
+// the customer has typed so far, best match first.
+func (p ProductModel) Suggest(q string, limit int) (*Suggestions, error) {
+	suggestions := &Suggestions{
+		Products:   []*Suggestion{},
+		Categories: []*Suggestion{},
+		Brands:     []*Suggestion{},
+	}
+
+	q = ToLatin(strings.TrimSpace(q))
+	if q == "" {
+		return suggestions, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`, strconv.FormatFloat(suggestThreshold, 'f', -1, 64))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, target := range []struct {
+		table string
+		dest  *[]*Suggestion
+	}{
+		{"products", &suggestions.Products},
+		{"categories", &suggestions.Categories},
+		{"brands", &suggestions.Brands},
+	} {
+		*target.dest, err = suggestNames(ctx, tx, target.table, q, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return suggestions, tx.Commit()
+}
+
+// suggestNames looks up the names of the table that contain a word similar
+// to q. The table name never comes from user input.
+func suggestNames(ctx context.Context, tx *sql.Tx, table, q string, limit int) ([]*Suggestion, error) {
+	query := `
+		SELECT id, name, word_similarity($1, translit_latin(name)) AS score
+		FROM ` + table + `
+		WHERE $1 <% translit_latin(name) AND deleted_at IS NULL
+		ORDER BY score DESC, name ASC
+		LIMIT $2`
+
+	rows, err := tx.QueryContext(ctx, query, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*Suggestion{}
+
+	for rows.Next() {
+		var suggestion Suggestion
+		err := rows.Scan(&suggestion.ID, &suggestion.Name, &suggestion.Score)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+// DidYouMean returns the product, category or brand name most similar to a
+// search that found nothing, or an empty string if nothing is close enough.
+func (p ProductModel) DidYouMean(q string) (string, error) {
+	q = ToLatin(strings.TrimSpace(q))
+	if q == "" {
+		return "", nil
+	}
+
+	// The % operator lets the trigram indexes find the candidates; similarity
+	// only ranks them.
+	query := `
+		SELECT name
+		FROM (
+			SELECT name, similarity($1, translit_latin(name)) AS score FROM products
+			WHERE $1 % translit_latin(name) AND deleted_at IS NULL
+			UNION ALL
+			SELECT name, similarity($1, translit_latin(name)) AS score FROM categories
+			WHERE $1 % translit_latin(name) AND deleted_at IS NULL
+			UNION ALL
+			SELECT name, similarity($1, translit_latin(name)) AS score FROM brands
+			WHERE $1 % translit_latin(name) AND deleted_at IS NULL
+		) names
+		ORDER BY score DESC, name ASC
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT set_config('pg_trgm.similarity_threshold', $1, true)`, strconv.FormatFloat(didYouMeanThreshold, 'f', -1, 64))
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	err = tx.QueryRowContext(ctx, query, q).Scan(&name)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", nil
+		default:
+			return "", err
+		}
+	}
+	return name, tx.Commit()
+}
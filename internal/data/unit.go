@@ -4,15 +4,17 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/dexciuq/yummy-express-backend/internal/validator"
 )
 
 type Unit struct {
-	ID          int64  `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 }
 
 type UnitModel struct {
@@ -48,16 +50,27 @@ func (u UnitModel) Insert(unit *Unit) error {
 	return nil
 }
 
-func (u UnitModel) GetAll() ([]*Unit, error) {
-	query := `SELECT count(*) OVER(), id, name, description FROM units`
+// GetAll returns a page of the units.
+func (u UnitModel) GetAll(filters Filters) ([]*Unit, Metadata, error) {
+	args := sqlArgs{}
+	filters.SortColumns = referenceSortColumns("units")
+
+	page, orderBy, limit := filters.pageSQL("units.id", &args)
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, id, name, description
+		FROM units
+		WHERE deleted_at IS NULL AND %s
+		ORDER BY %s
+		%s`, filters.countSQL(), filters.sortKeySQL(), page, orderBy, limit)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
-	rows, err := u.DB.QueryContext(ctx, query)
+	rows, err := u.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
@@ -65,25 +78,31 @@ func (u UnitModel) GetAll() ([]*Unit, error) {
 	totalRecords := 0
 
 	units := []*Unit{}
+	var keys []pageKey
 
 	for rows.Next() {
 		var unit Unit
+		var key pageKey
 		err := rows.Scan(
 			&totalRecords,
+			&key.Key,
 			&unit.ID,
 			&unit.Name,
 			&unit.Description,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
+		key.ID = unit.ID
 		units = append(units, &unit)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
-	return units, nil
+	units, metadata := paginate(filters, totalRecords, units, keys)
+	return units, metadata, nil
 }
 
 func (u UnitModel) Get(id int64) (*Unit, error) {
@@ -92,7 +111,7 @@ func (u UnitModel) Get(id int64) (*Unit, error) {
 	}
 
 	query := `
-		SELECT id, name, description
+		SELECT id, name, description, deleted_at
 		FROM units
 		WHERE id = $1`
 
@@ -101,6 +120,7 @@ func (u UnitModel) Get(id int64) (*Unit, error) {
 		&unit.ID,
 		&unit.Name,
 		&unit.Description,
+		&unit.DeletedAt,
 	)
 	if err != nil {
 		switch {
@@ -128,24 +148,13 @@ func (u UnitModel) Update(unit *Unit) error {
 	return u.DB.QueryRow(query, args...).Scan(&unit.ID)
 }
 
+// Delete archives the unit. It is no longer listed, but still resolves by
+// id for the products and orders that refer to it.
 func (u UnitModel) Delete(id int64) error {
-	query := `
-		DELETE FROM units
-		WHERE id = $1`
-	result, err := u.DB.Exec(query, id)
-	if err != nil {
-		return nil
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-	return nil
+	return archive(ctx, u.DB, "units", id)
 }
 
 func (u UnitModel) Init() error {
@@ -3,11 +3,14 @@ package data
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -16,8 +19,9 @@ import (
 )
 
 var (
-	ErrDuplicateEmail = errors.New("duplicate email")
-	AnonymousUser     = &User{}
+	ErrDuplicateEmail   = errors.New("duplicate email")
+	ErrInvalidResetCode = errors.New("invalid or expired reset code")
+	AnonymousUser       = &User{}
 )
 
 type User struct {
@@ -32,11 +36,14 @@ type User struct {
 	Activated   bool      `json:"is_activated"`
 }
 
+// MaxResetCodeAttempts is how many wrong guesses a reset code survives.
+const MaxResetCodeAttempts = 5
+
 type PasswordResetCode struct {
 	ID        int64     `json:"id"`
 	User_ID   int64     `json:"user_id"`
-	Code      string    `json:"code"`
 	Email     string    `json:"email"`
+	Attempts  int       `json:"-"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
@@ -127,7 +134,7 @@ func (u UserModel) GetById(id int64) (*User, error) {
 	query := `
 	SELECT id, firstname, lastname, phone_number, email, password_hash, created_at, role_id, is_activated
 	FROM users
-	WHERE id = $1`
+	WHERE id = $1 AND deleted_at IS NULL`
 
 	var user User
 
@@ -160,7 +167,7 @@ func (u UserModel) GetByEmail(email string) (*User, error) {
 	query := `
 	SELECT id, firstname, lastname, phone_number, email, password_hash, created_at, role_id, is_activated
 	FROM users
-	WHERE email = $1`
+	WHERE email = $1 AND deleted_at IS NULL`
 
 	var user User
 
@@ -189,25 +196,95 @@ func (u UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// InsertPasswordResetCode stores a password reset code for a user.
-func (m UserModel) InsertPasswordResetCode(userID int64, code string, expiresAt time.Time) error {
-	query := `INSERT INTO password_reset_codes (user_id, code, expires_at) VALUES ($1, $2, $3)`
-	_, err := m.DB.Exec(query, userID, code, expiresAt)
-	return err
+// InsertPasswordResetCode stores the hash of a password reset code for the
+// user, replacing any code requested before.
+func (m UserModel) InsertPasswordResetCode(userID int64, email, code string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM password_reset_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO password_reset_codes (user_id, email, code_hash, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, query, userID, email, hashResetCode(code), expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// ValidatePasswordResetCode validates the reset code.
-func (m UserModel) ValidatePasswordResetCode(code string) (*PasswordResetCode, error) {
-	resetCode := &PasswordResetCode{}
-	query := `SELECT user_id, expires_at FROM password_reset_codes WHERE code = $1`
-	err := m.DB.QueryRow(query, code).Scan(&resetCode.User_ID, &resetCode.ExpiresAt)
+// CheckPasswordResetCode checks the code against the one requested for the
+// email. Every wrong guess is counted and the code is invalidated after
+// MaxResetCodeAttempts of them.
+func (m UserModel) CheckPasswordResetCode(email, code string) (*PasswordResetCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ErrRecordNotFound
-		}
 		return nil, err
 	}
-	return resetCode, nil
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, user_id, email, code_hash, attempts, expires_at
+		FROM password_reset_codes
+		WHERE lower(email) = lower($1)
+		ORDER BY id DESC
+		LIMIT 1
+		FOR UPDATE`
+
+	var (
+		resetCode PasswordResetCode
+		codeHash  []byte
+	)
+	err = tx.QueryRowContext(ctx, query, email).Scan(
+		&resetCode.ID,
+		&resetCode.User_ID,
+		&resetCode.Email,
+		&codeHash,
+		&resetCode.Attempts,
+		&resetCode.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrInvalidResetCode
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(resetCode.ExpiresAt) {
+		return nil, ErrInvalidResetCode
+	}
+
+	if subtle.ConstantTimeCompare(codeHash, hashResetCode(code)) != 1 {
+		resetCode.Attempts++
+		if resetCode.Attempts >= MaxResetCodeAttempts {
+			_, err = tx.ExecContext(ctx, `DELETE FROM password_reset_codes WHERE id = $1`, resetCode.ID)
+		} else {
+			_, err = tx.ExecContext(ctx, `UPDATE password_reset_codes SET attempts = $1 WHERE id = $2`, resetCode.Attempts, resetCode.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidResetCode
+	}
+
+	return &resetCode, tx.Commit()
 }
 
 // UpdateUserPassword updates a user's password.
@@ -217,22 +294,27 @@ func (m UserModel) UpdateUserPassword(userID int64, newPassword string) error {
 	return err
 }
 
-// DeletePasswordResetCode deletes a used password reset code.
-func (m UserModel) DeletePasswordResetCode(code string) error {
-	query := `DELETE FROM password_reset_codes WHERE code = $1`
-	_, err := m.DB.Exec(query, code)
+// DeletePasswordResetCode deletes a used password reset code, so that it
+// can't be used twice.
+func (m UserModel) DeletePasswordResetCode(id int64) error {
+	query := `DELETE FROM password_reset_codes WHERE id = $1`
+	_, err := m.DB.Exec(query, id)
 	return err
 }
 
 // GenerateResetCode generates a secure random reset code.
-func GenerateResetCode(user *User) (string, error) {
+func GenerateResetCode() (string, error) {
 	code := make([]byte, 6)
 	_, err := rand.Read(code)
 	if err != nil {
 		return "", err
 	}
-	encodedStr := hex.EncodeToString(code)
-	return fmt.Sprintf("%s%d", encodedStr, user.ID), nil
+	return hex.EncodeToString(code), nil
+}
+
+func hashResetCode(code string) []byte {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hash[:]
 }
 
 func (u UserModel) Update(user *User) error {
@@ -270,28 +352,34 @@ func (u UserModel) Update(user *User) error {
 	return nil
 }
 
+// Delete archives the user and ends their sessions. Archived users can not
+// sign in, but their past orders keep resolving them. Their email is free to
+// sign up again.
 func (u UserModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	query := `DELETE FROM users
-		WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	result, err := u.DB.Exec(query, id)
+	tx, err := u.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	err = archive(ctx, tx, "users", id)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	_, err = tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
 	}
-	return nil
+
+	return tx.Commit()
 }
 
 func (u UserModel) Init() error {
@@ -0,0 +1,277 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/dexciuq/yummy-express-backend/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateUPC = errors.New("duplicate upc")
+	// ErrLastVariant is returned for deleting the only variant of a product,
+	// which could no longer be sold without one.
+	ErrLastVariant = errors.New("last variant")
+)
+
+// ProductVariant is a sellable item of a product, e.g. the 1 L bottle of a
+// milk. It has its own UPC, price and stock. A zero DiscountID means the
+// variant takes the discount of its product. Archived variants are no longer
+// sold but still resolve by id for the orders that refer to them.
+type ProductVariant struct {
+	ID         int64      `json:"id"`
+	ProductID  int64      `json:"product_id"`
+	Name       string     `json:"name"`
+	UPC        string     `json:"upc"`
+	Price      int64      `json:"price"`
+	Quantity   float64    `json:"quantity"`
+	UnitID     int64      `json:"unit_id"`
+	Step       float64    `json:"step"`
+	DiscountID int64      `json:"discount_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Version    int        `json:"version"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+}
+
+type ProductVariantModel struct {
+	DB *sql.DB
+}
+
+func ValidateVariant(v *validator.Validator, variant *ProductVariant) {
+	validateVariant(v, "", variant)
+}
+
+// validateVariant checks the variant, prefixing the error keys so that the
+// variants of a new product can be told apart.
+func validateVariant(v *validator.Validator, prefix string, variant *ProductVariant) {
+	v.Check(len(variant.Name) <= 100, prefix+"name", "must not be more than 100 bytes long")
+	v.Check(len(variant.UPC) <= 50, prefix+"upc", "must not be more than 50 bytes long")
+	v.Check(variant.Price >= 0, prefix+"price", "can not be negative")
+	v.Check(variant.Quantity >= 0, prefix+"quantity", "can not be negative")
+	v.Check(variant.Step >= 0, prefix+"step", "can not be negative")
+}
+
+// EffectiveDiscountID returns the discount that applies to the variant: its
+// own, or else the one of its product.
+func (variant *ProductVariant) EffectiveDiscountID(product *Product) int64 {
+	if variant.DiscountID != 0 {
+		return variant.DiscountID
+	}
+	return product.DiscountID
+}
+
+// variantColumns are the columns scanned by scanVariant, in order.
+const variantColumns = `id, product_id, name, COALESCE(upc, ''), price, quantity, COALESCE(unit_id, 0), step, COALESCE(discount_id, 0), created_at, version, deleted_at`
+
+func scanVariant(row interface{ Scan(...any) error }, variant *ProductVariant) error {
+	return row.Scan(
+		&variant.ID,
+		&variant.ProductID,
+		&variant.Name,
+		&variant.UPC,
+		&variant.Price,
+		&variant.Quantity,
+		&variant.UnitID,
+		&variant.Step,
+		&variant.DiscountID,
+		&variant.CreatedAt,
+		&variant.Version,
+		&variant.DeletedAt,
+	)
+}
+
+// variantError turns a unique violation on the UPC into ErrDuplicateUPC.
+func variantError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "product_variants_upc_key" {
+		return ErrDuplicateUPC
+	}
+	return err
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// insertVariant adds the variant, on its own or within the transaction that
+// inserts its product.
+func insertVariant(ctx context.Context, db queryRower, variant *ProductVariant) error {
+	query := `
+	INSERT INTO product_variants (product_id, name, upc, price, quantity, unit_id, step, discount_id)
+	VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6, 0), $7, NULLIF($8, 0))
+	RETURNING id, created_at, version`
+
+	args := []any{
+		variant.ProductID,
+		variant.Name,
+		variant.UPC,
+		variant.Price,
+		variant.Quantity,
+		variant.UnitID,
+		variant.Step,
+		variant.DiscountID,
+	}
+
+	err := db.QueryRowContext(ctx, query, args...).Scan(&variant.ID, &variant.CreatedAt, &variant.Version)
+	return variantError(err)
+}
+
+func (m ProductVariantModel) Insert(variant *ProductVariant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertVariant(ctx, m.DB, variant)
+}
+
+func (m ProductVariantModel) Get(id int64) (*ProductVariant, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + variantColumns + ` FROM product_variants WHERE id = $1`
+
+	var variant ProductVariant
+	err := scanVariant(m.DB.QueryRow(query, id), &variant)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &variant, nil
+}
+
+func (m ProductVariantModel) GetByUPC(upc string) (*ProductVariant, error) {
+	if upc == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + variantColumns + ` FROM product_variants WHERE upc = $1 AND deleted_at IS NULL`
+
+	var variant ProductVariant
+	err := scanVariant(m.DB.QueryRow(query, upc), &variant)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &variant, nil
+}
+
+// GetAllForProduct returns the listed variants of the product, oldest first.
+func (m ProductVariantModel) GetAllForProduct(productID int64) ([]*ProductVariant, error) {
+	query := `SELECT ` + variantColumns + ` FROM product_variants WHERE product_id = $1 AND deleted_at IS NULL ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []*ProductVariant{}
+
+	for rows.Next() {
+		var variant ProductVariant
+		err := scanVariant(rows, &variant)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, &variant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+func (m ProductVariantModel) Update(variant *ProductVariant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return updateVariant(ctx, m.DB, variant)
+}
+
+// updateVariant saves the variant if it is still at the version it was read
+// at, and returns ErrEditConflict otherwise.
+func updateVariant(ctx context.Context, db queryRower, variant *ProductVariant) error {
+	query := `UPDATE product_variants
+	SET name = $1, upc = NULLIF($2, ''), price = $3, quantity = $4, unit_id = NULLIF($5, 0), step = $6, discount_id = NULLIF($7, 0), version = version + 1
+	WHERE id = $8 AND version = $9
+	RETURNING version`
+
+	args := []any{
+		variant.Name,
+		variant.UPC,
+		variant.Price,
+		variant.Quantity,
+		variant.UnitID,
+		variant.Step,
+		variant.DiscountID,
+		variant.ID,
+		variant.Version,
+	}
+
+	err := db.QueryRowContext(ctx, query, args...).Scan(&variant.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return variantError(err)
+		}
+	}
+	return nil
+}
+
+// Delete archives the variant. It returns ErrLastVariant for the only listed
+// variant of its product.
+func (m ProductVariantModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the product so that two of its variants can't be archived side by
+	// side, leaving it with none.
+	var siblings int
+	err = tx.QueryRowContext(ctx, `
+		SELECT (SELECT count(*) FROM product_variants siblings WHERE siblings.product_id = products.id AND siblings.deleted_at IS NULL)
+		FROM products
+		WHERE products.id = (SELECT product_id FROM product_variants WHERE id = $1 AND deleted_at IS NULL)
+		FOR UPDATE`, id).Scan(&siblings)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if siblings < 2 {
+		return ErrLastVariant
+	}
+
+	err = archive(ctx, tx, "product_variants", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
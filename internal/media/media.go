@@ -0,0 +1,169 @@
+// Package media checks uploaded images and renders the resized and WebP
+// versions the storefront serves.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrTooLarge        = errors.New("image dimensions too large")
+)
+
+// MaxDimension is the largest width or height of an accepted image. It keeps
+// a small, highly compressed upload from decoding into gigabytes of pixels.
+const MaxDimension = 8000
+
+// Size is a bounding box the image is scaled down to fit in.
+type Size struct {
+	Name string
+	Max  int
+}
+
+// Sizes are the resized versions rendered for every upload, besides the
+// original.
+var Sizes = []Size{
+	{Name: "thumb", Max: 200},
+	{Name: "medium", Max: 800},
+	{Name: "large", Max: 1600},
+}
+
+// contentTypes maps the accepted content types to file extensions.
+var contentTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/webp": "webp",
+}
+
+// Rendition is one version of an uploaded image.
+type Rendition struct {
+	Name        string
+	Ext         string
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// Process checks that raw is a JPEG, PNG or WebP image of acceptable
+// dimensions and renders its versions: the untouched original, then every
+// size in the source format and as WebP. The content type is sniffed from the
+// bytes; whatever the client claimed is ignored.
+func Process(raw []byte) ([]Rendition, error) {
+	contentType := http.DetectContentType(raw)
+	ext, ok := contentTypes[contentType]
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	renditions := []Rendition{{
+		Name:        "original",
+		Ext:         ext,
+		ContentType: contentType,
+		Width:       config.Width,
+		Height:      config.Height,
+		Data:        raw,
+	}}
+
+	for _, size := range Sizes {
+		img := resize(src, size.Max)
+		bounds := img.Bounds()
+
+		// WebP sources are only rendered as WebP.
+		if contentType != "image/webp" {
+			data, err := encode(img, contentType)
+			if err != nil {
+				return nil, err
+			}
+			renditions = append(renditions, Rendition{
+				Name:        size.Name,
+				Ext:         ext,
+				ContentType: contentType,
+				Width:       bounds.Dx(),
+				Height:      bounds.Dy(),
+				Data:        data,
+			})
+		}
+
+		data, err := encode(img, "image/webp")
+		if err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, Rendition{
+			Name:        size.Name + "_webp",
+			Ext:         "webp",
+			ContentType: "image/webp",
+			Width:       bounds.Dx(),
+			Height:      bounds.Dy(),
+			Data:        data,
+		})
+	}
+
+	return renditions, nil
+}
+
+// resize scales the image down to fit in a box by box square, keeping its
+// aspect ratio. Smaller images are returned as they are.
+func resize(src image.Image, box int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= box && height <= box {
+		return src
+	}
+
+	if width > height {
+		height = height * box / width
+		width = box
+	} else {
+		width = width * box / height
+		height = box
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, max(width, 1), max(height, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	case "image/png":
+		err = png.Encode(&buf, img)
+	case "image/webp":
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		err = ErrUnsupportedType
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,150 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+func testImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeWebP(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcess(t *testing.T) {
+	type rendition struct {
+		name, contentType string
+		width, height     int
+	}
+
+	tests := []struct {
+		name string
+		raw  []byte
+		want []rendition
+	}{
+		{
+			name: "png",
+			raw:  encodePNG(t, testImage(400, 100)),
+			want: []rendition{
+				{"original", "image/png", 400, 100},
+				{"thumb", "image/png", 200, 50},
+				{"thumb_webp", "image/webp", 200, 50},
+				{"medium", "image/png", 400, 100},
+				{"medium_webp", "image/webp", 400, 100},
+				{"large", "image/png", 400, 100},
+				{"large_webp", "image/webp", 400, 100},
+			},
+		},
+		{
+			name: "jpeg",
+			raw:  encodeJPEG(t, testImage(100, 1000)),
+			want: []rendition{
+				{"original", "image/jpeg", 100, 1000},
+				{"thumb", "image/jpeg", 20, 200},
+				{"thumb_webp", "image/webp", 20, 200},
+				{"medium", "image/jpeg", 80, 800},
+				{"medium_webp", "image/webp", 80, 800},
+				{"large", "image/jpeg", 100, 1000},
+				{"large_webp", "image/webp", 100, 1000},
+			},
+		},
+		{
+			name: "webp",
+			raw:  encodeWebP(t, testImage(300, 300)),
+			want: []rendition{
+				{"original", "image/webp", 300, 300},
+				{"thumb_webp", "image/webp", 200, 200},
+				{"medium_webp", "image/webp", 300, 300},
+				{"large_webp", "image/webp", 300, 300},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renditions, err := Process(tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(renditions) != len(tt.want) {
+				t.Fatalf("got %d renditions, want %d", len(renditions), len(tt.want))
+			}
+
+			for i, want := range tt.want {
+				r := renditions[i]
+				got := rendition{r.Name, r.ContentType, r.Width, r.Height}
+				if got != want {
+					t.Errorf("rendition %d = %+v, want %+v", i, got, want)
+				}
+
+				config, _, err := image.DecodeConfig(bytes.NewReader(r.Data))
+				if err != nil {
+					t.Errorf("rendition %s does not decode: %v", r.Name, err)
+					continue
+				}
+				if config.Width != want.width || config.Height != want.height {
+					t.Errorf("rendition %s decodes as %dx%d, want %dx%d", r.Name, config.Width, config.Height, want.width, want.height)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+		err  error
+	}{
+		{"text", []byte("not an image at all"), ErrUnsupportedType},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"), ErrUnsupportedType},
+		{"truncated png", encodePNG(t, testImage(10, 10))[:20], ErrUnsupportedType},
+		{"too wide", encodePNG(t, image.NewGray(image.Rect(0, 0, MaxDimension+1, 1))), ErrTooLarge},
+		{"too tall", encodePNG(t, image.NewGray(image.Rect(0, 0, 1, MaxDimension+1))), ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Process(tt.raw)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
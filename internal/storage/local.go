@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores objects as files below Dir. The API serves them itself under
+// BaseURL.
+type Local struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocal(dir, baseURL string) *Local {
+	return &Local{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(l.Dir, filepath.FromSlash(key))
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see half an image.
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, body)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filepath.Join(l.Dir, filepath.FromSlash(key)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return l.BaseURL + "/" + key
+}
@@ -0,0 +1,34 @@
+// Package storage keeps uploaded files, such as product images, behind a
+// common interface so the API does not care whether they end up on the
+// local disk or in an S3-compatible bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage saves and removes objects by key. Keys are slash separated paths
+// like "products/12/ab34/thumb.webp".
+type Storage interface {
+	// Put stores the object, replacing any object with the same key.
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Delete removes the object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL the object is served from.
+	URL(key string) string
+}
+
+// cleanKey rejects keys that are empty or try to leave the storage root.
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if cleaned == "" || cleaned != key || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
@@ -3,6 +3,9 @@ package validator
 import "regexp"
 
 var (
+	// CodeRX matches the codes that name things in query strings, e.g.
+	// "fat_content".
+	CodeRX  = regexp.MustCompile("^[a-z][a-z0-9_]*$")
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
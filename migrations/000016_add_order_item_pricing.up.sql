@@ -0,0 +1,7 @@
+ALTER TABLE order_items
+    ADD COLUMN IF NOT EXISTS price bigint not null default 0,
+    ADD COLUMN IF NOT EXISTS discount_percent int not null default 0;
+
+UPDATE order_items
+SET price = FLOOR(total / quantity)
+WHERE quantity > 0;
@@ -0,0 +1,2 @@
+ALTER TABLE products
+    ALTER COLUMN quantity TYPE double precision;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id bigserial PRIMARY KEY,
+    order_id bigint not null references orders(id) ON DELETE CASCADE,
+    from_status_id bigint references statuses(id),
+    to_status_id bigint not null references statuses(id),
+    changed_by bigint references users(id) ON DELETE SET NULL,
+    note text not null default '',
+    created_at timestamp(0) with time zone not null default NOW()
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_id_idx ON order_status_history (order_id);
+
+INSERT INTO order_status_history (order_id, to_status_id, changed_by, created_at)
+SELECT id, status_id, user_id, created_at
+FROM orders
+WHERE status_id IS NOT NULL;
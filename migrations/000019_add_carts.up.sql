@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS carts (
+    id bigserial PRIMARY KEY,
+    user_id bigint UNIQUE references users(id) ON DELETE CASCADE,
+    token_hash bytea UNIQUE,
+    created_at timestamp(0) with time zone not null default NOW(),
+    updated_at timestamp(0) with time zone not null default NOW(),
+    CHECK (user_id IS NOT NULL OR token_hash IS NOT NULL)
+);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+    id bigserial PRIMARY KEY,
+    cart_id bigint not null references carts(id) ON DELETE CASCADE,
+    product_id bigint not null references products(id) ON DELETE CASCADE,
+    quantity double precision not null,
+    created_at timestamp(0) with time zone not null default NOW(),
+    UNIQUE (cart_id, product_id)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code varchar(50) not null UNIQUE,
+    description text not null
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id bigint not null references roles(id) ON DELETE CASCADE,
+    permission_id bigint not null references permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
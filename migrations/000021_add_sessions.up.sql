@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id bigserial PRIMARY KEY,
+    user_id bigint not null references users(id) ON DELETE CASCADE,
+    token_hash bytea not null,
+    user_agent text not null default '',
+    ip text not null default '',
+    created_at timestamp(0) with time zone not null default NOW(),
+    last_used_at timestamp(0) with time zone not null default NOW(),
+    expires_at timestamp(0) with time zone not null,
+    revoked_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+DROP TABLE IF EXISTS tokens;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id bigserial PRIMARY KEY,
+    kid text not null UNIQUE,
+    algorithm text not null,
+    private_key bytea not null,
+    created_at timestamp(0) with time zone not null default NOW(),
+    retired_at timestamp(0) with time zone
+);
+
+-- Tokens signed with the shared secrets can no longer be verified.
+UPDATE sessions SET revoked_at = NOW() WHERE revoked_at IS NULL;
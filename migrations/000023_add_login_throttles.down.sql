@@ -0,0 +1,9 @@
+DELETE FROM password_reset_codes;
+
+ALTER TABLE password_reset_codes DROP COLUMN attempts;
+ALTER TABLE password_reset_codes DROP COLUMN email;
+ALTER TABLE password_reset_codes DROP COLUMN code_hash;
+ALTER TABLE password_reset_codes ADD COLUMN code VARCHAR(12) NOT NULL UNIQUE;
+
+DROP TABLE IF EXISTS account_unlock_tokens;
+DROP TABLE IF EXISTS login_throttles;
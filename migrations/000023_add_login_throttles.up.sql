@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS login_throttles (
+    scope text not null,
+    key text not null,
+    failures integer not null default 0,
+    last_failed_at timestamp(0) with time zone not null default NOW(),
+    locked_until timestamp(0) with time zone,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE TABLE IF NOT EXISTS account_unlock_tokens (
+    token_hash bytea PRIMARY KEY,
+    user_id bigint not null references users(id) ON DELETE CASCADE,
+    expires_at timestamp(0) with time zone not null
+);
+
+-- Plaintext codes can't be converted, the users will have to request new ones.
+DELETE FROM password_reset_codes;
+
+ALTER TABLE password_reset_codes DROP COLUMN code;
+ALTER TABLE password_reset_codes ADD COLUMN code_hash bytea not null UNIQUE;
+ALTER TABLE password_reset_codes ADD COLUMN email text not null;
+ALTER TABLE password_reset_codes ADD COLUMN attempts integer not null default 0;
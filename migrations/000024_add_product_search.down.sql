@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS products_search_vector_idx;
+CREATE INDEX IF NOT EXISTS products_names_idx ON products USING GIN (to_tsvector('simple', name));
+
+DROP TRIGGER IF EXISTS categories_search_vector_trigger ON categories;
+DROP TRIGGER IF EXISTS brands_search_vector_trigger ON brands;
+DROP TRIGGER IF EXISTS products_search_vector_trigger ON products;
+DROP FUNCTION IF EXISTS products_search_vector_touch();
+DROP FUNCTION IF EXISTS products_search_vector_update();
+
+ALTER TABLE products DROP COLUMN IF EXISTS search_vector;
+
+DROP FUNCTION IF EXISTS translit_latin(text);
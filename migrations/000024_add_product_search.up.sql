@@ -0,0 +1,70 @@
+-- translit_latin transliterates Russian and Kazakh Cyrillic into the Latin
+-- spelling customers type on a Latin keyboard. ToLatin in internal/data
+-- must stay in sync with it.
+CREATE OR REPLACE FUNCTION translit_latin(input text) RETURNS text
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT translate(
+        replace(replace(replace(replace(replace(replace(replace(lower(input),
+            'щ', 'sch'), 'ж', 'zh'), 'ч', 'ch'), 'ш', 'sh'), 'ю', 'yu'), 'я', 'ya'), 'ц', 'ts'),
+        'абвгдеёзийклмнопрстуфхыэәғқңөұүһіъь',
+        'abvgdeeziyklmnoprstufhyeagqnouuhi'
+    )
+$$;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+-- The name weighs most, then the brand and category, then the description.
+-- Names are indexed with the russian configuration, which also stems English
+-- words, and once more transliterated with the simple one.
+CREATE OR REPLACE FUNCTION products_search_vector_update() RETURNS trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+    brand_name text;
+    category_name text;
+BEGIN
+    SELECT name INTO brand_name FROM brands WHERE id = NEW.brand_id;
+    SELECT name INTO category_name FROM categories WHERE id = NEW.category_id;
+
+    NEW.search_vector :=
+        setweight(to_tsvector('russian', coalesce(NEW.name, '')), 'A') ||
+        setweight(to_tsvector('simple', translit_latin(coalesce(NEW.name, ''))), 'A') ||
+        setweight(to_tsvector('russian', coalesce(brand_name, '')), 'B') ||
+        setweight(to_tsvector('simple', translit_latin(coalesce(brand_name, ''))), 'B') ||
+        setweight(to_tsvector('russian', coalesce(category_name, '')), 'B') ||
+        setweight(to_tsvector('simple', translit_latin(coalesce(category_name, ''))), 'B') ||
+        setweight(to_tsvector('russian', coalesce(NEW.description, '')), 'C');
+    RETURN NEW;
+END
+$$;
+
+CREATE TRIGGER products_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF name, description, brand_id, category_id ON products
+    FOR EACH ROW EXECUTE FUNCTION products_search_vector_update();
+
+-- Renaming a brand or category re-indexes its products.
+CREATE OR REPLACE FUNCTION products_search_vector_touch() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'brands' THEN
+        UPDATE products SET name = name WHERE brand_id = NEW.id;
+    ELSE
+        UPDATE products SET name = name WHERE category_id = NEW.id;
+    END IF;
+    RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER brands_search_vector_trigger
+    AFTER UPDATE OF name ON brands
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION products_search_vector_touch();
+
+CREATE TRIGGER categories_search_vector_trigger
+    AFTER UPDATE OF name ON categories
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION products_search_vector_touch();
+
+UPDATE products SET name = name;
+
+DROP INDEX IF EXISTS products_names_idx;
+CREATE INDEX IF NOT EXISTS products_search_vector_idx ON products USING GIN (search_vector);
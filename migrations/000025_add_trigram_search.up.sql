@@ -0,0 +1,7 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Names are compared transliterated to Latin, so that Cyrillic and Latin
+-- spellings of the same word are close to each other.
+CREATE INDEX IF NOT EXISTS products_name_trgm_idx ON products USING GIN (translit_latin(name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS categories_name_trgm_idx ON categories USING GIN (translit_latin(name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS brands_name_trgm_idx ON brands USING GIN (translit_latin(name) gin_trgm_ops);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS products_created_at_idx;
+DROP INDEX IF EXISTS order_items_product_id_idx;
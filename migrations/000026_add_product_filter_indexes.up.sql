@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS order_items_product_id_idx ON order_items (product_id);
+CREATE INDEX IF NOT EXISTS products_created_at_idx ON products (created_at);
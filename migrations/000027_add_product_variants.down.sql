@@ -0,0 +1,29 @@
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS price bigint,
+    ADD COLUMN IF NOT EXISTS upc character varying,
+    ADD COLUMN IF NOT EXISTS quantity double precision,
+    ADD COLUMN IF NOT EXISTS unit_id bigint references units(id),
+    ADD COLUMN IF NOT EXISTS step double precision;
+
+-- Products get the values of their first variant back.
+UPDATE products
+SET price = first.price, upc = first.upc, quantity = first.quantity, unit_id = first.unit_id, step = first.step
+FROM (
+    SELECT DISTINCT ON (product_id) product_id, price, upc, quantity, unit_id, step
+    FROM product_variants
+    ORDER BY product_id, id
+) first
+WHERE first.product_id = products.id;
+
+DELETE FROM cart_items a
+USING cart_items b
+WHERE a.cart_id = b.cart_id AND a.product_id = b.product_id AND a.id > b.id;
+
+ALTER TABLE cart_items
+    DROP CONSTRAINT IF EXISTS cart_items_cart_id_variant_id_key,
+    ADD CONSTRAINT cart_items_cart_id_product_id_key UNIQUE (cart_id, product_id),
+    DROP COLUMN IF EXISTS variant_id;
+
+ALTER TABLE order_items DROP COLUMN IF EXISTS variant_id;
+
+DROP TABLE IF EXISTS product_variants;
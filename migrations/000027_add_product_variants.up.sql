@@ -0,0 +1,50 @@
+CREATE TABLE IF NOT EXISTS product_variants (
+    id bigserial PRIMARY KEY,
+    product_id bigint not null references products(id) ON DELETE CASCADE,
+    "name" varchar(100) not null default '',
+    upc varchar UNIQUE,
+    price bigint not null,
+    quantity double precision not null default 0,
+    unit_id bigint references units(id),
+    step double precision not null default 1,
+    -- A variant without a discount of its own takes the discount of its product.
+    discount_id bigint references discounts(id),
+    created_at timestamp(0) with time zone not null default NOW()
+);
+
+CREATE INDEX IF NOT EXISTS product_variants_product_id_idx ON product_variants (product_id);
+
+-- Every existing product becomes a parent with a single variant. Duplicate
+-- UPCs are kept on the oldest product only.
+INSERT INTO product_variants (product_id, upc, price, quantity, unit_id, step, created_at)
+SELECT id,
+    CASE WHEN row_number() OVER (PARTITION BY upc ORDER BY id) = 1 THEN NULLIF(upc, '') END,
+    COALESCE(price, 0), COALESCE(quantity, 0), unit_id, COALESCE(step, 1), created_at
+FROM products
+ORDER BY id;
+
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS variant_id bigint references product_variants(id);
+
+UPDATE order_items
+SET variant_id = product_variants.id
+FROM product_variants
+WHERE product_variants.product_id = order_items.product_id;
+
+ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS variant_id bigint references product_variants(id) ON DELETE CASCADE;
+
+UPDATE cart_items
+SET variant_id = product_variants.id
+FROM product_variants
+WHERE product_variants.product_id = cart_items.product_id;
+
+ALTER TABLE cart_items
+    ALTER COLUMN variant_id SET NOT NULL,
+    DROP CONSTRAINT IF EXISTS cart_items_cart_id_product_id_key,
+    ADD CONSTRAINT cart_items_cart_id_variant_id_key UNIQUE (cart_id, variant_id);
+
+ALTER TABLE products
+    DROP COLUMN IF EXISTS price,
+    DROP COLUMN IF EXISTS upc,
+    DROP COLUMN IF EXISTS quantity,
+    DROP COLUMN IF EXISTS unit_id,
+    DROP COLUMN IF EXISTS step;
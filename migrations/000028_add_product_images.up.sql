@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS product_images (
+    id bigserial PRIMARY KEY,
+    product_id bigint not null references products(id) ON DELETE CASCADE,
+    position integer not null default 0,
+    is_primary boolean not null default false,
+    url text not null,
+    -- Rendition name to URL, e.g. {"thumb_webp": "https://..."}.
+    renditions jsonb not null default '{}',
+    -- Storage keys of every rendition, removed with the image.
+    storage_keys text[] not null default '{}',
+    created_at timestamp(0) with time zone not null default NOW()
+);
+
+CREATE INDEX IF NOT EXISTS product_images_product_id_idx ON product_images (product_id, position);
+CREATE UNIQUE INDEX IF NOT EXISTS product_images_primary_idx ON product_images (product_id) WHERE is_primary;
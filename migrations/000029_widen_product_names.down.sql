@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS products_search_vector_trigger ON products;
+
+ALTER TABLE products ALTER COLUMN "name" TYPE varchar(20) USING left("name", 20);
+
+CREATE TRIGGER products_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF name, description, brand_id, category_id ON products
+    FOR EACH ROW EXECUTE FUNCTION products_search_vector_update();
@@ -0,0 +1,10 @@
+-- ValidateProduct allows names of up to 100 bytes, as imported catalogs need.
+-- The search trigger depends on the column and has to be recreated around
+-- the change.
+DROP TRIGGER IF EXISTS products_search_vector_trigger ON products;
+
+ALTER TABLE products ALTER COLUMN "name" TYPE varchar(100);
+
+CREATE TRIGGER products_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF name, description, brand_id, category_id ON products
+    FOR EACH ROW EXECUTE FUNCTION products_search_vector_update();
@@ -0,0 +1,23 @@
+DROP INDEX IF EXISTS orders_not_exported_idx;
+ALTER TABLE orders DROP COLUMN IF EXISTS exported_at;
+
+DROP TRIGGER IF EXISTS brands_search_vector_trigger ON brands;
+DROP TRIGGER IF EXISTS categories_search_vector_trigger ON categories;
+
+ALTER TABLE categories ALTER COLUMN "name" TYPE varchar(20) USING left("name", 20);
+ALTER TABLE brands ALTER COLUMN "name" TYPE varchar(20) USING left("name", 20);
+ALTER TABLE units ALTER COLUMN "name" TYPE varchar(20) USING left("name", 20);
+
+CREATE TRIGGER brands_search_vector_trigger
+    AFTER UPDATE OF name ON brands
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION products_search_vector_touch();
+
+CREATE TRIGGER categories_search_vector_trigger
+    AFTER UPDATE OF name ON categories
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION products_search_vector_touch();
+
+ALTER TABLE categories DROP COLUMN IF EXISTS external_id;
+ALTER TABLE product_variants DROP COLUMN IF EXISTS external_id;
+ALTER TABLE products DROP COLUMN IF EXISTS external_id;
@@ -0,0 +1,29 @@
+-- Ids of the accounting system (1C) the catalog is exchanged with.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS external_id varchar(100) UNIQUE;
+ALTER TABLE product_variants ADD COLUMN IF NOT EXISTS external_id varchar(100) UNIQUE;
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS external_id varchar(100) UNIQUE;
+
+-- Product groups and manufacturers in 1C often have longer names than the
+-- reference tables allowed. The search triggers depend on the names and have
+-- to be recreated around the change.
+DROP TRIGGER IF EXISTS brands_search_vector_trigger ON brands;
+DROP TRIGGER IF EXISTS categories_search_vector_trigger ON categories;
+
+ALTER TABLE categories ALTER COLUMN "name" TYPE varchar(100);
+ALTER TABLE brands ALTER COLUMN "name" TYPE varchar(100);
+ALTER TABLE units ALTER COLUMN "name" TYPE varchar(100);
+
+CREATE TRIGGER brands_search_vector_trigger
+    AFTER UPDATE OF name ON brands
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION products_search_vector_touch();
+
+CREATE TRIGGER categories_search_vector_trigger
+    AFTER UPDATE OF name ON categories
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION products_search_vector_touch();
+
+-- Orders are handed to 1C once; exported_at records when it confirmed them.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS exported_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS orders_not_exported_idx ON orders (id) WHERE exported_at IS NULL;
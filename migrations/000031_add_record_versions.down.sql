@@ -0,0 +1,5 @@
+ALTER TABLE categories DROP COLUMN IF EXISTS version;
+ALTER TABLE discounts DROP COLUMN IF EXISTS version;
+ALTER TABLE orders DROP COLUMN IF EXISTS version;
+ALTER TABLE product_variants DROP COLUMN IF EXISTS version;
+ALTER TABLE products DROP COLUMN IF EXISTS version;
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS users_archived_idx;
+DROP INDEX IF EXISTS products_archived_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE discounts DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE countries DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE units DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE brands DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE categories DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE products DROP COLUMN IF EXISTS deleted_at;
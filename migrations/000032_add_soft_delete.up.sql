@@ -0,0 +1,13 @@
+-- Rows referenced by past orders are archived instead of deleted. Archived
+-- rows are hidden from listings; the purge command deletes the ones past the
+-- retention window that nothing refers to anymore.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+ALTER TABLE brands ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+ALTER TABLE units ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+ALTER TABLE countries ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+ALTER TABLE discounts ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS products_archived_idx ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS users_archived_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
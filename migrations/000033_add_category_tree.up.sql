@@ -0,0 +1,5 @@
+-- Categories form a tree. A root category has no parent; the application
+-- keeps the tree free of cycles when a category is moved.
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id bigint REFERENCES categories ON DELETE RESTRICT;
+ALTER TABLE categories ADD CONSTRAINT categories_parent_id_check CHECK (parent_id <> id);
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
@@ -0,0 +1,25 @@
+-- Typed product attributes, e.g. "vegan" (bool), "certification" (enum),
+-- "fat_content" (number) or "origin" (text). A value fills the column of its
+-- attribute's type; enum values are kept as text.
+CREATE TABLE IF NOT EXISTS attributes (
+    id bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE,
+    name text NOT NULL,
+    type text NOT NULL CHECK (type IN ('bool', 'enum', 'number', 'text')),
+    options text[] NOT NULL DEFAULT '{}',
+    unit text NOT NULL DEFAULT '',
+    filterable boolean NOT NULL DEFAULT false,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS product_attributes (
+    product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+    attribute_id bigint NOT NULL REFERENCES attributes ON DELETE CASCADE,
+    bool_value boolean,
+    number_value double precision,
+    text_value text,
+    PRIMARY KEY (product_id, attribute_id)
+);
+
+CREATE INDEX IF NOT EXISTS product_attributes_attribute_id_idx ON product_attributes (attribute_id);
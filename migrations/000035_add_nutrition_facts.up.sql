@@ -0,0 +1,18 @@
+-- Allergens are the codes of the EU 14 allergens a product contains. NULL
+-- means they have not been declared yet, which is not the same as none.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS allergens text[];
+
+-- Nutrition facts per 100 g, or per 100 ml for liquids. Energy is in kJ and
+-- kcal, everything else in grams.
+CREATE TABLE IF NOT EXISTS product_nutrition (
+    product_id bigint PRIMARY KEY REFERENCES products ON DELETE CASCADE,
+    per text NOT NULL CHECK (per IN ('100g', '100ml')),
+    energy_kj double precision NOT NULL,
+    energy_kcal double precision NOT NULL,
+    fat double precision NOT NULL,
+    saturates double precision NOT NULL,
+    carbohydrates double precision NOT NULL,
+    sugars double precision NOT NULL,
+    protein double precision NOT NULL,
+    salt double precision NOT NULL
+);
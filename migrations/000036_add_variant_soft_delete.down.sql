@@ -0,0 +1,7 @@
+-- Archived variants give up their UPCs to the listed ones.
+UPDATE product_variants SET upc = NULL WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS product_variants_upc_key;
+ALTER TABLE product_variants ADD CONSTRAINT product_variants_upc_key UNIQUE (upc);
+
+ALTER TABLE product_variants DROP COLUMN IF EXISTS deleted_at;
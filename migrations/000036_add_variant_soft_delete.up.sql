@@ -0,0 +1,7 @@
+-- Variants are archived like their products, since past orders refer to them.
+-- Only listed variants need a unique UPC, so that the UPC of an archived
+-- variant can be given to a new one.
+ALTER TABLE product_variants ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+ALTER TABLE product_variants DROP CONSTRAINT IF EXISTS product_variants_upc_key;
+CREATE UNIQUE INDEX IF NOT EXISTS product_variants_upc_key ON product_variants (upc) WHERE deleted_at IS NULL;
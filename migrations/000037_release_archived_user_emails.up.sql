@@ -0,0 +1,4 @@
+-- Only listed users need a unique email, so that the address of an archived
+-- user can sign up again.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email) WHERE deleted_at IS NULL;
@@ -0,0 +1,14 @@
+-- The statuses the order workflow is built on are known by a code, which stays
+-- put when they are renamed. Statuses added by the staff have none. The seeded
+-- statuses got the first ids, which is what the workflow relied on before.
+ALTER TABLE statuses ADD COLUMN IF NOT EXISTS code varchar(20) UNIQUE;
+
+UPDATE statuses
+SET code = CASE id
+    WHEN 1 THEN 'ordered'
+    WHEN 2 THEN 'processing'
+    WHEN 3 THEN 'shipped'
+    WHEN 4 THEN 'delivered'
+    WHEN 5 THEN 'cancelled'
+END
+WHERE id BETWEEN 1 AND 5 AND code IS NULL;
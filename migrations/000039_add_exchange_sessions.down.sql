@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS exchange_sale_queries;
+DROP TABLE IF EXISTS exchange_files;
@@ -0,0 +1,17 @@
+-- What 1C leaves with the API between the steps of an exchange session: the
+-- files it uploads, until they are imported, and the orders of its last sale
+-- query, until it confirms them. They live in the database so that any
+-- instance of the API can serve the next step.
+CREATE TABLE IF NOT EXISTS exchange_files (
+    session_id bigint not null references sessions(id) ON DELETE CASCADE,
+    "name" text not null,
+    content bytea not null,
+    updated_at timestamp(0) with time zone not null default NOW(),
+    PRIMARY KEY (session_id, "name")
+);
+
+CREATE TABLE IF NOT EXISTS exchange_sale_queries (
+    session_id bigint PRIMARY KEY references sessions(id) ON DELETE CASCADE,
+    order_ids bigint[] not null,
+    updated_at timestamp(0) with time zone not null default NOW()
+);
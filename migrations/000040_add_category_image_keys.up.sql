@@ -0,0 +1,3 @@
+-- The storage keys of the files of an uploaded category image, so that they
+-- can be deleted when the image is replaced or the category purged.
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS image_keys text[] NOT NULL DEFAULT '{}';